@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	sloggin "github.com/samber/slog-gin"
+)
+
+// logUpstreamAttempts logs every upstream attempt a request makes, retries,
+// failover and key switches included, for auditing quota usage.
+var logUpstreamAttempts = os.Getenv("UPSTREAM_ATTEMPT_LOG") == "true"
+
+type upstreamAttemptsKey struct{}
+
+// attemptCounter numbers the upstream attempts of one request.
+type attemptCounter struct {
+	requestID string
+	n         atomic.Int32
+}
+
+// upstreamAttempts gives each request an attempt counter, so its attempt log
+// lines are numbered and carry its request id.
+func upstreamAttempts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		counter := &attemptCounter{requestID: sloggin.GetRequestID(c)}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), upstreamAttemptsKey{}, counter))
+		c.Next()
+	}
+}
+
+// logAttempt writes the attempt log line for one upstream call to base. The
+// base URL never carries the api key, and errors are redacted, since a
+// transport error quotes the full request URL. Calls outside a request, such
+// as background refreshes, are logged without a number.
+func (h *httpMMRClient) logAttempt(ctx context.Context, base, region string, res *http.Response, err error, elapsed time.Duration) {
+	if !logUpstreamAttempts {
+		return
+	}
+	attrs := []any{
+		slog.String("base_url", base),
+		slog.String("region", region),
+		slog.Int64("latency_ms", elapsed.Milliseconds()),
+	}
+	if counter, ok := ctx.Value(upstreamAttemptsKey{}).(*attemptCounter); ok {
+		attrs = append(attrs,
+			slog.String("request_id", counter.requestID),
+			slog.Int("attempt", int(counter.n.Add(1))),
+		)
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("outcome", "error"), slog.String("error", redact(err.Error(), h.apiKey)))
+	} else {
+		attrs = append(attrs, slog.String("outcome", "response"), slog.Int("status", res.StatusCode))
+	}
+	h.logger.Info("Upstream attempt", attrs...)
+}
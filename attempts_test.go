@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// attemptLine is the part of an upstream attempt log line the tests check.
+type attemptLine struct {
+	Msg     string
+	BaseURL string `json:"base_url"`
+	Region  string
+	Attempt int
+	Outcome string
+	Status  int
+	Error   string
+}
+
+func TestUpstreamAttemptLog(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	tests := []struct {
+		name    string
+		enabled bool
+		// statuses are answered by the primary in turn, the last repeating.
+		statuses    []int
+		unreachable bool
+		fallback    bool
+		// want are the logged attempts as base URL ("primary", "fallback"
+		// or "down"), outcome and status.
+		want []attemptLine
+	}{
+		{"retried", true, []int{http.StatusBadGateway, http.StatusOK}, false, false, []attemptLine{
+			{BaseURL: "primary", Attempt: 1, Outcome: "response", Status: http.StatusBadGateway},
+			{BaseURL: "primary", Attempt: 2, Outcome: "response", Status: http.StatusOK},
+		}},
+		{"failover", true, []int{http.StatusBadGateway}, false, true, []attemptLine{
+			{BaseURL: "primary", Attempt: 1, Outcome: "response", Status: http.StatusBadGateway},
+			{BaseURL: "fallback", Attempt: 2, Outcome: "response", Status: http.StatusOK},
+		}},
+		{"connection error", true, nil, true, true, []attemptLine{
+			{BaseURL: "down", Attempt: 1, Outcome: "error"},
+			{BaseURL: "fallback", Attempt: 2, Outcome: "response", Status: http.StatusOK},
+		}},
+		{"single attempt", true, []int{http.StatusOK}, false, false, []attemptLine{
+			{BaseURL: "primary", Attempt: 1, Outcome: "response", Status: http.StatusOK},
+		}},
+		{"disabled", false, []int{http.StatusBadGateway, http.StatusOK}, false, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &logUpstreamAttempts, tt.enabled)
+			setVar(t, &retryBackoff, time.Millisecond)
+			if tt.fallback {
+				setVar(t, &maxUpstreamRetries, 0)
+			}
+			var calls atomic.Int64
+			cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				n := int(calls.Add(1))
+				writeJSON(w, tt.statuses[min(n, len(tt.statuses))-1], mmrBody)
+			})
+			cfg.APIKey = "secret-key"
+			names := map[string]string{cfg.UpstreamBaseURL: "primary", down.URL: "down"}
+			if tt.unreachable {
+				cfg.UpstreamBaseURL = down.URL
+			}
+			if tt.fallback {
+				fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(w, http.StatusOK, mmrBody)
+				}))
+				t.Cleanup(fallback.Close)
+				cfg.UpstreamFallbackURL = fallback.URL
+				names[fallback.URL] = "fallback"
+			}
+			var logs bytes.Buffer
+			client := newHTTPMMRClient(cfg, slog.New(slog.NewJSONHandler(&logs, nil)))
+			h := NewServer(cfg, discardLogger(), client).Handler()
+
+			if w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", ""); w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			if strings.Contains(logs.String(), "secret-key") {
+				t.Errorf("attempt log contains the api key: %s", logs.String())
+			}
+
+			var got []attemptLine
+			dec := json.NewDecoder(&logs)
+			for dec.More() {
+				var line attemptLine
+				if err := dec.Decode(&line); err != nil {
+					t.Fatal(err)
+				}
+				if line.Msg != "Upstream attempt" {
+					continue
+				}
+				if line.Region != "eu" || (line.Outcome == "error") != (line.Error != "") {
+					t.Errorf("attempt line %+v, want region eu and an error only on failure", line)
+				}
+				got = append(got, attemptLine{BaseURL: names[line.BaseURL], Attempt: line.Attempt, Outcome: line.Outcome, Status: line.Status})
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("logged attempts %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("attempt %d = %+v, want %+v", i+1, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
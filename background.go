@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// backgroundGroup runs work that outlives the request that started it. Every
+// task gets a context derived from one root context, which stop cancels
+// before waiting for the tasks to return, so shutdown never leaves
+// background goroutines behind.
+type backgroundGroup struct {
+	mu      sync.Mutex
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	stopped bool
+}
+
+func newBackgroundGroup() *backgroundGroup {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &backgroundGroup{ctx: ctx, cancel: cancel}
+}
+
+// goFunc runs fn in a new goroutine with the group's context. It reports false
+// without running fn once stop has been called.
+func (g *backgroundGroup) goFunc(fn func(ctx context.Context)) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopped {
+		return false
+	}
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		fn(g.ctx)
+	}()
+	return true
+}
+
+// stop cancels every task's context and blocks until all of them returned.
+func (g *backgroundGroup) stop() {
+	g.mu.Lock()
+	g.stopped = true
+	g.cancel()
+	g.mu.Unlock()
+	g.wg.Wait()
+}
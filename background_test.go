@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackgroundGroupStop(t *testing.T) {
+	g := newBackgroundGroup()
+	const tasks = 8
+	var running, exited atomic.Int64
+	for range tasks {
+		if !g.goFunc(func(ctx context.Context) {
+			running.Add(1)
+			<-ctx.Done()
+			exited.Add(1)
+		}) {
+			t.Fatal("goFunc() refused a task before stop")
+		}
+	}
+	for running.Load() < tasks {
+		time.Sleep(time.Millisecond)
+	}
+
+	g.stop()
+	if n := exited.Load(); n != tasks {
+		t.Errorf("stop() returned with %d of %d tasks exited", n, tasks)
+	}
+	if g.goFunc(func(context.Context) { t.Error("a task ran after stop") }) {
+		t.Error("goFunc() accepted a task after stop")
+	}
+}
+
+func TestStopCancelsBackgroundRefresh(t *testing.T) {
+	setVar(t, &staleTTL, time.Minute)
+	setVar(t, &cacheTTLJitter, 0)
+	started := make(chan struct{}, 1)
+	var cancelled atomic.Bool
+	cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-r.Context().Done()
+		cancelled.Store(true)
+	})
+	s := newHTTPServer(t, cfg)
+	clock := newFakeClock()
+	s.now = clock.now
+	s.cache.set(mmrCacheKey("eu", "foo", "bar", false), rankData(15, "Platinum 1", 45, "Diamond 2"))
+	clock.advance(cfg.CacheTTL + time.Second)
+
+	// The stale answer leaves a refresh hanging on upstream.
+	if w := serve(s.Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar", ""); w.Header().Get("X-Cache") != cacheStale {
+		t.Fatalf("X-Cache = %q, want a stale answer", w.Header().Get("X-Cache"))
+	}
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		s.background.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop() did not return with a refresh in flight")
+	}
+	if _, busy := s.refreshing.Load(mmrCacheKey("eu", "foo", "bar", false)); busy {
+		t.Error("the refresh is still marked in flight after stop")
+	}
+	// The server side notices the cancelled request asynchronously.
+	for deadline := time.Now().Add(time.Second); !cancelled.Load() && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if !cancelled.Load() {
+		t.Error("the upstream request was not cancelled")
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// maxBatchSize caps how many players a single batch request may ask for.
+	maxBatchSize = envInt("BATCH_MAX_SIZE", 25)
+	// batchQuotaLimit caps the cumulative number of player lookups a client
+	// may make through the batch endpoint per batchQuotaWindow.
+	batchQuotaLimit  = envInt("BATCH_QUOTA", 1000)
+	batchQuotaWindow = envDuration("BATCH_QUOTA_WINDOW", time.Hour)
+	// batchConcurrency caps the player lookups in flight across all batch
+	// requests, so batches cannot crowd out single lookups upstream. Zero
+	// means no limit.
+	batchConcurrency = envInt("BATCH_MAX_CONCURRENCY", 20)
+)
+
+// semaphore bounds concurrent work. A nil semaphore never blocks.
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+// acquire takes a slot, giving up when ctx is done first.
+func (s semaphore) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+type batchPlayer struct {
+	Region string `json:"region"`
+	Name   string `json:"name"`
+	Tag    string `json:"tag"`
+}
+
+type batchResult struct {
+	batchPlayer
+	Rank        string `json:"rank,omitempty"`
+	Tier        int    `json:"tier,omitempty"`
+	RR          *int   `json:"rr,omitempty"`
+	HighestRank string `json:"highest_rank,omitempty"`
+	RankValue   int    `json:"rank_value,omitempty"`
+	Cached      bool   `json:"cached,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// lookupPlayer resolves a single batch entry, reporting failures on the
+// result rather than failing the whole batch. The lookup waits for one of the
+// batch slots shared by every batch request.
+func (s *Server) lookupPlayer(ctx context.Context, p batchPlayer) batchResult {
+	result := batchResult{batchPlayer: p}
+
+	region, lerr := s.parseRegion(p.Region)
+	if lerr != nil {
+		result.Error = lerr.Error()
+		return result
+	}
+
+	name, tag, lerr := cleanPlayer(p.Name, p.Tag)
+	if lerr != nil {
+		result.Error = lerr.Error()
+		return result
+	}
+
+	if err := s.batchSlots.acquire(ctx); err != nil {
+		result.Error = "Timed out waiting for a lookup slot"
+		return result
+	}
+	lookup, lerr := s.lookupMMR(ctx, region, name, tag)
+	s.batchSlots.release()
+	if lerr != nil {
+		result.Error = lerr.Error()
+		return result
+	}
+
+	info, lerr := parseRank(lookup.data)
+	if lerr != nil {
+		result.Error = lerr.Error()
+		return result
+	}
+
+	rr := int(info.RR)
+	result.Rank = info.Rank
+	result.Tier = info.Tier
+	result.RR = &rr
+	result.HighestRank = info.HighestRank
+	result.RankValue = info.value()
+	result.Cached = lookup.cached()
+	return result
+}
+
+// lookupPlayers resolves every player concurrently, preserving input order.
+func (s *Server) lookupPlayers(ctx context.Context, players []batchPlayer) []batchResult {
+	results := make([]batchResult, len(players))
+
+	var wg sync.WaitGroup
+	for i, p := range players {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = s.lookupPlayer(ctx, p)
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// bindBatch parses and validates a batch request body and charges it against
+// the caller's quota. It writes the error response itself and returns false
+// when the request should not proceed.
+func (s *Server) bindBatch(c *gin.Context) ([]batchPlayer, bool) {
+	var req struct {
+		Players []batchPlayer `json:"players"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, codeInvalidBody, "Invalid request body"))
+		return nil, false
+	}
+
+	if len(req.Players) == 0 {
+		respondError(c, newAPIError(http.StatusBadRequest, codeInvalidBody, "No players given"))
+		return nil, false
+	}
+	if len(req.Players) > maxBatchSize {
+		respondError(c, newAPIError(http.StatusBadRequest, codeBatchTooLarge, fmt.Sprintf("Too many players, maximum is %d", maxBatchSize)))
+		return nil, false
+	}
+
+	if !s.batchQuota.allow(clientKey(c), len(req.Players)) {
+		respondError(c, newAPIError(http.StatusTooManyRequests, codeQuotaExceeded, "Batch lookup quota exceeded"))
+		return nil, false
+	}
+
+	return req.Players, true
+}
+
+// maxPathPlayers caps how many players GET /rank/:region/:names may ask for.
+const maxPathPlayers = 5
+
+// parsePathPlayers splits a comma separated list of name#tag pairs.
+func parsePathPlayers(region, list string) ([]batchPlayer, *apiError) {
+	pairs := strings.Split(list, ",")
+	if len(pairs) > maxPathPlayers {
+		return nil, newAPIError(http.StatusBadRequest, codeBatchTooLarge, fmt.Sprintf("Too many players, maximum is %d", maxPathPlayers))
+	}
+	players := make([]batchPlayer, 0, len(pairs))
+	for _, pair := range pairs {
+		name, tag, ok := strings.Cut(pair, "#")
+		if !ok || strings.TrimSpace(name) == "" || strings.TrimSpace(tag) == "" {
+			return nil, newAPIError(http.StatusBadRequest, codeInvalidPlayer, fmt.Sprintf("Invalid player %q, expected name#tag", pair))
+		}
+		players = append(players, batchPlayer{Region: region, Name: name, Tag: tag})
+	}
+	return players, nil
+}
+
+// writeResults writes batch results as JSON, or as CSV with ?format=csv. The
+// batch only counts as a cache hit when every player was served from cache.
+func writeResults(c *gin.Context, results []batchResult) {
+	source := sourceCache
+	for _, r := range results {
+		if !r.Cached {
+			source = sourceUpstream
+			break
+		}
+	}
+	setCacheHeader(c, source)
+
+	if c.Query("format") != "csv" {
+		c.JSON(http.StatusOK, gin.H{
+			"results": results,
+		})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="ranks.csv"`)
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"name", "tag", "region", "tier", "rr", "error"})
+	for _, r := range results {
+		// tier is the numeric tier, as in the JSON results; failed lookups
+		// leave it empty.
+		var tier, rr string
+		if r.Error == "" {
+			tier = strconv.Itoa(r.Tier)
+		}
+		if r.RR != nil {
+			rr = strconv.Itoa(*r.RR)
+		}
+		w.Write([]string{r.Name, r.Tag, r.Region, tier, rr, r.Error})
+	}
+	w.Flush()
+}
+
+// batchHandler looks up several players in one request.
+func (s *Server) batchHandler(c *gin.Context) {
+	players, ok := s.bindBatch(c)
+	if !ok {
+		return
+	}
+
+	writeResults(c, s.lookupPlayers(c.Request.Context(), players))
+}
+
+// topHandler looks up several players and returns them best ranked first.
+// Unranked players and failed lookups sort last, keeping their input order.
+func (s *Server) topHandler(c *gin.Context) {
+	players, ok := s.bindBatch(c)
+	if !ok {
+		return
+	}
+
+	results := s.lookupPlayers(c.Request.Context(), players)
+	slices.SortStableFunc(results, func(a, b batchResult) int {
+		return cmp.Compare(b.RankValue, a.RankValue)
+	})
+
+	writeResults(c, results)
+}
+
+// pathBatchHandler looks up the comma separated name#tag pairs of the path,
+// a GET form of batchHandler for a few players of one region. The #
+// must be sent as %23.
+func (s *Server) pathBatchHandler(c *gin.Context) {
+	region, lerr := s.requestRegion(c)
+	if lerr != nil {
+		respondError(c, lerr)
+		return
+	}
+	players, lerr := parsePathPlayers(region, c.Param("name"))
+	if lerr != nil {
+		respondError(c, lerr)
+		return
+	}
+	if !s.batchQuota.allow(clientKey(c), len(players)) {
+		respondError(c, newAPIError(http.StatusTooManyRequests, codeQuotaExceeded, "Batch lookup quota exceeded"))
+		return
+	}
+
+	writeResults(c, s.lookupPlayers(c.Request.Context(), players))
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// batchBody is a POST /rest/v1/ranks body asking for n eu players.
+func batchBody(n int) string {
+	players := make([]string, n)
+	for i := range players {
+		players[i] = fmt.Sprintf(`{"region":"eu","name":"p%d","tag":"t"}`, i)
+	}
+	return `{"players":[` + strings.Join(players, ",") + `]}`
+}
+
+func TestBatchSize(t *testing.T) {
+	setVar(t, &maxBatchSize, 3)
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"not json", `{"players":`, http.StatusBadRequest, codeInvalidBody},
+		{"no players", batchBody(0), http.StatusBadRequest, codeInvalidBody},
+		{"at the maximum", batchBody(3), http.StatusOK, ""},
+		{"over the maximum", batchBody(4), http.StatusBadRequest, codeBatchTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeServer(t, &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))})
+			w := serve(s.Handler(), http.MethodPost, "/rest/v1/ranks", tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantCode != "" {
+				if body := decodeBody(t, w.Body.Bytes()); body["code"] != tt.wantCode {
+					t.Errorf("code = %v, want %s", body["code"], tt.wantCode)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchQuota(t *testing.T) {
+	setVar(t, &batchQuotaLimit, 4)
+	setVar(t, &batchQuotaWindow, time.Hour)
+	clock := newFakeClock()
+	s := newFakeServer(t, &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))})
+	s.now = clock.now
+	h := s.Handler()
+
+	tests := []struct {
+		name       string
+		advance    time.Duration
+		players    int
+		client     string
+		wantStatus int
+	}{
+		{"within quota", 0, 3, "alice", http.StatusOK},
+		{"would exceed it", 0, 2, "alice", http.StatusTooManyRequests},
+		{"what is left", 0, 1, "alice", http.StatusOK},
+		{"exhausted", 0, 1, "alice", http.StatusTooManyRequests},
+		{"another client", 0, 4, "bob", http.StatusOK},
+		{"still exhausted later on", 30 * time.Minute, 1, "alice", http.StatusTooManyRequests},
+		{"recovered after the window", 2 * time.Hour, 4, "alice", http.StatusOK},
+	}
+	for _, tt := range tests {
+		clock.advance(tt.advance)
+		w := serveFrom(h, clientAddrs[tt.client], http.MethodPost, "/rest/v1/ranks", batchBody(tt.players))
+		if w.Code != tt.wantStatus {
+			t.Fatalf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.wantStatus, w.Body)
+		}
+		if w.Code == http.StatusTooManyRequests {
+			if body := decodeBody(t, w.Body.Bytes()); body["code"] != codeQuotaExceeded {
+				t.Errorf("%s: code = %v, want %s", tt.name, body["code"], codeQuotaExceeded)
+			}
+		}
+	}
+}
+
+func TestTopHandlerSortsByRank(t *testing.T) {
+	ranks := map[string]map[string]interface{}{
+		"gold":     rankData(12, "Gold 1", 50, "Gold 3"),
+		"plat":     rankData(15, "Platinum 1", 10, "Platinum 1"),
+		"platplus": rankData(15, "Platinum 1", 80, "Diamond 1"),
+		"unranked": rankData(0, "Unrated", 0, ""),
+	}
+	fake := &fakeMMRClient{mmr: func(region, name, tag string) (map[string]interface{}, *apiError) {
+		if data, ok := ranks[name]; ok {
+			return data, nil
+		}
+		return fails(http.StatusNotFound)(region, name, tag)
+	}}
+	s := newFakeServer(t, fake)
+
+	body := `{"players":[` +
+		`{"region":"eu","name":"unranked","tag":"t"},` +
+		`{"region":"eu","name":"gold","tag":"t"},` +
+		`{"region":"eu","name":"missing","tag":"t"},` +
+		`{"region":"eu","name":"plat","tag":"t"},` +
+		`{"region":"eu","name":"platplus","tag":"t"}]}`
+	w := serve(s.Handler(), http.MethodPost, "/rest/v1/ranks/top", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var got struct {
+		Results []batchResult `json:"results"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+
+	// Ties keep their input order, so the unranked player stays ahead of
+	// the failed lookup.
+	want := []string{"platplus", "plat", "gold", "unranked", "missing"}
+	var names []string
+	for _, r := range got.Results {
+		names = append(names, r.Name)
+	}
+	if !slices.Equal(names, want) {
+		t.Fatalf("order = %q, want %q", names, want)
+	}
+	for i, r := range got.Results {
+		if ranked := i < 3; (r.RankValue > 0) != ranked {
+			t.Errorf("%s: rank_value = %d, want it set only for ranked players", r.Name, r.RankValue)
+		}
+		if i > 0 && r.RankValue > got.Results[i-1].RankValue {
+			t.Errorf("%s: rank_value %d is above the previous %d", r.Name, r.RankValue, got.Results[i-1].RankValue)
+		}
+	}
+	if got.Results[4].Error == "" {
+		t.Errorf("missing: want an error, got %+v", got.Results[4])
+	}
+}
+
+func TestBatchCSV(t *testing.T) {
+	fake := &fakeMMRClient{mmr: func(region, name, tag string) (map[string]interface{}, *apiError) {
+		if name == "missing" {
+			return fails(http.StatusNotFound)(region, name, tag)
+		}
+		return rankData(15, "Platinum 1", 45, "Diamond 2"), nil
+	}}
+	s := newFakeServer(t, fake)
+
+	body := `{"players":[` +
+		`{"region":"eu","name":"foo","tag":"bar"},` +
+		`{"region":"eu","name":"missing","tag":"t"},` +
+		`{"region":"mars","name":"Comma, Quote\"","tag":"x"}]}`
+	w := serve(s.Handler(), http.MethodPost, "/rest/v1/ranks?format=csv", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want text/csv", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, `filename="ranks.csv"`) {
+		t.Errorf("Content-Disposition = %q, want a ranks.csv attachment", cd)
+	}
+
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV %q: %v", w.Body, err)
+	}
+	if header := []string{"name", "tag", "region", "tier", "rr", "error"}; len(rows) == 0 || !slices.Equal(rows[0], header) {
+		t.Fatalf("header = %q, want %q", rows, header)
+	}
+	want := [][]string{
+		{"foo", "bar", "eu", "15", "45"},
+		{"missing", "t", "eu", "", ""},
+		{`Comma, Quote"`, "x", "mars", "", ""},
+	}
+	rows = rows[1:]
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows, want %d: %q", len(rows), len(want), rows)
+	}
+	for i, row := range rows {
+		if !slices.Equal(row[:5], want[i]) {
+			t.Errorf("row %d = %q, want %q", i, row, want[i])
+		}
+		// Failed lookups carry their error; its wording is not checked.
+		if gotErr, wantErr := row[5] != "", i > 0; gotErr != wantErr {
+			t.Errorf("row %d error = %q, want one: %v", i, row[5], wantErr)
+		}
+	}
+}
+
+func TestBatchConcurrency(t *testing.T) {
+	const slots = 2
+	setVar(t, &batchConcurrency, slots)
+
+	var inFlight, peak atomic.Int64
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	fake := &fakeMMRClient{mmr: func(region, name, tag string) (map[string]interface{}, *apiError) {
+		if name == "solo" {
+			return rankData(15, "Platinum 1", 45, "Diamond 2"), nil
+		}
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		started <- struct{}{}
+		<-release
+		return rankData(15, "Platinum 1", 45, "Diamond 2"), nil
+	}}
+	h := newFakeServer(t, fake).Handler()
+
+	batch := make(chan int)
+	go func() {
+		batch <- serve(h, http.MethodPost, "/rest/v1/ranks", batchBody(10)).Code
+	}()
+	for range slots {
+		<-started
+	}
+
+	// Every batch slot is taken, yet a single lookup goes straight through.
+	done := make(chan int)
+	go func() { done <- serve(h, http.MethodGet, "/rest/v1/rank/eu/solo/t", "").Code }()
+	select {
+	case code := <-done:
+		if code != http.StatusOK {
+			t.Errorf("single lookup status = %d, want 200", code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("single lookup waited on the saturated batch slots")
+	}
+	select {
+	case <-started:
+		t.Error("a batch lookup started beyond the slot limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if code := <-batch; code != http.StatusOK {
+		t.Errorf("batch status = %d, want 200", code)
+	}
+	if n := peak.Load(); n != slots {
+		t.Errorf("peak batch lookups in flight = %d, want %d", n, slots)
+	}
+}
+
+func TestSemaphore(t *testing.T) {
+	tests := []struct {
+		name    string
+		n       int
+		wantErr bool
+	}{
+		{"free slot", 2, false},
+		{"full", 1, true},
+		{"unbounded", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sem := newSemaphore(tt.n)
+			if err := sem.acquire(context.Background()); err != nil {
+				t.Fatalf("first acquire() = %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			if err := sem.acquire(ctx); (err != nil) != tt.wantErr {
+				t.Errorf("second acquire() = %v, want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPathBatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		players    string
+		wantStatus int
+		wantCode   string
+		// wantNames are the names of the results, in order.
+		wantNames []string
+		wantCalls int64
+	}{
+		{"two players", "foo%23bar,baz%23qux", http.StatusOK, "", []string{"foo", "baz"}, 2},
+		{"at the cap", "a%23t,b%23t,c%23t,d%23t,e%23t", http.StatusOK, "", []string{"a", "b", "c", "d", "e"}, 5},
+		{"duplicate looked up once", "foo%23bar,foo%23bar", http.StatusOK, "", []string{"foo", "foo"}, 1},
+		{"over the cap", "a%23t,b%23t,c%23t,d%23t,e%23t,f%23t", http.StatusBadRequest, codeBatchTooLarge, nil, 0},
+		{"missing tag", "foo%23bar,baz", http.StatusBadRequest, codeInvalidPlayer, nil, 0},
+		{"blank name", "foo%23bar,%20%23qux", http.StatusBadRequest, codeInvalidPlayer, nil, 0},
+		{"empty pair", "foo%23bar,", http.StatusBadRequest, codeInvalidPlayer, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))}
+			s := newFakeServer(t, fake)
+
+			w := serve(s.Handler(), http.MethodGet, "/rest/v1/rank/eu/"+tt.players, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if n := fake.calls.Load(); n != tt.wantCalls {
+				t.Errorf("upstream calls = %d, want %d", n, tt.wantCalls)
+			}
+			if tt.wantCode != "" {
+				if body := decodeBody(t, w.Body.Bytes()); body["code"] != tt.wantCode {
+					t.Errorf("code = %v, want %s", body["code"], tt.wantCode)
+				}
+				return
+			}
+
+			var body struct {
+				Results []batchResult `json:"results"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, r := range body.Results {
+				if r.Error != "" || r.Rank != "Platinum 1" || r.Region != "eu" {
+					t.Errorf("result %+v, want Platinum 1 in eu", r)
+				}
+				names = append(names, r.Name)
+			}
+			if !slices.Equal(names, tt.wantNames) {
+				t.Errorf("results for %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
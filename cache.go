@@ -0,0 +1,471 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"hash/fnv"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// janitorInterval is how often expired entries are swept from the cache.
+	janitorInterval = envDuration("CACHE_JANITOR_INTERVAL", time.Minute)
+	// snapshotPath, when set, is where the cache is saved on shutdown and
+	// restored from on startup.
+	snapshotPath = os.Getenv("CACHE_SNAPSHOT_PATH")
+	// cacheTTLJitter spreads entry lifetimes by up to this fraction either
+	// side of their TTL so entries written together don't expire together.
+	cacheTTLJitter = envFloat("CACHE_TTL_JITTER", 0.1)
+	// staleTTL is how long past its lifetime an entry may still be served
+	// while it is refreshed in the background. Zero disables stale serving.
+	staleTTL = envDuration("CACHE_STALE_TTL", 0)
+	// maxValueBytes caps the JSON size of a cached value. Larger values are
+	// still served, just not cached. Zero means no limit.
+	maxValueBytes = envInt("CACHE_MAX_VALUE_BYTES", 0)
+	// tombstoneTTL is how long an evicted key is remembered, so a lookup
+	// soon after counts as a refetch rather than a cold miss. Zero disables
+	// tombstones.
+	tombstoneTTL = envDuration("CACHE_TOMBSTONE_TTL", 10*time.Minute)
+
+	// adaptiveTTL picks each lookup entry's lifetime from how often its
+	// previous copy was read: adaptiveHotReads reads or more get
+	// adaptiveMinTTL, unread entries get adaptiveMaxTTL and anything between
+	// keeps the cache TTL.
+	adaptiveTTL      = os.Getenv("CACHE_ADAPTIVE_TTL") == "true"
+	adaptiveHotReads = envInt("CACHE_ADAPTIVE_HOT_READS", 10)
+	adaptiveMinTTL   = envDuration("CACHE_TTL_MIN", defaultCacheTTL/2)
+	adaptiveMaxTTL   = envDuration("CACHE_TTL_MAX", 2*defaultCacheTTL)
+)
+
+// defaultCacheTTL is the CACHE_TTL used when none is configured.
+const defaultCacheTTL = 5 * time.Minute
+
+// lookupTTL is the lifetime of a lookup entry replacing one that was read
+// reads times, given the cache TTL ttl.
+func lookupTTL(reads int64, ttl time.Duration) time.Duration {
+	switch {
+	case !adaptiveTTL:
+		return ttl
+	case reads >= int64(adaptiveHotReads):
+		return adaptiveMinTTL
+	case reads == 0:
+		return adaptiveMaxTTL
+	}
+	return ttl
+}
+
+// fitsCache reports whether data is small enough to cache, logging the skip
+// when it is not.
+func fitsCache(key string, data map[string]interface{}) bool {
+	if maxValueBytes <= 0 {
+		return true
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return false
+	}
+	if len(b) <= maxValueBytes {
+		return true
+	}
+	slog.Debug("Value too large to cache",
+		slog.String("key", key),
+		slog.Int("bytes", len(b)),
+		slog.Int("limit", maxValueBytes),
+	)
+	return false
+}
+
+// jitteredTTL returns ttl adjusted by a random factor in [-jitter, +jitter].
+func jitteredTTL(ttl time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return ttl
+	}
+	return time.Duration(float64(ttl) * (1 + jitter*(2*rand.Float64()-1)))
+}
+
+// cacheEntry is one cached upstream payload.
+type cacheEntry struct {
+	data      map[string]interface{}
+	timestamp time.Time
+	ttl       time.Duration
+	// reads counts the times the entry was served. It is shared by every
+	// copy of the entry.
+	reads *atomic.Int64
+}
+
+func newCacheEntry(data map[string]interface{}, timestamp time.Time, ttl time.Duration) cacheEntry {
+	return cacheEntry{data: data, timestamp: timestamp, ttl: ttl, reads: new(atomic.Int64)}
+}
+
+// fresh reports whether the entry is still within its lifetime at now.
+func (e cacheEntry) fresh(now time.Time) bool {
+	return now.Sub(e.timestamp) < e.ttl
+}
+
+// servable reports whether the entry is fresh or within the stale window at
+// now.
+func (e cacheEntry) servable(now time.Time) bool {
+	return now.Sub(e.timestamp) < e.ttl+staleTTL
+}
+
+// cacheShards is how many independently locked partitions the cache is split
+// into, so concurrent requests for different keys rarely share a lock.
+var cacheShards = max(envInt("CACHE_SHARDS", 16), 1)
+
+// memCache is the in-memory response cache of a Server. Keys are spread
+// over shards by hash.
+type memCache struct {
+	shards []*cacheShard
+	// ttl is the lifetime of a cached player lookup.
+	ttl time.Duration
+	now func() time.Time
+}
+
+// cacheShard is one partition of a memCache.
+type cacheShard struct {
+	mu      sync.RWMutex
+	entries map[string]cacheEntry
+	// tombstones holds when each recently evicted key was evicted.
+	tombstones map[string]time.Time
+	// closed is set once shutdown has begun so late writers cannot race the
+	// snapshot.
+	closed bool
+}
+
+func newMemCache(ttl time.Duration, now func() time.Time) *memCache {
+	m := &memCache{shards: make([]*cacheShard, cacheShards), ttl: ttl, now: now}
+	for i := range m.shards {
+		m.shards[i] = &cacheShard{entries: make(map[string]cacheEntry), tombstones: make(map[string]time.Time)}
+	}
+	return m
+}
+
+// shard returns the shard holding key.
+func (m *memCache) shard(key string) *cacheShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return m.shards[h.Sum32()%uint32(len(m.shards))]
+}
+
+// get returns the entry for key if it is fresh.
+func (m *memCache) get(key string) (cacheEntry, bool) {
+	sh := m.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	entry, ok := sh.entries[key]
+	if !ok || !entry.fresh(m.now()) {
+		return cacheEntry{}, false
+	}
+	entry.reads.Add(1)
+	return entry, true
+}
+
+// peek returns the entry for key if it is still servable, fresh or not.
+func (m *memCache) peek(key string) (cacheEntry, bool) {
+	sh := m.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	entry, ok := sh.entries[key]
+	if !ok || !entry.servable(m.now()) {
+		return cacheEntry{}, false
+	}
+	entry.reads.Add(1)
+	return entry, true
+}
+
+// entry returns the entry for key even if it has expired.
+func (m *memCache) entry(key string) (cacheEntry, bool) {
+	sh := m.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	entry, ok := sh.entries[key]
+	return entry, ok
+}
+
+// evictedRecently reports whether key was cached until recently: it is still
+// stored but past its stale window, or was evicted within tombstoneTTL.
+func (m *memCache) evictedRecently(key string) bool {
+	sh := m.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	if _, ok := sh.entries[key]; ok {
+		return true
+	}
+	evicted, ok := sh.tombstones[key]
+	return ok && m.now().Sub(evicted) < tombstoneTTL
+}
+
+// len returns the number of stored entries, expired or not.
+func (m *memCache) len() int {
+	n := 0
+	for _, sh := range m.shards {
+		sh.mu.RLock()
+		n += len(sh.entries)
+		sh.mu.RUnlock()
+	}
+	return n
+}
+
+// sizeEstimate approximates the memory held by the cache as the length of
+// every key plus the JSON size of every value. It marshals each entry, so it
+// is meant for occasional operational use only.
+func (m *memCache) sizeEstimate() int {
+	n := 0
+	for _, sh := range m.shards {
+		sh.mu.RLock()
+		for key, entry := range sh.entries {
+			b, _ := json.Marshal(entry.data)
+			n += len(key) + len(b)
+		}
+		sh.mu.RUnlock()
+	}
+	return n
+}
+
+// set stores a lookup payload under key and returns the lifetime it was
+// given: the cache TTL for a new key, or lookupTTL of the reads of the copy
+// it replaces.
+func (m *memCache) set(key string, data map[string]interface{}) time.Duration {
+	sh := m.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	ttl := m.ttl
+	if prev, ok := sh.entries[key]; ok {
+		ttl = lookupTTL(prev.reads.Load(), m.ttl)
+	}
+	return sh.store(key, data, ttl, m.now())
+}
+
+// setTTL stores data under key for ttl, subject to the usual jitter.
+func (m *memCache) setTTL(key string, data map[string]interface{}, ttl time.Duration) {
+	sh := m.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.store(key, data, ttl, m.now())
+}
+
+// store does the work of set and setTTL, stamping the entry with now. sh.mu
+// must be held.
+func (sh *cacheShard) store(key string, data map[string]interface{}, ttl time.Duration, now time.Time) time.Duration {
+	if sh.closed {
+		return 0
+	}
+	entry := newCacheEntry(data, now, jitteredTTL(ttl, cacheTTLJitter))
+	sh.entries[key] = entry
+	delete(sh.tombstones, key)
+	return entry.ttl
+}
+
+// X-Cache header values.
+const (
+	cacheHit   = "HIT"
+	cacheStale = "STALE"
+	cacheMiss  = "MISS"
+)
+
+// setCacheHeader reports how a response was served in the X-Cache header.
+func setCacheHeader(c *gin.Context, source resultSource) {
+	switch source {
+	case sourceCache:
+		c.Header("X-Cache", cacheHit)
+	case sourceStale:
+		c.Header("X-Cache", cacheStale)
+	default:
+		c.Header("X-Cache", cacheMiss)
+	}
+}
+
+// evictExpired removes every entry past its lifetime and stale window,
+// leaving a tombstone in its place, and returns how many were removed.
+// Tombstones older than tombstoneTTL are dropped.
+func (m *memCache) evictExpired() int {
+	n, now := 0, m.now()
+	for _, sh := range m.shards {
+		sh.mu.Lock()
+		for key, entry := range sh.entries {
+			if !entry.servable(now) {
+				delete(sh.entries, key)
+				if tombstoneTTL > 0 {
+					sh.tombstones[key] = now
+				}
+				n++
+			}
+		}
+		for key, evicted := range sh.tombstones {
+			if now.Sub(evicted) >= tombstoneTTL {
+				delete(sh.tombstones, key)
+			}
+		}
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+// startJanitor sweeps expired entries and refreshes hot ones every interval
+// until the returned stop function is called. stop blocks until the janitor
+// has exited.
+func (s *Server) startJanitor(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.stats.evicted(s.cache.evictExpired())
+				s.notFound.sweep()
+				s.refreshHot(interval)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// close makes every later set a no-op, so the cache can be snapshotted
+// without racing stragglers.
+func (m *memCache) close() {
+	for _, sh := range m.shards {
+		sh.mu.Lock()
+		sh.closed = true
+		sh.mu.Unlock()
+	}
+}
+
+// snapshotEntry is the on-disk form of a cacheEntry.
+type snapshotEntry struct {
+	Data      map[string]interface{} `json:"data"`
+	Timestamp time.Time              `json:"timestamp"`
+	TTL       time.Duration          `json:"ttl"`
+}
+
+// saveSnapshot writes all unexpired entries to path. The file is written to
+// a temporary name first and renamed so a crash never leaves it half written.
+func (m *memCache) saveSnapshot(path string) error {
+	b, err := json.Marshal(m.freshEntries())
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadSnapshot restores unexpired entries from path and returns how many
+// were loaded.
+func (m *memCache) loadSnapshot(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var entries map[string]snapshotEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return 0, err
+	}
+	return m.restore(entries), nil
+}
+
+// freshEntries returns the unexpired entries.
+func (m *memCache) freshEntries() map[string]snapshotEntry {
+	entries, now := make(map[string]snapshotEntry), m.now()
+	for _, sh := range m.shards {
+		sh.mu.RLock()
+		for key, entry := range sh.entries {
+			if entry.fresh(now) {
+				entries[key] = snapshotEntry{Data: entry.data, Timestamp: entry.timestamp, TTL: entry.ttl}
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	return entries
+}
+
+// restore stores the unexpired entries under their original timestamps and
+// returns how many were kept.
+func (m *memCache) restore(entries map[string]snapshotEntry) int {
+	n, now := 0, m.now()
+	for key, entry := range entries {
+		restored := newCacheEntry(entry.Data, entry.Timestamp, cmp.Or(entry.TTL, m.ttl))
+		if entry.Data == nil || !restored.fresh(now) {
+			continue
+		}
+		sh := m.shard(key)
+		sh.mu.Lock()
+		if !sh.closed {
+			sh.entries[key] = restored
+			delete(sh.tombstones, key)
+			n++
+		}
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+// cacheEntryHandler describes the cached MMR entry for a player, including
+// expired entries the janitor has not swept yet. ?data=true adds the stored
+// payload.
+func (s *Server) cacheEntryHandler(c *gin.Context) {
+	region, lerr := s.requestRegion(c)
+	if lerr != nil {
+		respondError(c, lerr)
+		return
+	}
+	name, tag, lerr := cleanPlayer(c.Param("name"), c.Param("tag"))
+	if lerr != nil {
+		respondError(c, lerr)
+		return
+	}
+
+	key := tenantCacheKey(c.Request.Context(), mmrCacheKey(region, name, tag, s.cfg.FoldNameCase))
+	entry, ok := s.cache.entry(key)
+	if !ok {
+		respondError(c, newAPIError(http.StatusNotFound, codeNotCached, "No cache entry for this player"))
+		return
+	}
+
+	resp := gin.H{
+		"key":         key,
+		"timestamp":   entry.timestamp.UTC().Format(time.RFC3339),
+		"age_seconds": int(s.now().Sub(entry.timestamp).Seconds()),
+		"ttl_seconds": int(entry.ttl.Seconds()),
+		"reads":       entry.reads.Load(),
+		"expired":     !entry.fresh(s.now()),
+	}
+	if c.Query("data") == "true" {
+		resp["data"] = entry.data
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable clock for caches under test.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+func TestMemCacheShards(t *testing.T) {
+	for _, shards := range []int{1, 16} {
+		t.Run(strconv.Itoa(shards), func(t *testing.T) {
+			setVar(t, &cacheShards, shards)
+			setVar(t, &cacheTTLJitter, 0)
+			m := newMemCache(defaultCacheTTL, newFakeClock().now)
+
+			const n = 500
+			used := make(map[*cacheShard]bool)
+			for i := range n {
+				key := fmt.Sprintf("eu:player%d:tag", i)
+				m.set(key, map[string]interface{}{"i": i})
+				used[m.shard(key)] = true
+			}
+			if len(used) != shards {
+				t.Errorf("keys landed in %d shards, want %d", len(used), shards)
+			}
+			if got := m.len(); got != n {
+				t.Errorf("len() = %d, want %d", got, n)
+			}
+			for i := range n {
+				key := fmt.Sprintf("eu:player%d:tag", i)
+				entry, ok := m.get(key)
+				if !ok || entry.data["i"] != i {
+					t.Fatalf("get(%q) = %v, %v, want i=%d", key, entry.data, ok, i)
+				}
+			}
+		})
+	}
+}
+
+func TestMemCacheConcurrentShards(t *testing.T) {
+	setVar(t, &cacheShards, 8)
+	m := newMemCache(defaultCacheTTL, time.Now)
+
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 200 {
+				key := fmt.Sprintf("%d:%d", g, i)
+				m.set(key, map[string]interface{}{"g": g})
+				if entry, ok := m.get(key); !ok || entry.data["g"] != g {
+					t.Errorf("get(%q) = %v, %v", key, entry.data, ok)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if got := m.len(); got != 8*200 {
+		t.Errorf("len() = %d, want %d", got, 8*200)
+	}
+}
+
+func BenchmarkMemCacheParallel(b *testing.B) {
+	const keys = 1024
+	for _, shards := range []int{1, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			setVar(b, &cacheShards, shards)
+			m := newMemCache(defaultCacheTTL, time.Now)
+			names := make([]string, keys)
+			for i := range names {
+				names[i] = fmt.Sprintf("eu:player%d:tag", i)
+				m.set(names[i], map[string]interface{}{"i": i})
+			}
+			data := map[string]interface{}{"i": 0}
+			var workers atomic.Int64
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				// Start each worker on its own keys so they meet on
+				// shard locks rather than on the same entries.
+				i := int(workers.Add(1)) * 97
+				for pb.Next() {
+					key := names[i%keys]
+					// One write for every eight reads.
+					if i%8 == 0 {
+						m.set(key, data)
+					} else {
+						m.get(key)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+func TestShutdownAmidConcurrentWrites(t *testing.T) {
+	s := newFakeServer(t, &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))})
+	h := s.Handler()
+	stopJanitor := s.startJanitor(time.Millisecond)
+
+	// Handlers and direct writers keep setting entries, some expiring at
+	// once so the janitor has work, until well after the cache is frozen.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				serve(h, http.MethodGet, fmt.Sprintf("/rest/v1/rank/eu/p%d_%d/t", g, i), "")
+				s.cache.setTTL(fmt.Sprintf("direct:%d:%d", g, i), map[string]interface{}{"i": i}, time.Millisecond)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// The order main shuts down in.
+	stopJanitor()
+	s.background.stop()
+	s.cache.close()
+	frozen := s.cache.len()
+	path := t.TempDir() + "/snapshot.json"
+	if err := s.cache.saveSnapshot(path); err != nil {
+		t.Fatalf("saveSnapshot: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	if got := s.cache.len(); got > frozen {
+		t.Errorf("len() = %d after close, want at most the %d frozen entries", got, frozen)
+	}
+	restored := newMemCache(defaultCacheTTL, time.Now)
+	n, err := restored.loadSnapshot(path)
+	if err != nil {
+		t.Fatalf("loadSnapshot: %v", err)
+	}
+	if n == 0 || n > frozen {
+		t.Errorf("restored %d entries, want some of the %d frozen", n, frozen)
+	}
+}
+
+func TestCacheTTLJitter(t *testing.T) {
+	const ttl = 100 * time.Second
+	tests := []struct {
+		name   string
+		jitter float64
+	}{
+		{"disabled", 0},
+		{"default", 0.1},
+		{"wide", 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &cacheTTLJitter, tt.jitter)
+			clock := newFakeClock()
+			m := newMemCache(ttl, clock.now)
+
+			// Entries written together, as after a preload.
+			const n = 50
+			ttls := make(map[time.Duration]bool)
+			for i := range n {
+				key := strconv.Itoa(i)
+				if got := m.set(key, map[string]interface{}{}); got != mustEntry(t, m, key).ttl {
+					t.Fatalf("set(%q) = %v, but the entry lives %v", key, got, mustEntry(t, m, key).ttl)
+				}
+				ttls[mustEntry(t, m, key).ttl] = true
+			}
+			lo := time.Duration(float64(ttl) * (1 - tt.jitter))
+			hi := time.Duration(float64(ttl) * (1 + tt.jitter))
+			for d := range ttls {
+				if d < lo || d > hi {
+					t.Errorf("TTL %v is outside [%v, %v]", d, lo, hi)
+				}
+			}
+			if tt.jitter == 0 && len(ttls) != 1 {
+				t.Errorf("got %d distinct TTLs without jitter, want 1", len(ttls))
+			}
+			if tt.jitter > 0 && len(ttls) < n/2 {
+				t.Errorf("got %d distinct TTLs for %d entries, want expiries spread out", len(ttls), n)
+			}
+
+			// Everything is still fresh just inside the band and gone just past it.
+			clock.advance(lo - time.Second)
+			for i := range n {
+				if _, ok := m.get(strconv.Itoa(i)); !ok {
+					t.Fatalf("entry %d expired before %v", i, lo)
+				}
+			}
+			clock.advance(hi - lo + 2*time.Second)
+			for i := range n {
+				if _, ok := m.get(strconv.Itoa(i)); ok {
+					t.Fatalf("entry %d still fresh after %v", i, hi)
+				}
+			}
+		})
+	}
+}
+
+// mustEntry returns the stored entry for key, failing the test when there is
+// none.
+func mustEntry(t *testing.T, m *memCache, key string) cacheEntry {
+	t.Helper()
+	entry, ok := m.entry(key)
+	if !ok {
+		t.Fatalf("no entry for %q", key)
+	}
+	return entry
+}
+
+func TestCacheHeaderMatchesBody(t *testing.T) {
+	setVar(t, &staleTTL, time.Minute)
+	setVar(t, &cacheTTLJitter, 0)
+	fake := &fakeMMRClient{
+		mmr:         returns(rankData(15, "Platinum 1", 45, "Diamond 2")),
+		leaderboard: leaderboardFrom(leaderboardJSON(3)),
+	}
+	s := newFakeServer(t, fake)
+	clock := newFakeClock()
+	s.now = clock.now
+	h := s.Handler()
+
+	const batch = `{"players":[{"region":"eu","name":"foo","tag":"bar"}]}`
+	cached := func(t *testing.T, method, body string) interface{} {
+		t.Helper()
+		decoded := decodeBody(t, []byte(body))
+		if method == http.MethodPost {
+			results, _ := decoded["results"].([]interface{})
+			if len(results) != 1 {
+				t.Fatalf("batch results = %v, want one", decoded)
+			}
+			// Batch results omit cached when false.
+			result, _ := results[0].(map[string]interface{})
+			return result["cached"] == true
+		}
+		return decoded["cached"]
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		target, body string
+		advance      time.Duration
+		wantHeader   string
+		// wantCached is the JSON cached field, nil where the body has none.
+		wantCached interface{}
+	}{
+		{"rank miss", http.MethodGet, "/rest/v1/rank/eu/foo/bar", "", 0, cacheMiss, false},
+		{"rank hit", http.MethodGet, "/rest/v1/rank/eu/foo/bar", "", 0, cacheHit, true},
+		{"batch hit", http.MethodPost, "/rest/v1/ranks", batch, 0, cacheHit, true},
+		{"rank stale", http.MethodGet, "/rest/v1/rank/eu/foo/bar", "", defaultCacheTTL + time.Second, cacheStale, true},
+		{"rank refreshed", http.MethodGet, "/rest/v1/rank/eu/foo/bar", "", 0, cacheHit, true},
+		{"batch miss", http.MethodPost, "/rest/v1/ranks", `{"players":[{"region":"eu","name":"new","tag":"bar"}]}`, 0, cacheMiss, false},
+		{"leaderboard miss", http.MethodGet, "/rest/v1/leaderboard/eu", "", 0, cacheMiss, nil},
+		{"leaderboard hit", http.MethodGet, "/rest/v1/leaderboard/eu", "", 0, cacheHit, nil},
+	}
+	for _, tt := range tests {
+		clock.advance(tt.advance)
+		w := serve(h, tt.method, tt.target, tt.body)
+		// A stale answer refreshes in the background; let it land.
+		s.background.wg.Wait()
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", tt.name, w.Code, w.Body)
+		}
+		if got := w.Header().Get("X-Cache"); got != tt.wantHeader {
+			t.Errorf("%s: X-Cache = %q, want %q", tt.name, got, tt.wantHeader)
+		}
+		if got := cached(t, tt.method, w.Body.String()); got != tt.wantCached {
+			t.Errorf("%s: cached = %v, want %v", tt.name, got, tt.wantCached)
+		}
+	}
+}
+
+func TestCacheEntryHandler(t *testing.T) {
+	setVar(t, &cacheTTLJitter, 0)
+	s := opsServer(t, &fakeMMRClient{})
+	clock := newFakeClock()
+	s.now = clock.now
+	seeded := clock.now()
+	s.cache.set(mmrCacheKey("eu", "Foo", "bar", false), map[string]interface{}{"seeded": true})
+	clock.advance(90 * time.Second)
+	h := s.Handler()
+	auth := []string{"Authorization", "Bearer ops"}
+
+	tests := []struct {
+		name       string
+		target     string
+		advance    time.Duration
+		wantStatus int
+		want       map[string]interface{}
+	}{
+		{"seeded entry", "/cache/eu/Foo/bar", 0, http.StatusOK, map[string]interface{}{
+			"key": "eu:Foo:bar", "timestamp": seeded.Format(time.RFC3339),
+			"age_seconds": 90.0, "ttl_seconds": 300.0, "expired": false,
+		}},
+		{"region alias", "/cache/EUW/Foo/bar", 0, http.StatusOK, map[string]interface{}{"key": "eu:Foo:bar"}},
+		{"with data", "/cache/eu/Foo/bar?data=true", 0, http.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{"seeded": true},
+		}},
+		{"expired but not swept", "/cache/eu/Foo/bar", 5 * time.Minute, http.StatusOK, map[string]interface{}{
+			"age_seconds": 390.0, "expired": true,
+		}},
+		{"missing", "/cache/eu/nobody/bar", 0, http.StatusNotFound, map[string]interface{}{"code": codeNotCached}},
+		{"bad region", "/cache/mars/Foo/bar", 0, http.StatusBadRequest, map[string]interface{}{"code": codeInvalidRegion}},
+	}
+	for _, tt := range tests {
+		clock.advance(tt.advance)
+		w := serve(h, http.MethodGet, tt.target, "", auth...)
+		if w.Code != tt.wantStatus {
+			t.Fatalf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.wantStatus, w.Body)
+		}
+		body := decodeBody(t, w.Body.Bytes())
+		for k, want := range tt.want {
+			if !reflect.DeepEqual(body[k], want) {
+				t.Errorf("%s: %s = %v, want %v", tt.name, k, body[k], want)
+			}
+		}
+		if _, ok := body["data"]; ok != (tt.want["data"] != nil) {
+			t.Errorf("%s: data included: %v, want %v", tt.name, ok, !ok)
+		}
+	}
+
+	if w := serve(h, http.MethodGet, "/cache/eu/Foo/bar", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials: status = %d, want 401", w.Code)
+	}
+}
+
+func TestOversizedValuesAreNotCached(t *testing.T) {
+	big := rankData(15, "Platinum 1", 45, "Diamond 2")
+	big["padding"] = strings.Repeat("x", 4096)
+	tests := []struct {
+		name       string
+		limit      int
+		data       map[string]interface{}
+		wantCached bool
+	}{
+		{"no limit", 0, big, true},
+		{"under the limit", 1024, rankData(15, "Platinum 1", 45, "Diamond 2"), true},
+		{"over the limit", 1024, big, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &maxValueBytes, tt.limit)
+			var logs bytes.Buffer
+			old := slog.Default()
+			slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+			t.Cleanup(func() { slog.SetDefault(old) })
+			fake := &fakeMMRClient{mmr: returns(tt.data)}
+			s := newFakeServer(t, fake)
+			h := s.Handler()
+
+			for range 2 {
+				w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+				if w.Code != http.StatusOK || decodeBody(t, w.Body.Bytes())["message"] != "Platinum 1 [45RR] | Peak: Diamond 2" {
+					t.Fatalf("status = %d: %s, want the rank served", w.Code, w.Body)
+				}
+			}
+			wantCalls, wantEntries := int64(2), 0
+			if tt.wantCached {
+				wantCalls, wantEntries = 1, 1
+			}
+			if n := fake.calls.Load(); n != wantCalls {
+				t.Errorf("upstream calls = %d, want %d", n, wantCalls)
+			}
+			if n := s.cache.len(); n != wantEntries {
+				t.Errorf("cache entries = %d, want %d", n, wantEntries)
+			}
+			if logged := strings.Contains(logs.String(), "Value too large to cache"); logged == tt.wantCached {
+				t.Errorf("skip logged: %v, want %v: %s", logged, !tt.wantCached, logs.String())
+			}
+		})
+	}
+}
+
+func TestAdaptiveTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		adaptive bool
+		// hits are the cache hits the first copy of the entry served.
+		hits    int
+		wantTTL time.Duration
+	}{
+		{"hot", true, 5, time.Minute},
+		{"at the hot threshold", true, 3, time.Minute},
+		{"read now and then", true, 1, defaultCacheTTL},
+		{"never read", true, 0, 10 * time.Minute},
+		{"disabled, hot", false, 5, defaultCacheTTL},
+		{"disabled, never read", false, 0, defaultCacheTTL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &adaptiveTTL, tt.adaptive)
+			setVar(t, &adaptiveHotReads, 3)
+			setVar(t, &adaptiveMinTTL, time.Minute)
+			setVar(t, &adaptiveMaxTTL, 10*time.Minute)
+			setVar(t, &cacheTTLJitter, 0)
+			fake := &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))}
+			s := newFakeServer(t, fake)
+			clock := newFakeClock()
+			s.now = clock.now
+			h := s.Handler()
+			key := mmrCacheKey("eu", "foo", "bar", false)
+
+			for range 1 + tt.hits {
+				serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+			}
+			// A new key always starts on the cache TTL.
+			if ttl := mustEntry(t, s.cache, key).ttl; ttl != defaultCacheTTL {
+				t.Errorf("first ttl = %v, want %v", ttl, defaultCacheTTL)
+			}
+
+			clock.advance(defaultCacheTTL + time.Second)
+			serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+			if n := fake.calls.Load(); n != 2 {
+				t.Fatalf("upstream calls = %d, want the expired entry refetched", n)
+			}
+			if ttl := mustEntry(t, s.cache, key).ttl; ttl != tt.wantTTL {
+				t.Errorf("ttl after %d hits = %v, want %v", tt.hits, ttl, tt.wantTTL)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cacheExportLimit bounds how many entries GET /cache/export returns and
+// POST /cache/import accepts.
+var cacheExportLimit = envInt("CACHE_EXPORT_MAX_ENTRIES", 10000)
+
+// exportedEntry is one cache entry as exchanged by /cache/export and
+// /cache/import.
+type exportedEntry struct {
+	Key string `json:"key"`
+	snapshotEntry
+}
+
+// cacheExportHandler serves the unexpired cache entries as a JSON array
+// ordered by key, the first CACHE_EXPORT_MAX_ENTRIES of them.
+// X-Cache-Export-Truncated is set when entries were left out. Every fresh
+// entry is collected before truncating, so a truncated export is always the
+// same prefix of the keys rather than whichever the shards yield first.
+func (s *Server) cacheExportHandler(c *gin.Context) {
+	entries := s.cache.freshEntries()
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	if len(keys) > cacheExportLimit {
+		keys = keys[:cacheExportLimit]
+		c.Header("X-Cache-Export-Truncated", "true")
+	}
+
+	out := make([]exportedEntry, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, exportedEntry{Key: key, snapshotEntry: entries[key]})
+	}
+	c.Header("X-Total-Count", strconv.Itoa(len(out)))
+	c.JSON(http.StatusOK, out)
+}
+
+// cacheImportHandler loads entries in the form GET /cache/export returns.
+// Entries keep their original timestamps, so those already expired are
+// skipped.
+func (s *Server) cacheImportHandler(c *gin.Context) {
+	var req []exportedEntry
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, codeInvalidBody, "Invalid request body"))
+		return
+	}
+	if len(req) > cacheExportLimit {
+		respondError(c, newAPIError(http.StatusRequestEntityTooLarge, codeImportTooLarge, fmt.Sprintf("Too many entries, maximum is %d", cacheExportLimit)))
+		return
+	}
+
+	entries := make(map[string]snapshotEntry, len(req))
+	for _, e := range req {
+		if e.Key == "" {
+			respondError(c, newAPIError(http.StatusBadRequest, codeInvalidBody, "Every entry needs a key"))
+			return
+		}
+		entries[e.Key] = e.snapshotEntry
+	}
+	n := s.cache.restore(entries)
+	c.JSON(http.StatusOK, gin.H{"imported": n, "skipped": len(req) - n})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCacheExportImport(t *testing.T) {
+	setVar(t, &cacheTTLJitter, 0)
+	src := opsServer(t, &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))})
+	clock := newFakeClock()
+	src.now = clock.now
+	srcHandler := src.Handler()
+	src.cache.setTTL(mmrCacheKey("eu", "old", "t", false), rankData(3, "Iron 1", 0, "Iron 1"), time.Minute)
+	for _, target := range []string{"/rest/v1/rank/eu/a/t", "/rest/v1/rank/na/b/t"} {
+		serve(srcHandler, http.MethodGet, target, "")
+	}
+	clock.advance(2 * time.Minute)
+
+	if w := serve(srcHandler, http.MethodGet, "/cache/export", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("export without credentials = %d, want 401", w.Code)
+	}
+	w := serve(srcHandler, http.MethodGet, "/cache/export", "", "Authorization", "Bearer ops")
+	var exported []exportedEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &exported); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("export = %d %s: %v", w.Code, w.Body, err)
+	}
+	var keys []string
+	for _, e := range exported {
+		keys = append(keys, e.Key)
+	}
+	// The expired entry is left out.
+	if want := []string{mmrCacheKey("eu", "a", "t", false), mmrCacheKey("na", "b", "t", false)}; !slices.Equal(keys, want) {
+		t.Errorf("exported keys = %q, want %q", keys, want)
+	}
+	if got := w.Header().Get("X-Total-Count"); got != "2" {
+		t.Errorf("X-Total-Count = %q, want 2", got)
+	}
+
+	fake := &fakeMMRClient{mmr: fails(http.StatusBadGateway)}
+	dst := opsServer(t, fake)
+	dst.now = clock.now
+	dstHandler := dst.Handler()
+	w = serve(dstHandler, http.MethodPost, "/cache/import", w.Body.String(), "Authorization", "Bearer ops")
+	if body := decodeBody(t, w.Body.Bytes()); w.Code != http.StatusOK || body["imported"] != float64(2) || body["skipped"] != float64(0) {
+		t.Fatalf("import = %d %v, want 2 imported", w.Code, body)
+	}
+
+	for _, target := range []string{"/rest/v1/rank/eu/a/t", "/rest/v1/rank/na/b/t"} {
+		w := serve(dstHandler, http.MethodGet, target, "")
+		if w.Code != http.StatusOK || w.Header().Get("X-Cache") != cacheHit {
+			t.Errorf("%s after import = %d, X-Cache %q, want a 200 hit", target, w.Code, w.Header().Get("X-Cache"))
+		}
+		if msg := decodeBody(t, w.Body.Bytes())["message"]; msg != "Platinum 1 [45RR] | Peak: Diamond 2" {
+			t.Errorf("%s after import: message = %v", target, msg)
+		}
+	}
+	if n := fake.calls.Load(); n != 0 {
+		t.Errorf("upstream calls = %d, want the imported entries served", n)
+	}
+	// Imported entries keep their age and expire when the originals would.
+	entry := mustEntry(t, dst.cache, mmrCacheKey("eu", "a", "t", false))
+	if want := newFakeClock().now(); !entry.timestamp.Equal(want) {
+		t.Errorf("imported timestamp = %v, want the original %v", entry.timestamp, want)
+	}
+}
+
+func TestCacheImportErrors(t *testing.T) {
+	setVar(t, &cacheExportLimit, 2)
+	fresh := newFakeClock().now().Format(time.RFC3339)
+	expired := newFakeClock().now().Add(-time.Hour).Format(time.RFC3339)
+	entry := func(key, timestamp string) string {
+		return `{"key":"` + key + `","data":{"current_data":{}},"timestamp":"` + timestamp + `"}`
+	}
+	tests := []struct {
+		name         string
+		body         string
+		wantStatus   int
+		wantCode     string
+		wantImported float64
+	}{
+		{"expired skipped", "[" + entry("a", fresh) + "," + entry("b", expired) + "]", http.StatusOK, "", 1},
+		{"empty", "[]", http.StatusOK, "", 0},
+		{"too many", "[" + entry("a", fresh) + "," + entry("b", fresh) + "," + entry("c", fresh) + "]", http.StatusRequestEntityTooLarge, codeImportTooLarge, 0},
+		{"missing key", "[" + entry("", fresh) + "]", http.StatusBadRequest, codeInvalidBody, 0},
+		{"not an array", `{"key":"a"}`, http.StatusBadRequest, codeInvalidBody, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := opsServer(t, &fakeMMRClient{})
+			s.now = newFakeClock().now
+			w := serve(s.Handler(), http.MethodPost, "/cache/import", tt.body, "Authorization", "Bearer ops")
+			body := decodeBody(t, w.Body.Bytes())
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantCode != "" && body["code"] != tt.wantCode {
+				t.Errorf("code = %v, want %s", body["code"], tt.wantCode)
+			}
+			if tt.wantCode == "" && body["imported"] != tt.wantImported {
+				t.Errorf("imported = %v, want %v", body["imported"], tt.wantImported)
+			}
+			if got := s.cache.len(); float64(got) != tt.wantImported {
+				t.Errorf("cache holds %d entries, want %v", got, tt.wantImported)
+			}
+		})
+	}
+}
+
+func TestCacheExportTruncated(t *testing.T) {
+	setVar(t, &cacheExportLimit, 3)
+	s := opsServer(t, &fakeMMRClient{})
+	var keys []string
+	for i := range 20 {
+		key := mmrCacheKey("eu", fmt.Sprintf("p%02d", i), "t", false)
+		s.cache.set(key, rankData(15, "Platinum 1", 45, "Diamond 2"))
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	// Entries are spread over shards whose order varies, so the export must
+	// still be the first keys in order every time.
+	for range 5 {
+		w := serve(s.Handler(), http.MethodGet, "/cache/export", "", "Authorization", "Bearer ops")
+		var exported []exportedEntry
+		if err := json.Unmarshal(w.Body.Bytes(), &exported); err != nil {
+			t.Fatalf("export = %s: %v", w.Body, err)
+		}
+		got := make([]string, len(exported))
+		for i, e := range exported {
+			got[i] = e.Key
+		}
+		if !slices.Equal(got, keys[:3]) {
+			t.Fatalf("exported keys = %q, want the first three %q", got, keys[:3])
+		}
+		if w.Header().Get("X-Cache-Export-Truncated") != "true" {
+			t.Error("X-Cache-Export-Truncated not set on a truncated export")
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// MMRClient fetches player data from henrikdev. Payloads are the "data"
+// object of the upstream response, with list payloads wrapped under "items".
+// A response without that object is reported as UPSTREAM_EMPTY.
+type MMRClient interface {
+	GetMMR(ctx context.Context, region, name, tag string) (map[string]interface{}, *apiError)
+	GetAccount(ctx context.Context, region, name, tag string) (map[string]interface{}, *apiError)
+	GetHistory(ctx context.Context, region, name, tag string) (map[string]interface{}, *apiError)
+	// GetLeaderboard returns the raw leaderboard body so it can be streamed.
+	// The caller must close it.
+	GetLeaderboard(ctx context.Context, region string) (io.ReadCloser, *apiError)
+}
+
+// httpMMRClient is the MMRClient talking to henrikdev over HTTP.
+type httpMMRClient struct {
+	cfg Config
+	// apiKey is the key requests are sent with, normally keys.primary. Copies
+	// made by withKey send another.
+	apiKey string
+	keys   *apiKeys
+	client *http.Client
+	logger *slog.Logger
+}
+
+func newHTTPMMRClient(cfg Config, logger *slog.Logger) *httpMMRClient {
+	return &httpMMRClient{
+		cfg:    cfg,
+		apiKey: cfg.APIKey,
+		keys:   &apiKeys{primary: cfg.APIKey, secondary: cfg.APIKeySecondary},
+		client: newHTTPClient(),
+		logger: logger,
+	}
+}
+
+// apiKeys is the pair of upstream keys used during a rotation. Requests go
+// out with the active key; when upstream rejects it the other one is tried
+// and, if accepted, becomes active.
+type apiKeys struct {
+	primary, secondary string
+	useSecondary       atomic.Bool
+}
+
+func (k *apiKeys) active() string {
+	if k.useSecondary.Load() {
+		return k.secondary
+	}
+	return k.primary
+}
+
+// other returns the key that is not key, or "" without a secondary key.
+func (k *apiKeys) other(key string) string {
+	if k.secondary == "" {
+		return ""
+	}
+	if key == k.primary {
+		return k.secondary
+	}
+	return k.primary
+}
+
+// withKey returns a copy of h sending key instead.
+func (h *httpMMRClient) withKey(key string) *httpMMRClient {
+	c := *h
+	c.apiKey = key
+	return &c
+}
+
+// keyRejected reports whether upstream refused the api key of a response.
+func keyRejected(res *http.Response) bool {
+	return res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden
+}
+
+func (h *httpMMRClient) GetMMR(ctx context.Context, region, name, tag string) (map[string]interface{}, *apiError) {
+	data, lerr := h.getData(ctx, region, mmrPath(h.cfg, region, name, tag))
+	if lerr != nil || h.cfg.MMRVersion != "v3" {
+		return data, lerr
+	}
+	return mmrFromV3(data), nil
+}
+
+func (h *httpMMRClient) GetAccount(ctx context.Context, region, name, tag string) (map[string]interface{}, *apiError) {
+	return h.getData(ctx, region, accountPath(name, tag))
+}
+
+func (h *httpMMRClient) GetHistory(ctx context.Context, region, name, tag string) (map[string]interface{}, *apiError) {
+	return h.getData(ctx, region, historyPath(region, name, tag))
+}
+
+func (h *httpMMRClient) GetLeaderboard(ctx context.Context, region string) (io.ReadCloser, *apiError) {
+	res, lerr := h.get(ctx, region, leaderboardPath(region))
+	if lerr != nil {
+		return nil, lerr
+	}
+	return res.Body, nil
+}
+
+// get fetches path with retries, turning anything but a 200 into an error.
+// On success the caller owns the response body.
+func (h *httpMMRClient) get(ctx context.Context, region, path string) (*http.Response, *apiError) {
+	if !hasHeadroom(ctx, minUpstreamHeadroom) {
+		return nil, newAPIError(http.StatusServiceUnavailable, codeInsufficientBudget, "Not enough time left to contact external API")
+	}
+
+	key := h.keys.active()
+	res, err := h.withKey(key).fetchWithRetry(ctx, region, path)
+	if other := h.keys.other(key); err == nil && keyRejected(res) && other != "" {
+		res.Body.Close()
+		key = other
+		res, err = h.withKey(key).fetchWithRetry(ctx, region, path)
+		if err == nil && !keyRejected(res) {
+			h.keys.useSecondary.Store(key == h.keys.secondary)
+			h.logger.Warn("Upstream rejected the active API key, switched keys",
+				slog.Bool("secondary", key == h.keys.secondary),
+			)
+		}
+	}
+	if err != nil {
+		return nil, fetchError(err)
+	}
+	// A 404 or 429 means the same whatever the body, but a success or 5xx
+	// that isn't JSON is a proxy or maintenance page rather than the API.
+	if (res.StatusCode == http.StatusOK || res.StatusCode >= http.StatusInternalServerError) && !isJSON(res) {
+		res.Body.Close()
+		h.logger.Warn("Upstream returned a non-JSON response",
+			slog.String("path", path),
+			slog.Int("status", res.StatusCode),
+			slog.String("content_type", res.Header.Get("Content-Type")),
+		)
+		return nil, newAPIError(http.StatusBadGateway, codeUpstreamMalformed, "External API returned a non-JSON response")
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		return nil, upstreamStatusError(res, key)
+	}
+	return res, nil
+}
+
+// isJSON reports whether res declares a JSON body. A missing Content-Type is
+// given the benefit of the doubt.
+func isJSON(res *http.Response) bool {
+	ct := res.Header.Get("Content-Type")
+	if ct == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// getData fetches path and extracts the "data" object of the payload.
+func (h *httpMMRClient) getData(ctx context.Context, region, path string) (map[string]interface{}, *apiError) {
+	result, lerr := h.getPayload(ctx, region, path)
+	if lerr != nil {
+		return nil, lerr
+	}
+
+	// Cache entries are objects, so list payloads are stored under "items".
+	switch d := result["data"].(type) {
+	case map[string]interface{}:
+		return d, nil
+	case []interface{}:
+		return map[string]interface{}{"items": d}, nil
+	}
+	return nil, newAPIError(http.StatusBadGateway, codeUpstreamEmpty, "External API returned no data")
+}
+
+// getPayload fetches and decodes path. A body that fails to decode is most
+// often a truncated one, so it is fetched again up to maxDecodeRetries times
+// while the retry budget and the request deadline allow.
+func (h *httpMMRClient) getPayload(ctx context.Context, region, path string) (map[string]interface{}, *apiError) {
+	for attempt := 0; ; attempt++ {
+		res, lerr := h.get(ctx, region, path)
+		if lerr != nil {
+			return nil, lerr
+		}
+
+		var result map[string]interface{}
+		err := json.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err == nil {
+			return result, nil
+		}
+
+		upstreamRetryBudget.failure()
+		if attempt >= maxDecodeRetries || !upstreamRetryBudget.canRetry() || !hasHeadroom(ctx, minUpstreamHeadroom) {
+			return nil, newAPIError(http.StatusBadGateway, codeUpstreamMalformed, "Failed to parse API response")
+		}
+		h.logger.Warn("Upstream response failed to decode, fetching again",
+			slog.String("path", path),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// offlineMMRClient is the MMRClient used with OFFLINE=true. It never contacts
+// upstream, so only what is already cached can be served.
+type offlineMMRClient struct{}
+
+func offlineError() *apiError {
+	return newAPIError(http.StatusServiceUnavailable, codeCacheMissOffline, "Not cached, and the server is offline")
+}
+
+func (offlineMMRClient) GetMMR(context.Context, string, string, string) (map[string]interface{}, *apiError) {
+	return nil, offlineError()
+}
+
+func (offlineMMRClient) GetAccount(context.Context, string, string, string) (map[string]interface{}, *apiError) {
+	return nil, offlineError()
+}
+
+func (offlineMMRClient) GetHistory(context.Context, string, string, string) (map[string]interface{}, *apiError) {
+	return nil, offlineError()
+}
+
+func (offlineMMRClient) GetLeaderboard(context.Context, string) (io.ReadCloser, *apiError) {
+	return nil, offlineError()
+}
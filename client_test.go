@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeResult is a canned answer of fakeMMRClient.
+type fakeResult func(region, name, tag string) (map[string]interface{}, *apiError)
+
+// fakeMMRClient is an MMRClient answering from functions instead of HTTP.
+// Methods without a function answer like an offline client. Every call is
+// counted.
+type fakeMMRClient struct {
+	mmr, account, history fakeResult
+	leaderboard           func(region string) (io.ReadCloser, *apiError)
+
+	calls atomic.Int64
+}
+
+func (f *fakeMMRClient) GetMMR(_ context.Context, region, name, tag string) (map[string]interface{}, *apiError) {
+	return f.answer(f.mmr, region, name, tag)
+}
+
+func (f *fakeMMRClient) GetAccount(_ context.Context, region, name, tag string) (map[string]interface{}, *apiError) {
+	return f.answer(f.account, region, name, tag)
+}
+
+func (f *fakeMMRClient) GetHistory(_ context.Context, region, name, tag string) (map[string]interface{}, *apiError) {
+	return f.answer(f.history, region, name, tag)
+}
+
+func (f *fakeMMRClient) GetLeaderboard(_ context.Context, region string) (io.ReadCloser, *apiError) {
+	f.calls.Add(1)
+	if f.leaderboard == nil {
+		return nil, offlineError()
+	}
+	return f.leaderboard(region)
+}
+
+func (f *fakeMMRClient) answer(fn fakeResult, region, name, tag string) (map[string]interface{}, *apiError) {
+	f.calls.Add(1)
+	if fn == nil {
+		return nil, offlineError()
+	}
+	return fn(region, name, tag)
+}
+
+// rankData is an MMR data payload for a player at tier on rr, peaking at
+// peak.
+func rankData(tier int, patched string, rr float64, peak string) map[string]interface{} {
+	return map[string]interface{}{
+		"current_data": map[string]interface{}{
+			"currenttier":        float64(tier),
+			"currenttierpatched": patched,
+			"ranking_in_tier":    rr,
+		},
+		"highest_rank": map[string]interface{}{"patched_tier": peak},
+	}
+}
+
+// returns is a fakeResult always answering data.
+func returns(data map[string]interface{}) fakeResult {
+	return func(string, string, string) (map[string]interface{}, *apiError) { return data, nil }
+}
+
+// fails is a fakeResult always failing the way the HTTP client reports an
+// upstream answering status.
+func fails(status int) fakeResult {
+	return func(string, string, string) (map[string]interface{}, *apiError) {
+		return nil, upstreamStatusError(&http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(""))}, "")
+	}
+}
+
+// newFakeServer builds a Server with the default configuration getting its
+// data from fake.
+func newFakeServer(t *testing.T, fake MMRClient) *Server {
+	t.Helper()
+	return NewServer(testConfig(t, "http://upstream.invalid"), discardLogger(), fake)
+}
+
+func TestMalformedUpstreamIsBadGateway(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+	}{
+		{"not json", "text/html"},
+		{"truncated json", "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				io.WriteString(w, `{"status":200,"data":{`)
+			})
+			s := newHTTPServer(t, cfg)
+
+			w := serve(s.Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+			if w.Code != http.StatusBadGateway {
+				t.Fatalf("status = %d, want 502: %s", w.Code, w.Body)
+			}
+			if body := decodeBody(t, w.Body.Bytes()); body["code"] != codeUpstreamMalformed {
+				t.Errorf("code = %v, want %s", body["code"], codeUpstreamMalformed)
+			}
+		})
+	}
+}
+
+func TestUpstreamMaintenancePage(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		wantStatus  int
+		wantCode    string
+	}{
+		{"html 503", http.StatusServiceUnavailable, "text/html; charset=utf-8", http.StatusBadGateway, codeUpstreamMalformed},
+		{"html 200", http.StatusOK, "text/html", http.StatusBadGateway, codeUpstreamMalformed},
+		{"html 404", http.StatusNotFound, "text/html", http.StatusNotFound, codePlayerNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &maxUpstreamRetries, 0)
+			cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				io.WriteString(w, "<html><body><h1>Down for maintenance</h1></body></html>")
+			})
+			s := newHTTPServer(t, cfg)
+
+			w := serve(s.Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if body := decodeBody(t, w.Body.Bytes()); body["code"] != tt.wantCode {
+				t.Errorf("code = %v, want %s", body["code"], tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestIsJSON(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{"", true},
+		{"application/json", true},
+		{"application/json; charset=utf-8", true},
+		{"application/problem+json", true},
+		{"text/html", false},
+		{"text/plain", false},
+		{"application/json;;", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			res := &http.Response{Header: http.Header{}}
+			if tt.contentType != "" {
+				res.Header.Set("Content-Type", tt.contentType)
+			}
+			if got := isJSON(res); got != tt.want {
+				t.Errorf("isJSON(%q) = %v, want %v", tt.contentType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOfflineMode(t *testing.T) {
+	cfg := testConfig(t, "http://upstream.invalid")
+	cfg.Offline = true
+	s := NewServer(cfg, discardLogger(), offlineMMRClient{})
+	s.cache.set(mmrCacheKey("eu", "cached", "bar", false), rankData(15, "Platinum 1", 45, "Diamond 2"))
+	h := s.Handler()
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantCode   string
+	}{
+		{"cached", "/rest/v1/rank/eu/cached/bar", http.StatusOK, ""},
+		{"cached, extras missing", "/rest/v1/rank/eu/cached/bar?level=true", http.StatusOK, ""},
+		{"not cached", "/rest/v1/rank/eu/missing/bar", http.StatusServiceUnavailable, codeCacheMissOffline},
+		{"leaderboard", "/rest/v1/leaderboard/eu", http.StatusServiceUnavailable, codeCacheMissOffline},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, http.MethodGet, tt.target, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			body := decodeBody(t, w.Body.Bytes())
+			if tt.wantCode == "" {
+				if body["message"] != "Platinum 1 [45RR] | Peak: Diamond 2" || body["cached"] != true {
+					t.Errorf("body = %v, want the cached rank", body)
+				}
+				return
+			}
+			if body["code"] != tt.wantCode {
+				t.Errorf("code = %v, want %s", body["code"], tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAPIKeyRotation(t *testing.T) {
+	tests := []struct {
+		name      string
+		secondary string
+		// accepted are the keys upstream takes; others get reject.
+		accepted []string
+		reject   int
+
+		wantStatus int
+		// wantKeys are the keys sent by the first request, then the second.
+		wantKeys  []string
+		wantAgain []string
+		wantWarn  bool
+	}{
+		{"primary accepted", "new", []string{"old", "new"}, http.StatusUnauthorized, http.StatusOK, []string{"old"}, []string{"old"}, false},
+		{"primary rejected with 401", "new", []string{"new"}, http.StatusUnauthorized, http.StatusOK, []string{"old", "new"}, []string{"new"}, true},
+		{"primary rejected with 403", "new", []string{"new"}, http.StatusForbidden, http.StatusOK, []string{"old", "new"}, []string{"new"}, true},
+		{"both rejected", "new", nil, http.StatusUnauthorized, http.StatusUnauthorized, []string{"old", "new"}, []string{"old", "new"}, false},
+		{"no secondary", "", nil, http.StatusForbidden, http.StatusForbidden, []string{"old"}, []string{"old"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu   sync.Mutex
+				keys []string
+			)
+			cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				key := r.URL.Query().Get("api_key")
+				mu.Lock()
+				keys = append(keys, key)
+				mu.Unlock()
+				if !slices.Contains(tt.accepted, key) {
+					writeJSON(w, tt.reject, `{"status":`+strconv.Itoa(tt.reject)+`}`)
+					return
+				}
+				writeJSON(w, http.StatusOK, mmrBody)
+			})
+			cfg.APIKey = "old"
+			cfg.APIKeySecondary = tt.secondary
+			var logs strings.Builder
+			client := newHTTPMMRClient(cfg, slog.New(slog.NewTextHandler(&logs, nil)))
+			h := NewServer(cfg, discardLogger(), client).Handler()
+
+			for i, want := range [][]string{tt.wantKeys, tt.wantAgain} {
+				// Distinct players keep the second request off the cache.
+				w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/"+strconv.Itoa(i), "")
+				if w.Code != tt.wantStatus {
+					t.Fatalf("request %d: status = %d, want %d: %s", i, w.Code, tt.wantStatus, w.Body)
+				}
+				mu.Lock()
+				if !slices.Equal(keys, want) {
+					t.Errorf("request %d sent keys %q, want %q", i, keys, want)
+				}
+				keys = nil
+				mu.Unlock()
+			}
+			if warned := strings.Contains(logs.String(), "switched keys"); warned != tt.wantWarn {
+				t.Errorf("logged the switch = %v, want %v: %s", warned, tt.wantWarn, logs.String())
+			}
+		})
+	}
+}
+
+func TestEmptyUpstreamData(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"no data", `{"status":200}`},
+		{"null data", `{"status":200,"data":null}`},
+		{"data not an object", `{"status":200,"data":"Platinum 1"}`},
+		{"data without current_data", `{"status":200,"data":{"name":"foo","tag":"bar"}}`},
+		{"list data", `{"status":200,"data":[]}`},
+		{"empty object", `{}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int64
+			cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				writeJSON(w, http.StatusOK, tt.body)
+			})
+			h := newHTTPServer(t, cfg).Handler()
+
+			for range 2 {
+				w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+				if w.Code != http.StatusBadGateway {
+					t.Fatalf("status = %d, want 502: %s", w.Code, w.Body)
+				}
+				body := decodeBody(t, w.Body.Bytes())
+				if body["code"] != codeUpstreamEmpty || body["error"] == "" {
+					t.Errorf("body = %v, want %s with a message", body, codeUpstreamEmpty)
+				}
+			}
+			if n := calls.Load(); n != 2 {
+				t.Errorf("upstream calls = %d, want an empty answer never cached", n)
+			}
+		})
+	}
+}
+
+func TestDecodeRetry(t *testing.T) {
+	const truncated = `{"status":200,"data":{"current_data":`
+	tests := []struct {
+		name string
+		// bodies are answered in turn, the last one repeating.
+		bodies        []string
+		decodeRetries int
+		drainBudget   bool
+		wantStatus    int
+		wantCalls     int64
+	}{
+		{"valid", []string{mmrBody}, 1, false, http.StatusOK, 1},
+		{"truncated then valid", []string{truncated, mmrBody}, 1, false, http.StatusOK, 2},
+		{"truncated every time", []string{truncated}, 1, false, http.StatusBadGateway, 2},
+		{"more retries allowed", []string{truncated, truncated, mmrBody}, 2, false, http.StatusOK, 3},
+		{"retries disabled", []string{truncated, mmrBody}, 0, false, http.StatusBadGateway, 1},
+		{"retry budget spent", []string{truncated, mmrBody}, 1, true, http.StatusBadGateway, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &maxDecodeRetries, tt.decodeRetries)
+			var calls atomic.Int64
+			cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				n := int(calls.Add(1))
+				writeJSON(w, http.StatusOK, tt.bodies[min(n, len(tt.bodies))-1])
+			})
+			if tt.drainBudget {
+				for range 5 {
+					upstreamRetryBudget.failure()
+				}
+			}
+
+			w := serve(newHTTPServer(t, cfg).Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if body := decodeBody(t, w.Body.Bytes()); body["code"] != codeUpstreamMalformed {
+					t.Errorf("code = %v, want %s", body["code"], codeUpstreamMalformed)
+				}
+			}
+			if n := calls.Load(); n != tt.wantCalls {
+				t.Errorf("upstream calls = %d, want %d", n, tt.wantCalls)
+			}
+		})
+	}
+}
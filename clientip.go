@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseTrustedProxies parses TRUSTED_PROXIES, comma separated addresses or
+// CIDR ranges of the proxies allowed to report the client address in
+// X-Forwarded-For or X-Real-IP. An empty list trusts no proxy, so the client
+// is always the peer of the connection.
+func parseTrustedProxies(list string) ([]string, error) {
+	var proxies []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if _, err := netip.ParsePrefix(p); err != nil {
+			if _, err := netip.ParseAddr(p); err != nil {
+				return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q, expected an IP address or CIDR range", p)
+			}
+		}
+		proxies = append(proxies, p)
+	}
+	return proxies, nil
+}
+
+// clientKey identifies the caller for quota purposes by its address, as
+// seen through the trusted proxies only. Headers a client can set freely,
+// such as X-API-Key, are not used, or every new value would get a fresh
+// quota.
+func clientKey(c *gin.Context) string {
+	return c.ClientIP()
+}
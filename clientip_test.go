@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestClientKeyIgnoresSpoofedHeaders(t *testing.T) {
+	// step is a request from addr with header set, and the status it gets.
+	type step struct {
+		addr   string
+		header []string
+		want   int
+	}
+	const proxy, direct = "198.51.100.1:40000", "192.0.2.7:40000"
+	tests := []struct {
+		name    string
+		trusted string
+		steps   []step
+	}{
+		{"no trusted proxies", "", []step{
+			{proxy, nil, http.StatusOK},
+			{proxy, nil, http.StatusOK},
+			{proxy, nil, http.StatusTooManyRequests},
+			{proxy, []string{"X-API-Key", "fresh"}, http.StatusTooManyRequests},
+			{proxy, []string{"X-Forwarded-For", "203.0.113.9"}, http.StatusTooManyRequests},
+			{proxy, []string{"X-Real-IP", "203.0.113.9"}, http.StatusTooManyRequests},
+			{direct, nil, http.StatusOK},
+		}},
+		{"trusted proxy", "198.51.100.0/24", []step{
+			{proxy, []string{"X-Forwarded-For", "203.0.113.1"}, http.StatusOK},
+			{proxy, []string{"X-Forwarded-For", "203.0.113.1"}, http.StatusOK},
+			{proxy, []string{"X-Forwarded-For", "203.0.113.1", "X-API-Key", "fresh"}, http.StatusTooManyRequests},
+			{proxy, []string{"X-Forwarded-For", "203.0.113.2"}, http.StatusOK},
+			// Only the proxy may name the client.
+			{direct, []string{"X-Forwarded-For", "203.0.113.3"}, http.StatusOK},
+			{direct, []string{"X-Forwarded-For", "203.0.113.4"}, http.StatusOK},
+			{direct, []string{"X-Forwarded-For", "203.0.113.5"}, http.StatusTooManyRequests},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", tt.trusted)
+			cfg := testConfig(t, "http://upstream.invalid")
+			cfg.RateLimit, cfg.RateLimitWindow, cfg.RateLimitMode = 2, time.Minute, "all"
+			fake := &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))}
+			h := NewServer(cfg, discardLogger(), fake).Handler()
+
+			for i, st := range tt.steps {
+				if w := serveFrom(h, st.addr, http.MethodGet, "/rest/v1/rank/eu/foo/bar", "", st.header...); w.Code != st.want {
+					t.Errorf("step %d, %s %v: status = %d, want %d", i, st.addr, st.header, w.Code, st.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchQuotaIgnoresSpoofedHeaders(t *testing.T) {
+	setVar(t, &batchQuotaLimit, 4)
+	h := newFakeServer(t, &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))}).Handler()
+	if w := serve(h, http.MethodPost, "/rest/v1/ranks", batchBody(4)); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	for _, header := range [][]string{{"X-API-Key", "fresh"}, {"X-Forwarded-For", "203.0.113.9"}} {
+		if w := serve(h, http.MethodPost, "/rest/v1/ranks", batchBody(1), header...); w.Code != http.StatusTooManyRequests {
+			t.Errorf("with %v: status = %d, want the exhausted quota kept", header, w.Code)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"10.0.0.1", []string{"10.0.0.1"}, false},
+		{" 10.0.0.0/8 , ::1,fd00::/8 ", []string{"10.0.0.0/8", "::1", "fd00::/8"}, false},
+		{"proxy.internal", nil, true},
+		{"10.0.0.0/33", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTrustedProxies(tt.list)
+		if (err != nil) != tt.wantErr || !slices.Equal(got, tt.want) {
+			t.Errorf("parseTrustedProxies(%q) = %q, %v, want %q and an error: %v", tt.list, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
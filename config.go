@@ -0,0 +1,360 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envInt reads an integer from the environment, falling back to def when unset
+// or malformed.
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+// envDuration reads a time.ParseDuration formatted value from the environment,
+// falling back to def when unset or malformed.
+func envDuration(key string, def time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+// envFloat reads a floating point number from the environment, falling back
+// to def when unset or malformed.
+func envFloat(key string, def float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return v
+	}
+	return def
+}
+
+// validatePort checks that port is a numeric TCP port between 1 and 65535.
+func validatePort(port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("PORT must be a number between 1 and 65535, got %q", port)
+	}
+	return nil
+}
+
+// validateNonNegative rejects a negative value of the setting named key.
+func validateNonNegative[T int | time.Duration](key string, v T) error {
+	if v < 0 {
+		return fmt.Errorf("%s cannot be negative, got %v", key, v)
+	}
+	return nil
+}
+
+// Config is the server level configuration, read from the environment once
+// at startup by loadConfig. It holds what differs between Server instances;
+// process wide tuning knobs that belong to a single component stay next to
+// that component.
+type Config struct {
+	Port   string
+	APIKey string
+	// APIKeySecondary, when set, is tried whenever upstream rejects APIKey,
+	// so keys can be rotated without downtime.
+	APIKeySecondary string
+	ClientAPIKey    string
+	// TrustedProxies are the addresses and CIDR ranges whose forwarding
+	// headers are believed when identifying clients. Empty trusts none.
+	TrustedProxies []string
+
+	// Regions is the set of accepted regions and their metadata.
+	Regions map[string]regionInfo
+	// DefaultRegion, when set, is used for requests that name no region and
+	// enables the region-less rank route.
+	DefaultRegion string
+	// UpstreamBaseURL is where henrikdev requests go unless RegionBaseURLs
+	// overrides it for the region.
+	UpstreamBaseURL string
+	RegionBaseURLs  map[string]string
+	// UpstreamFallbackURL, when set, is tried once whenever the primary base
+	// URL fails with a connection error or a 5xx.
+	UpstreamFallbackURL string
+	// Offline serves from cache only and never contacts upstream.
+	Offline bool
+	// MMRVersion is the henrikdev MMR endpoint version, "v2" or "v3", and
+	// MMRPath the upstream path template of MMR lookups.
+	MMRVersion string
+	MMRPath    pathTemplate
+	// UpstreamHeaders are added to every upstream request, for proxies in
+	// front of henrikdev that expect their own headers.
+	UpstreamHeaders http.Header
+	// UpstreamQueryAllowlist holds the query parameters, such as henrikdev's
+	// platform, that are copied from client requests onto upstream URLs. Any
+	// other parameter stays with this server.
+	UpstreamQueryAllowlist map[string]bool
+	// UpstreamAttemptTimeout bounds each upstream call on its own, so a hung
+	// attempt leaves REQUEST_TIMEOUT room for a retry. Zero leaves attempts
+	// bounded only by the request budget and the HTTP client timeout.
+	UpstreamAttemptTimeout time.Duration
+	// UpstreamQuotaLowWater is the remaining upstream quota, as reported by
+	// henrikdev's x-ratelimit headers, at or below which outbound calls are
+	// paced out over the rest of the quota window instead of running into a
+	// 429. Zero disables pacing; the headers are still tracked for
+	// /healthz/detailed.
+	UpstreamQuotaLowWater int
+
+	// CacheTTL is the lifetime of a cached player lookup.
+	CacheTTL time.Duration
+	// CacheCoalesceWindow delays the first fetch of a missing key by this
+	// long so near-simultaneous requests for it share one upstream call even
+	// when they do not quite overlap. It only applies with
+	// CACHE_LOCK_GRANULARITY=key. Zero disables the delay.
+	CacheCoalesceWindow time.Duration
+	// FoldNameCase makes cache keys case-insensitive in the player's name
+	// and tag, so "Foo#EUW" and "foo#euw" share an entry. Only the key is
+	// folded: upstream is always sent the name and tag as the client typed
+	// them.
+	FoldNameCase bool
+	// RateLimit caps the requests a client may make to /rest/v1 per
+	// RateLimitWindow; zero disables it. RateLimitMode is what counts
+	// against it: "all" requests, or only the "upstream" lookups a request
+	// causes, so cache hits are always served.
+	RateLimit       int
+	RateLimitWindow time.Duration
+	RateLimitMode   string
+	// StrictQuery makes requests with query parameters no route reads fail
+	// with 400 instead of ignoring them, so client typos surface.
+	StrictQuery bool
+
+	ValidateAPIKey      bool
+	StrictStartup       bool
+	StartupProbeTimeout time.Duration
+
+	WarmConnections        bool
+	WarmConnectionsTimeout time.Duration
+
+	SecurityHeaders       bool
+	ReferrerPolicy        string
+	ContentSecurityPolicy string
+
+	// V1Sunset is the announced removal date of /rest/v1, zero when unset.
+	V1Sunset time.Time
+
+	SlowRequestThreshold time.Duration
+	ShutdownTimeout      time.Duration
+
+	// Connection level limits of the HTTP server. WriteTimeout must leave
+	// room for REQUEST_TIMEOUT and leaderboard streaming.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+}
+
+// loadConfig reads Config from the environment, applying defaults, and
+// validates it together with the component settings that are checked at
+// startup. Every problem found is reported, not just the first.
+func loadConfig() (Config, error) {
+	cfg := Config{
+		Port:            cmp.Or(os.Getenv("PORT"), "8080"),
+		APIKey:          os.Getenv("VALORANT_API_KEY"),
+		APIKeySecondary: os.Getenv("VALORANT_API_KEY_SECONDARY"),
+		ClientAPIKey:    os.Getenv("CLIENT_API_KEY"),
+
+		Regions:             parseRegions(os.Getenv("VALID_REGIONS"), defaultRegions),
+		DefaultRegion:       strings.ToLower(strings.TrimSpace(os.Getenv("DEFAULT_REGION"))),
+		UpstreamBaseURL:     strings.TrimSuffix(cmp.Or(os.Getenv("UPSTREAM_BASE_URL"), "https://api.henrikdev.xyz"), "/"),
+		RegionBaseURLs:      make(map[string]string),
+		UpstreamFallbackURL: strings.TrimSuffix(os.Getenv("UPSTREAM_FALLBACK_URL"), "/"),
+		Offline:             os.Getenv("OFFLINE") == "true",
+		MMRVersion:          cmp.Or(os.Getenv("UPSTREAM_MMR_VERSION"), "v2"),
+
+		UpstreamQueryAllowlist: parseQueryAllowlist(os.Getenv("UPSTREAM_QUERY_ALLOWLIST")),
+		UpstreamAttemptTimeout: envDuration("UPSTREAM_ATTEMPT_TIMEOUT", 0),
+		UpstreamQuotaLowWater:  envInt("UPSTREAM_QUOTA_LOW_WATER", 0),
+
+		CacheTTL:            envDuration("CACHE_TTL", defaultCacheTTL),
+		CacheCoalesceWindow: envDuration("CACHE_COALESCE_WINDOW", 0),
+		FoldNameCase:        os.Getenv("CACHE_FOLD_CASE") == "true",
+		RateLimit:           envInt("RATE_LIMIT", 0),
+		RateLimitWindow:     envDuration("RATE_LIMIT_WINDOW", time.Minute),
+		RateLimitMode:       cmp.Or(os.Getenv("RATE_LIMIT_MODE"), "all"),
+		StrictQuery:         os.Getenv("STRICT_QUERY_PARAMS") == "true",
+
+		ValidateAPIKey:      os.Getenv("VALIDATE_API_KEY") == "true",
+		StrictStartup:       os.Getenv("STRICT_STARTUP") == "true",
+		StartupProbeTimeout: envDuration("STARTUP_PROBE_TIMEOUT", 3*time.Second),
+
+		WarmConnections:        os.Getenv("WARM_CONNECTIONS") == "true",
+		WarmConnectionsTimeout: envDuration("WARM_CONNECTIONS_TIMEOUT", 2*time.Second),
+
+		SecurityHeaders:       os.Getenv("SECURITY_HEADERS") != "false",
+		ReferrerPolicy:        cmp.Or(os.Getenv("REFERRER_POLICY"), "no-referrer"),
+		ContentSecurityPolicy: os.Getenv("CONTENT_SECURITY_POLICY"),
+
+		SlowRequestThreshold: envDuration("SLOW_REQUEST_THRESHOLD", 3*time.Second),
+		ShutdownTimeout:      envDuration("SHUTDOWN_TIMEOUT", 10*time.Second),
+
+		ReadHeaderTimeout: envDuration("SERVER_READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       envDuration("SERVER_READ_TIMEOUT", 15*time.Second),
+		WriteTimeout:      envDuration("SERVER_WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:       envDuration("SERVER_IDLE_TIMEOUT", 2*time.Minute),
+	}
+
+	for region := range cfg.Regions {
+		if v := os.Getenv("UPSTREAM_BASE_URL_" + strings.ToUpper(region)); v != "" {
+			cfg.RegionBaseURLs[region] = strings.TrimSuffix(v, "/")
+		}
+	}
+
+	cfg.MMRPath = newMMRTemplate(cfg.MMRVersion)
+
+	var errs []error
+	if err := validatePort(cfg.Port); err != nil {
+		errs = append(errs, err)
+	}
+	headers, err := parseUpstreamHeaders(os.Getenv("UPSTREAM_HEADERS"))
+	if err != nil {
+		errs = append(errs, err)
+	}
+	cfg.UpstreamHeaders = headers
+	proxies, err := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		errs = append(errs, err)
+	}
+	cfg.TrustedProxies = proxies
+	if cfg.DefaultRegion != "" {
+		region, ok := canonicalRegion(cfg.DefaultRegion, cfg.Regions)
+		if !ok {
+			errs = append(errs, fmt.Errorf("DEFAULT_REGION %q is not one of VALID_REGIONS", cfg.DefaultRegion))
+		}
+		cfg.DefaultRegion = region
+	}
+	if v := os.Getenv("V1_SUNSET"); v != "" {
+		sunset, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid V1_SUNSET %q, expected YYYY-MM-DD", v))
+		}
+		cfg.V1Sunset = sunset
+	}
+	errs = append(errs,
+		loadLocale(),
+		loadDenylist(),
+		loadRoster(),
+		validateUnrankedStatus(),
+		validateRateLimitMode(cfg.RateLimitMode),
+		validateMMRVersion(cfg.MMRVersion),
+		cfg.MMRPath.validate(),
+		validatePathTemplates(),
+		validateCacheLockGranularity(),
+		validateQueryAllowlist(cfg.UpstreamQueryAllowlist),
+		validateNonNegative("CACHE_COALESCE_WINDOW", cfg.CacheCoalesceWindow),
+		validateNonNegative("UPSTREAM_ATTEMPT_TIMEOUT", cfg.UpstreamAttemptTimeout),
+		validateNonNegative("UPSTREAM_QUOTA_LOW_WATER", cfg.UpstreamQuotaLowWater),
+	)
+	return cfg, errors.Join(errs...)
+}
+
+// redactURL masks the password of any userinfo in raw so it can be logged.
+// Values that do not parse are logged as a placeholder, since they may hold
+// credentials in a form url.Parse rejects.
+func redactURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "<unparseable>"
+	}
+	return u.Redacted()
+}
+
+// redactURLs applies redactURL to every value of urls.
+func redactURLs(urls map[string]string) map[string]string {
+	out := make(map[string]string, len(urls))
+	for k, v := range urls {
+		out[k] = redactURL(v)
+	}
+	return out
+}
+
+// logConfig emits a single line summarising the effective configuration so
+// misconfiguration is visible at boot. Secrets are never logged, only whether
+// they are set.
+func logConfig(logger *slog.Logger, cfg Config) {
+	logger.Info("Effective configuration",
+		slog.String("cache_ttl", cfg.CacheTTL.String()),
+		slog.Float64("cache_ttl_jitter", cacheTTLJitter),
+		slog.Bool("cache_adaptive_ttl", adaptiveTTL),
+		slog.String("negative_cache_ttl", negativeTTL.String()),
+		slog.String("cache_janitor_interval", janitorInterval.String()),
+		slog.String("cache_snapshot_path", snapshotPath),
+		slog.String("cache_tombstone_ttl", tombstoneTTL.String()),
+		slog.Int("cache_export_max_entries", cacheExportLimit),
+		slog.Int("cache_shards", cacheShards),
+		slog.String("cache_lock_granularity", cacheLockGranularity),
+		slog.String("cache_coalesce_window", cfg.CacheCoalesceWindow.String()),
+		slog.Bool("cache_fold_case", cfg.FoldNameCase),
+		slog.Float64("cache_health_min_hit_ratio", cacheHealthMinRatio),
+		slog.String("cache_health_window", cacheHealthWindow.String()),
+		slog.String("leaderboard_cache_ttl", leaderboardTTL.String()),
+		slog.Int("leaderboard_cache_max_entries", leaderboardCacheLimit),
+		slog.Int("hot_key_threshold", hotThreshold),
+		slog.Int("hot_key_max", maxHotRefreshes),
+		slog.String("request_timeout", requestBudget.String()),
+		slog.String("upstream_min_headroom", minUpstreamHeadroom.String()),
+		slog.Any("valid_regions", slices.Sorted(maps.Keys(cfg.Regions))),
+		slog.String("default_region", cfg.DefaultRegion),
+		slog.String("upstream_base_url", redactURL(cfg.UpstreamBaseURL)),
+		slog.Any("upstream_region_overrides", redactURLs(cfg.RegionBaseURLs)),
+		slog.String("upstream_fallback_url", redactURL(cfg.UpstreamFallbackURL)),
+		slog.Bool("offline", cfg.Offline),
+		slog.String("upstream_mmr_version", cfg.MMRVersion),
+		slog.String("upstream_mmr_path", cfg.MMRPath.tmpl),
+		slog.Bool("forward_upstream_errors", forwardUpstreamErrors),
+		slog.Int("upstream_max_retries", maxUpstreamRetries),
+		slog.Int("upstream_decode_retries", maxDecodeRetries),
+		slog.String("upstream_attempt_timeout", cfg.UpstreamAttemptTimeout.String()),
+		slog.Bool("upstream_attempt_log", logUpstreamAttempts),
+		slog.Int("upstream_quota_low_water", cfg.UpstreamQuotaLowWater),
+		slog.Any("upstream_query_allowlist", slices.Sorted(maps.Keys(cfg.UpstreamQueryAllowlist))),
+		slog.Any("upstream_headers", slices.Sorted(maps.Keys(cfg.UpstreamHeaders))),
+		slog.Bool("strict_query_params", cfg.StrictQuery),
+		slog.Float64("retry_budget_tokens", upstreamRetryBudget.max),
+		slog.Int("batch_max_size", maxBatchSize),
+		slog.Int("batch_quota", batchQuotaLimit),
+		slog.String("batch_quota_window", batchQuotaWindow.String()),
+		slog.Int("rate_limit", cfg.RateLimit),
+		slog.String("rate_limit_window", cfg.RateLimitWindow.String()),
+		slog.String("rate_limit_mode", cfg.RateLimitMode),
+		slog.Int("rate_limit_max_buckets", maxQuotaClients),
+		slog.Any("trusted_proxies", cfg.TrustedProxies),
+		slog.Int("batch_max_concurrency", batchConcurrency),
+		slog.String("default_lang", defaultLanguage.String()),
+		slog.String("default_tz", defaultLocation.String()),
+		slog.Int("denylist_entries", denylistLen()),
+		slog.String("denylist_match", denylistMatch),
+		slog.Int("roster_teams", len(rosterTeams())),
+		slog.String("port", cfg.Port),
+		slog.Bool("security_headers", cfg.SecurityHeaders),
+		slog.Bool("warm_connections", cfg.WarmConnections),
+		slog.String("shutdown_timeout", cfg.ShutdownTimeout.String()),
+		slog.String("server_read_header_timeout", cfg.ReadHeaderTimeout.String()),
+		slog.String("server_read_timeout", cfg.ReadTimeout.String()),
+		slog.String("server_write_timeout", cfg.WriteTimeout.String()),
+		slog.String("server_idle_timeout", cfg.IdleTimeout.String()),
+		slog.Int("error_log_size", errorLogSize),
+		slog.Int("recent_lookups_size", lookupLogSize),
+		slog.Int("estimate_games", estimateGames),
+		slog.Bool("api_key_set", cfg.APIKey != ""),
+		slog.Bool("api_key_secondary_set", cfg.APIKeySecondary != ""),
+		slog.Bool("client_api_key_set", cfg.ClientAPIKey != ""),
+	)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	denylistPath = os.Getenv("DENYLIST_PATH")
+	// denylistMatch is how entries are compared to names: "exact", "substring"
+	// or "wildcard" (shell style * and ? patterns).
+	denylistMatch = cmp.Or(os.Getenv("DENYLIST_MATCH"), "exact")
+
+	// deniedNames is swapped whole by loadDenylist, at startup and on every
+	// reload, so a lookup always checks one complete list.
+	deniedNames atomic.Pointer[[]string]
+)
+
+// normalizeName folds a player name for denylist comparison so case and
+// Unicode composition cannot be used to slip past an entry.
+func normalizeName(name string) string {
+	return strings.ToLower(norm.NFC.String(strings.TrimSpace(name)))
+}
+
+// loadDenylist reads DENYLIST_PATH, one name or pattern per line. Blank lines
+// and lines starting with # are ignored. It is called at startup and on
+// every reload; a list that fails to load leaves the previous one in place.
+func loadDenylist() error {
+	switch denylistMatch {
+	case "exact", "substring", "wildcard":
+	default:
+		return fmt.Errorf("invalid DENYLIST_MATCH %q, expected exact, substring or wildcard", denylistMatch)
+	}
+	if denylistPath == "" {
+		deniedNames.Store(&[]string{})
+		return nil
+	}
+
+	f, err := os.Open(denylistPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry := normalizeName(line)
+		if denylistMatch == "wildcard" {
+			if _, err := path.Match(entry, ""); err != nil {
+				return fmt.Errorf("invalid denylist pattern %q: %w", line, err)
+			}
+		}
+		names = append(names, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	deniedNames.Store(&names)
+	return nil
+}
+
+// denylistLen returns how many entries the current denylist has.
+func denylistLen() int {
+	if names := deniedNames.Load(); names != nil {
+		return len(*names)
+	}
+	return 0
+}
+
+// isDenied reports whether name matches a denylist entry.
+func isDenied(name string) bool {
+	names := deniedNames.Load()
+	if names == nil {
+		return false
+	}
+	name = normalizeName(name)
+	for _, entry := range *names {
+		var match bool
+		switch denylistMatch {
+		case "substring":
+			match = strings.Contains(name, entry)
+		case "wildcard":
+			match, _ = path.Match(entry, name)
+		default:
+			match = name == entry
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
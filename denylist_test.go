@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useDenylist loads lines as the denylist in mode for the duration of a
+// test.
+func useDenylist(t *testing.T, mode string, lines ...string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "denylist.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	old := deniedNames.Load()
+	t.Cleanup(func() { deniedNames.Store(old) })
+	setVar(t, &denylistPath, path)
+	setVar(t, &denylistMatch, mode)
+	if err := loadDenylist(); err != nil {
+		t.Fatalf("loadDenylist: %v", err)
+	}
+}
+
+func TestIsDenied(t *testing.T) {
+	lines := []string{"# moderation list", "", "BadGuy", "  troll*  ", "x?z"}
+	tests := []struct {
+		mode, name string
+		want       bool
+	}{
+		{"exact", "badguy", true},
+		{"exact", " BADGUY ", true},
+		{"exact", "badguy2", false},
+		{"exact", "# moderation list", false},
+		{"substring", "the badguy here", true},
+		{"substring", "goodguy", false},
+		{"wildcard", "trollface", true},
+		{"wildcard", "xyz", true},
+		{"wildcard", "xyyz", false},
+		{"wildcard", "atroll", false},
+		{"exact", "José", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+"/"+tt.name, func(t *testing.T) {
+			useDenylist(t, tt.mode, lines...)
+			if got := isDenied(tt.name); got != tt.want {
+				t.Errorf("isDenied(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDenylistNormalizesComposition(t *testing.T) {
+	useDenylist(t, "exact", "Jos\u00e9")
+	if !isDenied("Jose\u0301") {
+		t.Error("a decomposed spelling slipped past the denylist")
+	}
+}
+
+func TestLoadDenylistErrors(t *testing.T) {
+	useDenylist(t, "exact", "badguy")
+
+	setVar(t, &denylistMatch, "regex")
+	if err := loadDenylist(); err == nil || !strings.Contains(err.Error(), "DENYLIST_MATCH") {
+		t.Errorf("loadDenylist() with a bad mode = %v, want a DENYLIST_MATCH error", err)
+	}
+	setVar(t, &denylistMatch, "exact")
+	setVar(t, &denylistPath, filepath.Join(t.TempDir(), "missing.txt"))
+	if err := loadDenylist(); err == nil {
+		t.Error("loadDenylist() of a missing file = nil, want an error")
+	}
+	if !isDenied("badguy") || denylistLen() != 1 {
+		t.Error("a failed load replaced the previous denylist")
+	}
+
+	path := filepath.Join(t.TempDir(), "bad.txt")
+	os.WriteFile(path, []byte("[unclosed"), 0o600)
+	setVar(t, &denylistPath, path)
+	setVar(t, &denylistMatch, "wildcard")
+	if err := loadDenylist(); err == nil || !strings.Contains(err.Error(), "[unclosed") {
+		t.Errorf("loadDenylist() with a bad pattern = %v, want it named", err)
+	}
+}
+
+func TestRankHandlerDenylist(t *testing.T) {
+	useDenylist(t, "exact", "badguy")
+	fake := &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))}
+	h := newFakeServer(t, fake).Handler()
+
+	w := serve(h, http.MethodGet, "/rest/v1/rank/eu/BadGuy/bar", "")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("denied player: status = %d, want 403: %s", w.Code, w.Body)
+	}
+	if code := decodeBody(t, w.Body.Bytes())["code"]; code != codePlayerDenied {
+		t.Errorf("code = %v, want %s", code, codePlayerDenied)
+	}
+	if n := fake.calls.Load(); n != 0 {
+		t.Errorf("upstream calls = %d, want none for a denied player", n)
+	}
+	if w := serve(h, http.MethodGet, "/rest/v1/rank/eu/goodguy/bar", ""); w.Code != http.StatusOK {
+		t.Errorf("allowed player: status = %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"cmp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tierColors are the embed colors of each rank, indexed by tier / 3 from
+// Iron (tiers 3-5) up; Radiant, tier 27, is the last.
+var tierColors = []int{
+	0x4f514f, // Iron
+	0xa5855d, // Bronze
+	0xd1d6d6, // Silver
+	0xeccc5f, // Gold
+	0x3b9ab0, // Platinum
+	0xc887f7, // Diamond
+	0x2aa672, // Ascendant
+	0xbb3d65, // Immortal
+	0xfffeb4, // Radiant
+}
+
+// unrankedColor is the embed color of players without a rank.
+const unrankedColor = 0x7d7d7d
+
+// rankColor returns the canonical embed color of tier.
+func rankColor(tier int) int {
+	if tier < minRankedTier {
+		return unrankedColor
+	}
+	return tierColors[min(tier/3-1, len(tierColors)-1)]
+}
+
+// discordEmbed builds a message payload with a single Discord embed for the
+// player, ready to post through a webhook or a bot. rr is the RR as
+// ?rr_format= renders it.
+func discordEmbed(name, tag string, info rankInfo, rr, updatedAt string) gin.H {
+	peak := cmp.Or(info.HighestRank, "Unknown")
+	embed := gin.H{
+		"title": name + "#" + tag,
+		"color": rankColor(info.Tier),
+		"fields": []gin.H{
+			{"name": "Rank", "value": info.Rank, "inline": true},
+			{"name": "RR", "value": rr, "inline": true},
+			{"name": "Peak", "value": peak, "inline": true},
+		},
+	}
+	if updatedAt != "" {
+		embed["timestamp"] = updatedAt
+	}
+	return gin.H{"embeds": []gin.H{embed}}
+}
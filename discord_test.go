@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRankColor(t *testing.T) {
+	tests := []struct {
+		tier int
+		want int
+	}{
+		{0, unrankedColor},
+		{minRankedTier - 1, unrankedColor},
+		{3, 0x4f514f},
+		{5, 0x4f514f},
+		{6, 0xa5855d},
+		{15, 0x3b9ab0},
+		{17, 0x3b9ab0},
+		{18, 0xc887f7},
+		{26, 0xbb3d65},
+		{radiantTier, 0xfffeb4},
+	}
+	for _, tt := range tests {
+		if got := rankColor(tt.tier); got != tt.want {
+			t.Errorf("rankColor(%d) = %#x, want %#x", tt.tier, got, tt.want)
+		}
+	}
+}
+
+func TestDiscordFormat(t *testing.T) {
+	type field struct {
+		Name   string
+		Value  string
+		Inline bool
+	}
+	type embed struct {
+		Title     string
+		Color     int
+		Fields    []field
+		Timestamp string
+	}
+	tests := []struct {
+		name      string
+		data      map[string]interface{}
+		query     string
+		wantColor int
+		// wantFields are the rank, RR and peak field values.
+		wantFields [3]string
+	}{
+		{"platinum", rankData(15, "Platinum 1", 45.7, "Diamond 2"), "", 0x3b9ab0, [3]string{"Platinum 1", "45RR", "Diamond 2"}},
+		{"rr format", rankData(15, "Platinum 1", 45.7, "Diamond 2"), "&rr_format=percent", 0x3b9ab0, [3]string{"Platinum 1", "46%", "Diamond 2"}},
+		{"radiant", rankData(radiantTier, "Radiant", 550, "Radiant"), "", 0xfffeb4, [3]string{"Radiant", "550RR", "Radiant"}},
+		{"unranked without a peak", rankData(0, "Unrated", 0, ""), "", unrankedColor, [3]string{"Unrated", "0RR", "Unknown"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeServer(t, &fakeMMRClient{mmr: returns(tt.data)})
+			clock := newFakeClock()
+			s.now = clock.now
+			w := serve(s.Handler(), http.MethodGet, "/rest/v1/rank/eu/Foo/bar?format=discord"+tt.query, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			var body struct{ Embeds []embed }
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || len(body.Embeds) != 1 {
+				t.Fatalf("body %s, want a single embed: %v", w.Body, err)
+			}
+			e := body.Embeds[0]
+			if e.Title != "Foo#bar" || e.Color != tt.wantColor {
+				t.Errorf("title %q, color %#x, want Foo#bar and %#x", e.Title, e.Color, tt.wantColor)
+			}
+			want := []field{
+				{"Rank", tt.wantFields[0], true},
+				{"RR", tt.wantFields[1], true},
+				{"Peak", tt.wantFields[2], true},
+			}
+			if len(e.Fields) != len(want) {
+				t.Fatalf("fields = %+v, want %+v", e.Fields, want)
+			}
+			for i := range want {
+				if e.Fields[i] != want[i] {
+					t.Errorf("fields[%d] = %+v, want %+v", i, e.Fields[i], want[i])
+				}
+			}
+			if ts, err := time.Parse(time.RFC3339, e.Timestamp); err != nil || !ts.Equal(clock.now()) {
+				t.Errorf("timestamp = %q, want %v", e.Timestamp, clock.now())
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errorLogSize is how many error responses GET /debug/errors remembers.
+var errorLogSize = envInt("ERROR_LOG_SIZE", 50)
+
+// apiErrorKey is the gin context key respondError leaves its error under.
+const apiErrorKey = "apiError"
+
+// errorEvent is one error response, as shown by GET /debug/errors.
+type errorEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Route     string    `json:"route"`
+	Status    int       `json:"status"`
+	Code      string    `json:"code"`
+	Message   string    `json:"message"`
+}
+
+// errorLog keeps the most recent error responses in a ring buffer, the
+// oldest overwritten first.
+type errorLog struct {
+	mu     sync.Mutex
+	events []errorEvent
+	next   int
+	full   bool
+	now    func() time.Time
+}
+
+func newErrorLog(size int, now func() time.Time) *errorLog {
+	return &errorLog{events: make([]errorEvent, max(size, 0)), now: now}
+}
+
+func (l *errorLog) add(e errorEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.events) == 0 {
+		return
+	}
+	l.events[l.next] = e
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// recent returns the remembered events, oldest first.
+func (l *errorLog) recent() []errorEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.full {
+		return append([]errorEvent(nil), l.events[:l.next]...)
+	}
+	return append(append([]errorEvent(nil), l.events[l.next:]...), l.events[:l.next]...)
+}
+
+// middleware records the error respondError wrote for a request, if any.
+func (l *errorLog) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		v, ok := c.Get(apiErrorKey)
+		if !ok {
+			return
+		}
+		err := v.(*apiError)
+		l.add(errorEvent{
+			Timestamp: l.now().UTC(),
+			Route:     cmp.Or(c.FullPath(), "unmatched"),
+			Status:    err.status,
+			Code:      err.code,
+			Message:   err.Error(),
+		})
+	}
+}
+
+// handler serves the remembered error responses, oldest first.
+func (l *errorLog) handler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"errors": l.recent()})
+}
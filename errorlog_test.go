@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestErrorLogRing(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		added int
+		want  []string
+	}{
+		{"empty", 3, 0, nil},
+		{"partly filled", 3, 2, []string{"e0", "e1"}},
+		{"exactly full", 3, 3, []string{"e0", "e1", "e2"}},
+		{"oldest evicted", 3, 5, []string{"e2", "e3", "e4"}},
+		{"wrapped twice", 2, 5, []string{"e3", "e4"}},
+		{"disabled", 0, 3, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newErrorLog(tt.size, time.Now)
+			for i := range tt.added {
+				l.add(errorEvent{Code: "e" + strconv.Itoa(i)})
+			}
+			var got []string
+			for _, e := range l.recent() {
+				got = append(got, e.Code)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("recent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDebugErrors(t *testing.T) {
+	setVar(t, &errorLogSize, 2)
+	s := opsServer(t, &fakeMMRClient{mmr: fails(http.StatusNotFound)})
+	clock := newFakeClock()
+	s.now = clock.now
+	h := s.Handler()
+
+	serve(h, http.MethodGet, "/rest/v1/rank/mars/foo/bar", "")
+	clock.advance(time.Second)
+	serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+	clock.advance(time.Second)
+	serve(h, http.MethodGet, "/rest/v1/rank/eu/baz/qux?rr_format=roman", "")
+	// Successful requests and gin's own 404s are not recorded.
+	serve(h, http.MethodGet, "/rest/v1/regions", "")
+	serve(h, http.MethodGet, "/rest/v1/nowhere", "")
+
+	w := serve(h, http.MethodGet, "/debug/errors", "", "Authorization", "Bearer ops")
+	var body struct{ Errors []errorEvent }
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("debug errors = %d %s: %v", w.Code, w.Body, err)
+	}
+
+	// The invalid region, oldest, was evicted.
+	want := []errorEvent{
+		{Timestamp: clock.now().Add(-time.Second).UTC(), Route: "/rest/v1/rank/:region/:name/:tag", Status: http.StatusNotFound, Code: codePlayerNotFound},
+		{Timestamp: clock.now().UTC(), Route: "/rest/v1/rank/:region/:name/:tag", Status: http.StatusBadRequest, Code: codeInvalidRRFormat},
+	}
+	if len(body.Errors) != len(want) {
+		t.Fatalf("errors = %+v, want %d events", body.Errors, len(want))
+	}
+	for i, e := range body.Errors {
+		if !e.Timestamp.Equal(want[i].Timestamp) || e.Route != want[i].Route || e.Status != want[i].Status || e.Code != want[i].Code || e.Message == "" {
+			t.Errorf("errors[%d] = %+v, want %+v with a message", i, e, want[i])
+		}
+	}
+
+	if w := serve(h, http.MethodGet, "/debug/errors", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials = %d, want 401", w.Code)
+	}
+}
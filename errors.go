@@ -0,0 +1,114 @@
+package main
+
+import (
+	"cmp"
+	"maps"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error codes reported in the "code" field of error responses.
+const (
+	codeInvalidRegion       = "INVALID_REGION"
+	codeNameRequired        = "NAME_REQUIRED"
+	codeTagRequired         = "TAG_REQUIRED"
+	codeInvalidPlayer       = "INVALID_PLAYER"
+	codeInvalidPath         = "INVALID_PATH"
+	codeUnsupportedVersion  = "UNSUPPORTED_API_VERSION"
+	codePlayerDenied        = "PLAYER_DENIED"
+	codeInvalidLocale       = "INVALID_LOCALE"
+	codeInvalidTenant       = "INVALID_TENANT"
+	codeUnauthorized        = "UNAUTHORIZED"
+	codeInvalidBody         = "INVALID_BODY"
+	codeInvalidFilter       = "INVALID_FILTER"
+	codeInvalidRRFormat     = "INVALID_RR_FORMAT"
+	codeUnknownQuery        = "UNKNOWN_QUERY_PARAMS"
+	codeBatchTooLarge       = "BATCH_TOO_LARGE"
+	codeImportTooLarge      = "IMPORT_TOO_LARGE"
+	codeQuotaExceeded       = "QUOTA_EXCEEDED"
+	codeRateLimited         = "RATE_LIMITED"
+	codeInsufficientBudget  = "INSUFFICIENT_BUDGET"
+	codeUpstreamUnreachable = "UPSTREAM_UNREACHABLE"
+	codeUpstreamTimeout     = "UPSTREAM_TIMEOUT"
+	codeUpstreamMalformed   = "UPSTREAM_MALFORMED"
+	codeUpstreamEmpty       = "UPSTREAM_EMPTY"
+	codeUpstreamError       = "UPSTREAM_ERROR"
+	codeUpstreamRateLimited = "UPSTREAM_RATE_LIMITED"
+	codeUpstreamPaused      = "UPSTREAM_PAUSED"
+	codePlayerNotFound      = "PLAYER_NOT_FOUND"
+	codeTeamNotFound        = "TEAM_NOT_FOUND"
+	codeInvalidRankData     = "INVALID_RANK_DATA"
+	codeNotCached           = "NOT_CACHED"
+	codeCacheMissOffline    = "CACHE_MISS_OFFLINE"
+	codeClientClosed        = "CLIENT_CLOSED_REQUEST"
+)
+
+// statusClientClosedRequest is the non-standard status nginx uses for requests
+// the client abandoned. Nobody reads it, but it keeps disconnects out of the
+// 5xx counts.
+const statusClientClosedRequest = 499
+
+// textErrorFallback is the text mode message for codes without a friendlier
+// mapping in textErrorMessages.
+var textErrorFallback = cmp.Or(os.Getenv("TEXT_ERROR_MESSAGE"), "Couldn't fetch rank, try again later")
+
+// textErrorMessages are the text mode messages for codes a chat user can act
+// on. Anything else gets textErrorFallback.
+var textErrorMessages = map[string]string{
+	codePlayerNotFound:      "Player not found, check the name and tag",
+	codeUpstreamRateLimited: "Too many lookups right now, try again in a minute",
+	codeUpstreamPaused:      "Too many lookups right now, try again in a minute",
+	codeRateLimited:         "Too many lookups right now, try again in a minute",
+}
+
+// apiError is a failed request together with the status and body that should
+// be reported to the client.
+type apiError struct {
+	status int
+	code   string
+	body   gin.H
+	// retryAfter, when set, is sent as a Retry-After header.
+	retryAfter time.Duration
+	// text, when set, is the text mode message, taking precedence over
+	// textErrorMessages.
+	text string
+}
+
+func newAPIError(status int, code, msg string) *apiError {
+	return &apiError{status: status, code: code, body: gin.H{"error": msg, "code": code}}
+}
+
+// with returns a copy of e with key set in its body. Errors coming out of a
+// flight or memo are shared by every waiter, so they must be decorated
+// through with rather than by writing to their body.
+func (e *apiError) with(key string, value interface{}) *apiError {
+	cp := *e
+	cp.body = maps.Clone(e.body)
+	cp.body[key] = value
+	return &cp
+}
+
+func (e *apiError) Error() string {
+	msg, _ := e.body["error"].(string)
+	return msg
+}
+
+// respondError writes err to the client. Text mode clients get a friendly
+// message instead of the internal error. Errors are never cacheable. err is
+// left on the context for the error log.
+func respondError(c *gin.Context, err *apiError) {
+	c.Set(apiErrorKey, err)
+	c.Header("Cache-Control", "no-store")
+	if err.retryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(err.retryAfter.Seconds()))))
+	}
+	if c.Query("format") == "text" {
+		c.String(err.status, cmp.Or(err.text, textErrorMessages[err.code], textErrorFallback))
+		return
+	}
+	c.JSON(err.status, err.body)
+}
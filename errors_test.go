@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTextModeErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		mmr        fakeResult
+		fallback   string
+		target     string
+		wantStatus int
+		wantText   string
+	}{
+		{"not found", fails(http.StatusNotFound), textErrorFallback, "/rest/v1/rank/eu/foo/bar", http.StatusNotFound, "Player not found, check the name and tag"},
+		{"upstream rate limit", fails(http.StatusTooManyRequests), textErrorFallback, "/rest/v1/rank/eu/foo/bar", http.StatusTooManyRequests, "Too many lookups right now, try again in a minute"},
+		{"unmapped code", fails(http.StatusServiceUnavailable), textErrorFallback, "/rest/v1/rank/eu/foo/bar", http.StatusServiceUnavailable, "Couldn't fetch rank, try again later"},
+		{"configured fallback", fails(http.StatusServiceUnavailable), "Rank is napping", "/rest/v1/rank/eu/foo/bar", http.StatusServiceUnavailable, "Rank is napping"},
+		{"error with its own text", nil, textErrorFallback, "/rest/v1/rank/mars/foo/bar", http.StatusBadRequest, "Unknown region, try one of: ap, br, eu, kr, latam, na"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &textErrorFallback, tt.fallback)
+			s := newFakeServer(t, &fakeMMRClient{mmr: tt.mmr})
+			h := s.Handler()
+
+			w := serve(h, http.MethodGet, tt.target+"?format=text", "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if got := w.Body.String(); got != tt.wantText {
+				t.Errorf("text = %q, want %q", got, tt.wantText)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+				t.Errorf("Content-Type = %q, want text/plain", ct)
+			}
+
+			// JSON clients still get the internal code.
+			w = serve(h, http.MethodGet, tt.target, "")
+			if code, _ := decodeBody(t, w.Body.Bytes())["code"].(string); code == "" || strings.Contains(tt.wantText, code) {
+				t.Errorf("JSON code = %q, want an internal code the text hides", code)
+			}
+		})
+	}
+}
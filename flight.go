@@ -0,0 +1,102 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// cacheLockGranularity decides how concurrent misses for one key are filled:
+// "key" lets a single request fetch while the rest wait for its answer, and
+// "none" lets every request fetch for itself.
+var cacheLockGranularity = cmp.Or(os.Getenv("CACHE_LOCK_GRANULARITY"), "key")
+
+// validateCacheLockGranularity rejects unknown CACHE_LOCK_GRANULARITY values.
+func validateCacheLockGranularity() error {
+	switch cacheLockGranularity {
+	case "key", "none":
+		return nil
+	}
+	return fmt.Errorf("invalid CACHE_LOCK_GRANULARITY %q, expected key or none", cacheLockGranularity)
+}
+
+// flightCall is one in-flight cache fill, shared by every request missing
+// the same key meanwhile.
+type flightCall struct {
+	done   chan struct{}
+	result lookupResult
+	err    *apiError
+}
+
+// flightGroup coalesces concurrent cache fills across requests, so a key
+// that just expired under load is fetched once rather than by every request
+// that finds it missing. Unlike fetchMemo a call is forgotten once it
+// finishes, so the next miss fetches afresh.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+	// window is how long the first fetch of a missing key waits for others
+	// to join it, from CACHE_COALESCE_WINDOW.
+	window time.Duration
+}
+
+func newFlightGroup(window time.Duration) *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall), window: window}
+}
+
+// leaderOnly reports whether err says something about the request that made
+// the call rather than about upstream, so waiters should not share it.
+func leaderOnly(err *apiError) bool {
+	switch err.code {
+	case codeClientClosed, codeRateLimited, codeInsufficientBudget:
+		return true
+	}
+	return false
+}
+
+// do returns the outcome of fill for key. The first caller runs it, after
+// the coalesce window; callers arriving before it finishes wait for its outcome,
+// or until ctx is done.
+// A waiter handed an outcome that only concerned the first caller, such as
+// its disconnect, runs fill itself.
+func (g *flightGroup) do(ctx context.Context, key string, fill func() (lookupResult, *apiError)) (lookupResult, *apiError) {
+	if cacheLockGranularity == "none" {
+		return fill()
+	}
+
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return lookupResult{}, fetchError(ctx.Err())
+		}
+		if call.err != nil && leaderOnly(call.err) {
+			return fill()
+		}
+		return call.result, call.err
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	if g.window > 0 {
+		// A cancelled ctx still runs fill, which reports the disconnect,
+		// and waiters then fetch for themselves.
+		select {
+		case <-time.After(g.window):
+		case <-ctx.Done():
+		}
+	}
+	call.result, call.err = fill()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(call.done)
+	return call.result, call.err
+}
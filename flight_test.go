@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExpiredKeyStampede(t *testing.T) {
+	const requests = 20
+	tests := []struct {
+		granularity string
+		wantCalls   int64
+	}{
+		{"key", 1},
+		{"none", requests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.granularity, func(t *testing.T) {
+			setVar(t, &cacheLockGranularity, tt.granularity)
+			setVar(t, &cacheTTLJitter, 0)
+			var calls atomic.Int64
+			release := make(chan struct{})
+			fake := &fakeMMRClient{mmr: func(region, name, tag string) (map[string]interface{}, *apiError) {
+				calls.Add(1)
+				<-release
+				return rankData(16, "Platinum 2", 10, "Diamond 2"), nil
+			}}
+			s := newFakeServer(t, fake)
+			clock := newFakeClock()
+			s.now = clock.now
+			s.cache.set(mmrCacheKey("eu", "foo", "bar", false), rankData(15, "Platinum 1", 45, "Diamond 2"))
+			clock.advance(defaultCacheTTL + time.Second)
+			h := s.Handler()
+
+			var wg sync.WaitGroup
+			bodies := make([][]byte, requests)
+			for i := range requests {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+					if w.Code != http.StatusOK {
+						t.Errorf("status = %d, want 200: %s", w.Code, w.Body)
+						return
+					}
+					bodies[i] = w.Body.Bytes()
+				}()
+			}
+
+			// Hold the refresh until every request has had time to find
+			// the key expired, or all of them are refreshing it.
+			deadline := time.After(2 * time.Second)
+			for calls.Load() < tt.wantCalls {
+				select {
+				case <-deadline:
+					t.Fatalf("upstream calls = %d, want %d", calls.Load(), tt.wantCalls)
+				case <-time.After(time.Millisecond):
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			if n := calls.Load(); n != tt.wantCalls {
+				t.Errorf("upstream calls = %d, want %d", n, tt.wantCalls)
+			}
+			for i, body := range bodies {
+				if body == nil {
+					continue
+				}
+				if msg := decodeBody(t, body)["message"]; msg != "Platinum 2 [10RR] | Peak: Diamond 2" {
+					t.Errorf("request %d got %v, want the refreshed rank", i, msg)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateCacheLockGranularity(t *testing.T) {
+	for granularity, wantErr := range map[string]bool{"key": false, "none": false, "shard": true, "": true} {
+		setVar(t, &cacheLockGranularity, granularity)
+		if err := validateCacheLockGranularity(); (err != nil) != wantErr {
+			t.Errorf("validateCacheLockGranularity() with %q = %v, want an error: %v", granularity, err, wantErr)
+		}
+	}
+}
+
+func TestCoalesceWindow(t *testing.T) {
+	const requests = 5
+	tests := []struct {
+		name        string
+		window      time.Duration
+		granularity string
+		wantCalls   int64
+	}{
+		{"within the window", 200 * time.Millisecond, "key", 1},
+		{"off", 0, "key", requests},
+		{"no per-key lock", 200 * time.Millisecond, "none", requests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &cacheLockGranularity, tt.granularity)
+			// Misses are not remembered, so every request that does not
+			// share a call reaches upstream.
+			setVar(t, &negativeTTL, 0)
+			fake := &fakeMMRClient{mmr: fails(http.StatusNotFound)}
+			cfg := testConfig(t, "http://upstream.invalid")
+			cfg.CacheCoalesceWindow = tt.window
+			h := NewServer(cfg, discardLogger(), fake).Handler()
+
+			// The requests never overlap an upstream call, which answers at
+			// once, but all arrive within the window.
+			var wg sync.WaitGroup
+			for range requests {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", ""); w.Code != http.StatusNotFound {
+						t.Errorf("status = %d, want 404: %s", w.Code, w.Body)
+					}
+				}()
+				time.Sleep(10 * time.Millisecond)
+			}
+			wg.Wait()
+
+			if n := fake.calls.Load(); n != tt.wantCalls {
+				t.Errorf("upstream calls = %d, want %d", n, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -1,10 +1,12 @@
-module main
+module github.com/notkoyo/gin
 
 go 1.23.0
 
 require (
 	github.com/gin-gonic/gin v1.10.0
 	github.com/samber/slog-gin v1.13.5
+	golang.org/x/text v0.18.0
+	google.golang.org/protobuf v1.34.2
 )
 
 require (
@@ -35,7 +37,5 @@ require (
 	golang.org/x/crypto v0.27.0 // indirect
 	golang.org/x/net v0.29.0 // indirect
 	golang.org/x/sys v0.25.0 // indirect
-	golang.org/x/text v0.18.0 // indirect
-	google.golang.org/protobuf v1.34.2 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Subsystem statuses reported by GET /healthz/detailed.
+const (
+	healthOK        = "ok"
+	healthDegraded  = "degraded"
+	healthPaused    = "paused"
+	healthThrottled = "throttled"
+	healthOffline   = "offline"
+	healthDisabled  = "disabled"
+)
+
+// upstreamHealth describes henrikdev as this process currently sees it.
+// Upstream has no circuit breaker as such; the Retry-After pause and the
+// retry budget play that part, so their state is what is reported.
+func (s *Server) upstreamHealth() gin.H {
+	health := gin.H{
+		"status":        healthOK,
+		"paused_for_ms": upstreamPause.remaining().Milliseconds(),
+		"retry_tokens":  upstreamRetryBudget.remaining(),
+		"last_success":  nil,
+		"quota":         upstreamQuota.snapshot(s.cfg.UpstreamQuotaLowWater),
+	}
+	if t := upstreamLastSuccess.Load(); t != 0 {
+		health["last_success"] = time.Unix(0, t).UTC().Format(time.RFC3339)
+	}
+	switch {
+	case s.cfg.Offline:
+		health["status"] = healthOffline
+	case upstreamPause.remaining() > 0:
+		health["status"] = healthPaused
+	case !upstreamRetryBudget.canRetry():
+		health["status"] = healthDegraded
+	case upstreamQuota.low(s.cfg.UpstreamQuotaLowWater):
+		health["status"] = healthThrottled
+	}
+	return health
+}
+
+// rateLimitHealth describes the per-client rate limiter.
+func (s *Server) rateLimitHealth() gin.H {
+	if s.cfg.RateLimit <= 0 {
+		return gin.H{"status": healthDisabled}
+	}
+	return gin.H{
+		"status":         healthOK,
+		"mode":           s.cfg.RateLimitMode,
+		"active_buckets": s.rateLimit.active(),
+	}
+}
+
+// detailedHealthHandler reports the health of each subsystem. It always
+// answers 200 while the process is up: a paused or offline upstream still
+// leaves the cache serving, so the overall status is informational.
+func (s *Server) detailedHealthHandler(c *gin.Context) {
+	upstream := s.upstreamHealth()
+	status := healthOK
+	if upstream["status"] != healthOK {
+		status = healthDegraded
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": status,
+		"cache": gin.H{
+			"status":         healthOK,
+			"entries":        s.cache.len(),
+			"estimate_bytes": s.cache.sizeEstimate(),
+		},
+		"upstream":   upstream,
+		"rate_limit": s.rateLimitHealth(),
+	})
+}
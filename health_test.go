@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDetailedHealth(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(cfg *Config)
+
+		wantStatus    string
+		wantUpstream  string
+		wantRateLimit string
+	}{
+		{"healthy", func(*Config) {}, healthOK, healthOK, healthDisabled},
+		{"rate limited clients", func(cfg *Config) { cfg.RateLimit = 10 }, healthOK, healthOK, healthOK},
+		{"upstream paused", func(*Config) { upstreamPause.pauseFor(time.Minute) }, healthDegraded, healthPaused, healthDisabled},
+		{"retry budget drained", func(*Config) {
+			for range 10 {
+				upstreamRetryBudget.failure()
+			}
+		}, healthDegraded, healthDegraded, healthDisabled},
+		{"offline", func(cfg *Config) { cfg.Offline = true }, healthDegraded, healthOffline, healthDisabled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetUpstream(t)
+			cfg := testConfig(t, "http://upstream.invalid")
+			cfg.ClientAPIKey = "ops"
+			tt.setup(&cfg)
+			s := NewServer(cfg, discardLogger(), &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))})
+			s.cache.set(mmrCacheKey("eu", "foo", "bar", false), rankData(15, "Platinum 1", 45, "Diamond 2"))
+			h := s.Handler()
+			serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+
+			if w := serve(h, http.MethodGet, "/healthz/detailed", ""); w.Code != http.StatusUnauthorized {
+				t.Errorf("without credentials = %d, want 401", w.Code)
+			}
+			w := serve(h, http.MethodGet, "/healthz/detailed", "", "Authorization", "Bearer ops")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200 whatever the subsystems say: %s", w.Code, w.Body)
+			}
+			var body struct {
+				Status string
+				Cache  struct {
+					Status        string
+					Entries       int
+					EstimateBytes int `json:"estimate_bytes"`
+				}
+				Upstream struct {
+					Status      string
+					PausedForMS int64   `json:"paused_for_ms"`
+					RetryTokens float64 `json:"retry_tokens"`
+					LastSuccess *string `json:"last_success"`
+					Quota       json.RawMessage
+				}
+				RateLimit struct {
+					Status        string
+					ActiveBuckets *int `json:"active_buckets"`
+				} `json:"rate_limit"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+
+			if body.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", body.Status, tt.wantStatus)
+			}
+			if body.Cache.Status != healthOK || body.Cache.Entries != 1 || body.Cache.EstimateBytes <= 0 {
+				t.Errorf("cache = %+v, want ok with the one entry and its size", body.Cache)
+			}
+			if body.Upstream.Status != tt.wantUpstream || body.Upstream.Quota == nil {
+				t.Errorf("upstream = %+v, want %q with the quota", body.Upstream, tt.wantUpstream)
+			}
+			if paused := body.Upstream.PausedForMS > 0; paused != (tt.wantUpstream == healthPaused) {
+				t.Errorf("paused_for_ms = %d with upstream %q", body.Upstream.PausedForMS, body.Upstream.Status)
+			}
+			if body.RateLimit.Status != tt.wantRateLimit {
+				t.Errorf("rate_limit status = %q, want %q", body.RateLimit.Status, tt.wantRateLimit)
+			}
+			if tt.wantRateLimit == healthOK && (body.RateLimit.ActiveBuckets == nil || *body.RateLimit.ActiveBuckets != 1) {
+				t.Errorf("active_buckets = %v, want alice's bucket", body.RateLimit.ActiveBuckets)
+			}
+		})
+	}
+}
+
+func TestDetailedHealthLastSuccess(t *testing.T) {
+	cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, mmrBody)
+	})
+	cfg.ClientAPIKey = "ops"
+	s := newHTTPServer(t, cfg)
+	h := s.Handler()
+
+	health := func() map[string]interface{} {
+		w := serve(h, http.MethodGet, "/healthz/detailed", "", "Authorization", "Bearer ops")
+		return decodeBody(t, w.Body.Bytes())["upstream"].(map[string]interface{})
+	}
+	if got := health()["last_success"]; got != nil {
+		t.Errorf("last_success = %v before any upstream call, want null", got)
+	}
+	before := time.Now().Truncate(time.Second)
+	serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+	last, err := time.Parse(time.RFC3339, health()["last_success"].(string))
+	if err != nil || last.Before(before) || last.After(time.Now()) {
+		t.Errorf("last_success = %v (%v), want the time of the lookup", last, err)
+	}
+}
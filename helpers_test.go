@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// setVar sets a package level knob for the duration of a test.
+func setVar[T any](t testing.TB, p *T, v T) {
+	t.Helper()
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+// discardLogger is a logger for servers under test.
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// resetUpstream clears the process wide upstream state, the pause, retry
+// budget and reported quota, before and after a test.
+func resetUpstream(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		upstreamPause.mu.Lock()
+		upstreamPause.notBefore = time.Time{}
+		upstreamPause.mu.Unlock()
+		upstreamQuota = quotaTracker{}
+		upstreamRetryBudget = newRetryBudget(10, 0.1)
+		upstreamLastSuccess.Store(0)
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+// testConfig returns the default configuration with upstream pointed at
+// baseURL.
+func testConfig(t *testing.T, baseURL string) Config {
+	t.Helper()
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	cfg.APIKey = "test-key"
+	cfg.UpstreamBaseURL = baseURL
+	return cfg
+}
+
+// newUpstream starts a fake henrikdev answering with handler and returns the
+// configuration pointing at it.
+func newUpstream(t *testing.T, handler http.HandlerFunc) Config {
+	t.Helper()
+	resetUpstream(t)
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return testConfig(t, srv.URL)
+}
+
+// newHTTPServer builds a Server using the real HTTP client against cfg.
+func newHTTPServer(t *testing.T, cfg Config) *Server {
+	t.Helper()
+	return NewServer(cfg, discardLogger(), newHTTPMMRClient(cfg, discardLogger()))
+}
+
+// serve sends a request to h and returns the recorded response.
+func serve(h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	return serveFrom(h, "192.0.2.1:1234", method, target, body, header...)
+}
+
+// serveFrom is serve for a request arriving from remoteAddr.
+func serveFrom(h http.Handler, remoteAddr, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	req.RemoteAddr = remoteAddr
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// writeJSON answers a fake upstream request with status and body.
+func writeJSON(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	io.WriteString(w, body)
+}
+
+// mmrBody is an upstream v2 MMR response for a Platinum 1 player on 45 RR.
+const mmrBody = `{"status":200,"data":{"name":"n","tag":"t","current_data":{"currenttier":15,"currenttierpatched":"Platinum 1","ranking_in_tier":45,"mmr_change_to_last_game":10,"elo":1245},"highest_rank":{"patched_tier":"Diamond 2","tier":17}}}`
+
+// mmrV3Body is the v3 upstream MMR response for the same player as mmrBody.
+const mmrV3Body = `{"status":200,"data":{"account":{"name":"n","tag":"t"},"current":{"tier":{"id":15,"name":"Platinum 1"},"rr":45,"last_change":10,"elo":1245},"peak":{"tier":{"id":17,"name":"Diamond 2"}},"seasonal":[{"season":{"short":"e9a1"},"act_wins":[{"id":15,"name":"Platinum 1"}]}]}}`
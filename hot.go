@@ -0,0 +1,109 @@
+package main
+
+import (
+	"cmp"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	// hotThreshold is how many lookups of a key within one janitor interval
+	// make it hot. Hot keys are refreshed ahead of expiry. Zero disables
+	// proactive refresh.
+	hotThreshold = envInt("HOT_KEY_THRESHOLD", 0)
+	// maxHotRefreshes bounds the hot keys refreshed per janitor interval;
+	// the most requested win.
+	maxHotRefreshes = envInt("HOT_KEY_MAX", 100)
+)
+
+// maxTrackedKeys bounds how many distinct keys are counted per interval, so
+// a scan of unique players cannot grow the tracker.
+const maxTrackedKeys = 10000
+
+// hotKey is the demand seen for one cache key and how to refetch it.
+type hotKey struct {
+	hits  int
+	fetch upstreamFetch
+	valid func(map[string]interface{}) bool
+}
+
+// hotKeys counts lookups per cache key over one janitor interval.
+type hotKeys struct {
+	mu   sync.Mutex
+	keys map[string]*hotKey
+}
+
+func newHotKeys() *hotKeys {
+	return &hotKeys{keys: make(map[string]*hotKey)}
+}
+
+// record counts a lookup of cacheKey.
+func (h *hotKeys) record(cacheKey string, fetch upstreamFetch, valid func(map[string]interface{}) bool) {
+	if hotThreshold <= 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	k, ok := h.keys[cacheKey]
+	if !ok {
+		if len(h.keys) >= maxTrackedKeys {
+			return
+		}
+		k = &hotKey{}
+		h.keys[cacheKey] = k
+	}
+	k.hits++
+	k.fetch, k.valid = fetch, valid
+}
+
+// take returns the keys that reached hotThreshold, most requested first and
+// at most maxHotRefreshes of them, and starts a new interval.
+func (h *hotKeys) take() map[string]*hotKey {
+	h.mu.Lock()
+	keys := h.keys
+	h.keys = make(map[string]*hotKey)
+	h.mu.Unlock()
+
+	names := make([]string, 0, len(keys))
+	for name, k := range keys {
+		if k.hits >= hotThreshold {
+			names = append(names, name)
+		}
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		return cmp.Compare(keys[b].hits, keys[a].hits)
+	})
+
+	hot := make(map[string]*hotKey, min(len(names), maxHotRefreshes))
+	for _, name := range names[:min(len(names), maxHotRefreshes)] {
+		hot[name] = keys[name]
+	}
+	return hot
+}
+
+// refreshHot refetches hot keys whose entry would expire before the next
+// janitor run, interval from now, unless upstream quota is low. Entry TTLs
+// are jittered, so the refreshes of keys cached together are spread out too.
+func (s *Server) refreshHot(interval time.Duration) {
+	// With upstream quota running low, requests get what is left; hot keys
+	// are served stale instead of refreshed ahead of time.
+	if upstreamQuota.low(s.cfg.UpstreamQuotaLowWater) {
+		s.hot.take()
+		return
+	}
+	n := 0
+	for key, k := range s.hot.take() {
+		entry, ok := s.cache.get(key)
+		if !ok || entry.timestamp.Add(entry.ttl).Sub(s.now()) > interval {
+			continue
+		}
+		s.refreshInBackground(key, k.fetch, k.valid)
+		n++
+	}
+	if n > 0 {
+		s.logger.Debug("Refreshing hot cache entries", slog.Int("count", n))
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHotKeyRefresh(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int
+		lookups   int
+		// age is how long after caching the janitor runs.
+		age         time.Duration
+		wantRefresh bool
+	}{
+		{"hot and about to expire", 3, 3, defaultCacheTTL - 30*time.Second, true},
+		{"hot but fresh for a while", 3, 3, time.Minute, false},
+		{"below the threshold", 3, 2, defaultCacheTTL - 30*time.Second, false},
+		{"disabled", 0, 10, defaultCacheTTL - 30*time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &hotThreshold, tt.threshold)
+			setVar(t, &cacheTTLJitter, 0)
+			fake := &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))}
+			s := newFakeServer(t, fake)
+			clock := newFakeClock()
+			s.now = clock.now
+			h := s.Handler()
+
+			for range tt.lookups {
+				if w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", ""); w.Code != http.StatusOK {
+					t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+				}
+			}
+			clock.advance(tt.age)
+			s.refreshHot(time.Minute)
+			s.background.wg.Wait()
+
+			wantCalls := int64(1)
+			if tt.wantRefresh {
+				wantCalls = 2
+			}
+			if n := fake.calls.Load(); n != wantCalls {
+				t.Errorf("upstream calls = %d, want %d", n, wantCalls)
+			}
+			entry := mustEntry(t, s.cache, mmrCacheKey("eu", "foo", "bar", false))
+			if refreshed := entry.timestamp.Equal(clock.now()); refreshed != tt.wantRefresh {
+				t.Errorf("entry cached at %v, refreshed = %v, want %v", entry.timestamp, refreshed, tt.wantRefresh)
+			}
+			// Served before the original TTL ran out, the entry is always a hit.
+			if w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", ""); w.Header().Get("X-Cache") != "HIT" {
+				t.Errorf("X-Cache = %q, want HIT", w.Header().Get("X-Cache"))
+			}
+		})
+	}
+}
+
+func TestHotKeysBounded(t *testing.T) {
+	setVar(t, &hotThreshold, 2)
+	setVar(t, &maxHotRefreshes, 2)
+	h := newHotKeys()
+	for i, hits := range []int{2, 5, 1, 3} {
+		for range hits {
+			h.record(fmt.Sprintf("key%d", i), nil, nil)
+		}
+	}
+
+	hot := h.take()
+	if len(hot) != 2 || hot["key1"] == nil || hot["key3"] == nil {
+		t.Errorf("take() = %v, want the two most requested keys, key1 and key3", hot)
+	}
+	if again := h.take(); len(again) != 0 {
+		t.Errorf("second take() = %v, want a fresh interval with no hot keys", again)
+	}
+}
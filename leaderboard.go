@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// leaderboardFlushEvery is how many entries are written between flushes.
+const leaderboardFlushEvery = 100
+
+// leaderboardTTL is how long a fetched leaderboard is cached. Leaderboards
+// move slowly and are expensive to fetch, so this is kept apart from cacheTTL.
+var leaderboardTTL = envDuration("LEADERBOARD_CACHE_TTL", 15*time.Minute)
+
+// leaderboardCacheLimit caps how many entries of a streamed leaderboard are
+// held back for the cache. A longer leaderboard is still streamed in full but
+// not cached, so streaming never buffers more than this many entries.
+var leaderboardCacheLimit = envInt("LEADERBOARD_CACHE_MAX_ENTRIES", 20000)
+
+// leaderboardCacheKey builds the cache key for a region's leaderboard.
+func leaderboardCacheKey(region string) string {
+	return "leaderboard:" + region
+}
+
+// leaderboardEntry is the subset of a henrikdev leaderboard row we expose.
+type leaderboardEntry struct {
+	LeaderboardRank int    `json:"leaderboardRank"`
+	GameName        string `json:"gameName"`
+	TagLine         string `json:"tagLine"`
+	RankedRating    int    `json:"rankedRating"`
+	NumberOfWins    int    `json:"numberOfWins"`
+	CompetitiveTier int    `json:"competitiveTier"`
+}
+
+// leaderboardFilter keeps the leaderboard entries at or above a minimum tier
+// and RR. The zero value keeps everything.
+type leaderboardFilter struct {
+	minTier int
+	minRR   int
+}
+
+func (f leaderboardFilter) keep(e leaderboardEntry) bool {
+	return e.CompetitiveTier >= f.minTier && e.RankedRating >= f.minRR
+}
+
+// apply returns the entries passing f, leaving players untouched.
+func (f leaderboardFilter) apply(players []leaderboardEntry) []leaderboardEntry {
+	kept := make([]leaderboardEntry, 0, len(players))
+	for _, e := range players {
+		if f.keep(e) {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// parseLeaderboardFilter reads ?min_tier= (0 to radiantTier) and ?min_rr=
+// (not negative) from the request.
+func parseLeaderboardFilter(c *gin.Context) (leaderboardFilter, *apiError) {
+	var f leaderboardFilter
+	if v := c.Query("min_tier"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > radiantTier {
+			return f, newAPIError(http.StatusBadRequest, codeInvalidFilter, fmt.Sprintf("min_tier must be a number between 0 and %d", radiantTier))
+		}
+		f.minTier = n
+	}
+	if v := c.Query("min_rr"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return f, newAPIError(http.StatusBadRequest, codeInvalidFilter, "min_rr must be a number of at least 0")
+		}
+		f.minRR = n
+	}
+	return f, nil
+}
+
+// seekArray advances dec to just inside the leaderboard array. henrikdev
+// returns a bare array, but a {"data": [...]} envelope is accepted too.
+func seekArray(dec *json.Decoder) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok {
+	case json.Delim('['):
+		return nil
+	case json.Delim('{'):
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			if key == "data" {
+				return seekArray(dec)
+			}
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+		}
+	}
+	return errors.New("leaderboard array not found")
+}
+
+// leaderboardHandler serves a region's leaderboard from cache, or streams it
+// entry by entry from upstream to the client and caches it once the
+// stream completed. Once streaming has started the status can no longer
+// change; a failure midway is reported in a trailing "error" field instead
+// and nothing is cached. Leaderboards longer than leaderboardCacheLimit are
+// not cached either. ?min_tier= and ?min_rr= narrow what is served; the cache
+// always holds the full leaderboard.
+func (s *Server) leaderboardHandler(c *gin.Context) {
+	region, lerr := s.requestRegion(c)
+	if lerr != nil {
+		respondError(c, lerr)
+		return
+	}
+	filter, lerr := parseLeaderboardFilter(c)
+	if lerr != nil {
+		respondError(c, lerr)
+		return
+	}
+
+	ctx := c.Request.Context()
+	key := upstreamQueryCacheKey(ctx, tenantCacheKey(ctx, leaderboardCacheKey(region)))
+	// Entries restored from a snapshot have lost their type and are
+	// simply refetched.
+	if entry, found := s.cache.get(key); found {
+		if players, ok := entry.data["items"].([]leaderboardEntry); ok {
+			s.stats.hit()
+			setCacheHeader(c, sourceCache)
+			setMaxAge(c, entry.timestamp.Add(entry.ttl).Sub(s.now()))
+			c.JSON(http.StatusOK, gin.H{"players": filter.apply(players)})
+			return
+		}
+	}
+	s.countMiss(key)
+
+	if !allowUpstream(ctx) {
+		respondError(c, rateLimitedError())
+		return
+	}
+	body, lerr := s.upstream.GetLeaderboard(ctx, region)
+	if lerr != nil {
+		respondError(c, lerr)
+		return
+	}
+	defer body.Close()
+
+	dec := json.NewDecoder(body)
+	if err := seekArray(dec); err != nil {
+		respondError(c, newAPIError(http.StatusBadGateway, codeUpstreamMalformed, "Failed to parse API response"))
+		return
+	}
+
+	setCacheHeader(c, sourceUpstream)
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	w := c.Writer
+	io.WriteString(w, `{"players":[`)
+
+	var (
+		players   []leaderboardEntry
+		cacheable = true
+		written   int
+		streamErr error
+	)
+	for i := 0; dec.More(); i++ {
+		var entry leaderboardEntry
+		if streamErr = dec.Decode(&entry); streamErr != nil {
+			break
+		}
+		switch {
+		case !cacheable:
+		case i < leaderboardCacheLimit:
+			players = append(players, entry)
+		default:
+			// Too long to cache: stop buffering and let go of what was held.
+			cacheable, players = false, nil
+		}
+		if !filter.keep(entry) {
+			continue
+		}
+		if written > 0 {
+			io.WriteString(w, ",")
+		}
+		written++
+		b, err := json.Marshal(entry)
+		if err == nil {
+			_, err = w.Write(b)
+		}
+		if streamErr = err; streamErr != nil {
+			break
+		}
+		if i%leaderboardFlushEvery == 0 {
+			w.Flush()
+		}
+	}
+
+	if streamErr != nil {
+		s.logger.Warn("Leaderboard stream aborted",
+			slog.String("region", region),
+			slog.String("error", redact(streamErr.Error(), s.apiKey)),
+		)
+		io.WriteString(w, `],"error":"Leaderboard stream interrupted"}`)
+		return
+	}
+	io.WriteString(w, "]}")
+	if !cacheable {
+		s.logger.Debug("Leaderboard too long to cache",
+			slog.String("region", region),
+			slog.Int("limit", leaderboardCacheLimit),
+		)
+		return
+	}
+	if data := map[string]interface{}{"items": players}; fitsCache(key, data) {
+		s.cache.setTTL(key, data, leaderboardTTL)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// leaderboardJSON is an upstream leaderboard of n players, the best first.
+func leaderboardJSON(n int) string {
+	rows := make([]string, n)
+	for i := range rows {
+		rows[i] = fmt.Sprintf(`{"leaderboardRank":%d,"gameName":"p%d","tagLine":"t","rankedRating":%d,"competitiveTier":27}`, i+1, i, max(1000-i*100, 0))
+	}
+	return "[" + strings.Join(rows, ",") + "]"
+}
+
+// leaderboardFrom is a fake GetLeaderboard always answering body.
+func leaderboardFrom(body string) func(string) (io.ReadCloser, *apiError) {
+	return func(string) (io.ReadCloser, *apiError) { return io.NopCloser(strings.NewReader(body)), nil }
+}
+
+func TestLeaderboardHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		upstream    string
+		query       string
+		wantStatus  int
+		wantPlayers int
+	}{
+		{"bare array", leaderboardJSON(3), "", http.StatusOK, 3},
+		{"data envelope", `{"status":200,"data":` + leaderboardJSON(3) + `}`, "", http.StatusOK, 3},
+		{"min_rr filter", leaderboardJSON(5), "?min_rr=800", http.StatusOK, 3},
+		{"min_tier filter", leaderboardJSON(5), "?min_tier=27", http.StatusOK, 5},
+		{"bad filter", leaderboardJSON(5), "?min_tier=99", http.StatusBadRequest, 0},
+		{"not an array", `"nope"`, "", http.StatusBadGateway, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeServer(t, &fakeMMRClient{leaderboard: leaderboardFrom(tt.upstream)})
+
+			w := serve(s.Handler(), http.MethodGet, "/rest/v1/leaderboard/eu"+tt.query, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var body struct {
+				Players []leaderboardEntry `json:"players"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decoding %s: %v", w.Body, err)
+			}
+			if len(body.Players) != tt.wantPlayers {
+				t.Errorf("got %d players, want %d", len(body.Players), tt.wantPlayers)
+			}
+		})
+	}
+}
+
+func TestLeaderboardCacheLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		limit      int
+		wantCached bool
+	}{
+		{"under the limit", 10, true},
+		{"at the limit", 3, true},
+		{"over the limit", 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &leaderboardCacheLimit, tt.limit)
+			fake := &fakeMMRClient{leaderboard: leaderboardFrom(leaderboardJSON(3))}
+			s := newFakeServer(t, fake)
+
+			for range 2 {
+				w := serve(s.Handler(), http.MethodGet, "/rest/v1/leaderboard/eu", "")
+				if w.Code != http.StatusOK {
+					t.Fatalf("status = %d, want 200", w.Code)
+				}
+				// A leaderboard too long to cache still streams in full.
+				if n := strings.Count(w.Body.String(), "leaderboardRank"); n != 3 {
+					t.Fatalf("streamed %d players, want 3: %s", n, w.Body)
+				}
+			}
+			wantCalls := int64(2)
+			if tt.wantCached {
+				wantCalls = 1
+			}
+			if n := fake.calls.Load(); n != wantCalls {
+				t.Errorf("upstream calls = %d, want %d", n, wantCalls)
+			}
+		})
+	}
+}
+
+func TestLeaderboardStreamsLargeResponse(t *testing.T) {
+	const n = 5000
+	s := newFakeServer(t, &fakeMMRClient{leaderboard: leaderboardFrom(leaderboardJSON(n))})
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/rest/v1/leaderboard/eu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", res.StatusCode)
+	}
+	// Flushing as it goes means the length is never known up front.
+	if !slices.Equal(res.TransferEncoding, []string{"chunked"}) || res.ContentLength != -1 {
+		t.Errorf("transfer encoding %q, length %d, want a chunked response", res.TransferEncoding, res.ContentLength)
+	}
+
+	var body struct {
+		Players []leaderboardEntry `json:"players"`
+		Error   string             `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding the stream: %v", err)
+	}
+	if len(body.Players) != n || body.Error != "" {
+		t.Fatalf("got %d players and error %q, want %d and none", len(body.Players), body.Error, n)
+	}
+	for i, p := range body.Players {
+		if p.LeaderboardRank != i+1 || p.GameName != fmt.Sprintf("p%d", i) {
+			t.Fatalf("players[%d] = %+v, want rank %d in upstream order", i, p, i+1)
+		}
+	}
+}
+
+func TestLeaderboardStreamInterrupted(t *testing.T) {
+	full := leaderboardJSON(300)
+	fake := &fakeMMRClient{leaderboard: leaderboardFrom(full[:len(full)/2])}
+	s := newFakeServer(t, fake)
+
+	for range 2 {
+		w := serve(s.Handler(), http.MethodGet, "/rest/v1/leaderboard/eu", "")
+		// The status went out with the first entries.
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", w.Code)
+		}
+		var body struct {
+			Players []leaderboardEntry `json:"players"`
+			Error   string             `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("an interrupted stream must still be valid JSON: %v", err)
+		}
+		if body.Error == "" || len(body.Players) == 0 || len(body.Players) >= 300 {
+			t.Errorf("got %d players and error %q, want the entries before the cut and an error", len(body.Players), body.Error)
+		}
+	}
+	if n := fake.calls.Load(); n != 2 {
+		t.Errorf("upstream calls = %d, want 2: an interrupted leaderboard must not be cached", n)
+	}
+}
+
+func TestLeaderboardOutlivesRankTTL(t *testing.T) {
+	setVar(t, &cacheTTLJitter, 0)
+	setVar(t, &leaderboardTTL, 15*time.Minute)
+	fake := &fakeMMRClient{
+		mmr:         returns(rankData(15, "Platinum 1", 45, "Diamond 2")),
+		leaderboard: leaderboardFrom(leaderboardJSON(3)),
+	}
+	s := newFakeServer(t, fake)
+	clock := newFakeClock()
+	s.now = clock.now
+	h := s.Handler()
+
+	tests := []struct {
+		name      string
+		target    string
+		advance   time.Duration
+		wantCache string
+	}{
+		{"leaderboard fetched", "/rest/v1/leaderboard/eu", 0, cacheMiss},
+		{"rank fetched", "/rest/v1/rank/eu/foo/bar", 0, cacheMiss},
+		{"rank expired", "/rest/v1/rank/eu/foo/bar", s.cfg.CacheTTL + time.Minute, cacheMiss},
+		{"leaderboard still cached", "/rest/v1/leaderboard/eu", 0, cacheHit},
+		{"leaderboard expired", "/rest/v1/leaderboard/eu", 15 * time.Minute, cacheMiss},
+	}
+	for _, tt := range tests {
+		clock.advance(tt.advance)
+		w := serve(h, http.MethodGet, tt.target, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", tt.name, w.Code, w.Body)
+		}
+		if got := w.Header().Get("X-Cache"); got != tt.wantCache {
+			t.Errorf("%s: X-Cache = %q, want %q", tt.name, got, tt.wantCache)
+		}
+	}
+}
+
+func TestLeaderboardFilter(t *testing.T) {
+	const upstream = `[
+		{"leaderboardRank":1,"gameName":"a","tagLine":"t","rankedRating":900,"competitiveTier":27},
+		{"leaderboardRank":2,"gameName":"b","tagLine":"t","rankedRating":450,"competitiveTier":27},
+		{"leaderboardRank":3,"gameName":"c","tagLine":"t","rankedRating":300,"competitiveTier":26},
+		{"leaderboardRank":4,"gameName":"d","tagLine":"t","rankedRating":90,"competitiveTier":25}
+	]`
+	tests := []struct {
+		query      string
+		wantStatus int
+		// want are the names kept, from the stream and again from the cache.
+		want []string
+	}{
+		{"", http.StatusOK, []string{"a", "b", "c", "d"}},
+		{"?min_tier=26", http.StatusOK, []string{"a", "b", "c"}},
+		{"?min_tier=27", http.StatusOK, []string{"a", "b"}},
+		{"?min_rr=300", http.StatusOK, []string{"a", "b", "c"}},
+		{"?min_tier=26&min_rr=400", http.StatusOK, []string{"a", "b"}},
+		{"?min_rr=1000", http.StatusOK, []string{}},
+		{"?min_tier=0&min_rr=0", http.StatusOK, []string{"a", "b", "c", "d"}},
+		{"?min_tier=28", http.StatusBadRequest, nil},
+		{"?min_tier=-1", http.StatusBadRequest, nil},
+		{"?min_rr=-5", http.StatusBadRequest, nil},
+		{"?min_rr=lots", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			fake := &fakeMMRClient{leaderboard: leaderboardFrom(upstream)}
+			h := newFakeServer(t, fake).Handler()
+			for _, wantCache := range []string{cacheMiss, cacheHit} {
+				w := serve(h, http.MethodGet, "/rest/v1/leaderboard/eu"+tt.query, "")
+				if w.Code != tt.wantStatus {
+					t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+				}
+				if w.Code != http.StatusOK {
+					if code := decodeBody(t, w.Body.Bytes())["code"]; code != codeInvalidFilter {
+						t.Errorf("code = %v, want %s", code, codeInvalidFilter)
+					}
+					return
+				}
+				var body struct {
+					Players []leaderboardEntry `json:"players"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+					t.Fatalf("decoding %s: %v", w.Body, err)
+				}
+				got := []string{}
+				for _, p := range body.Players {
+					got = append(got, p.GameName)
+				}
+				if !slices.Equal(got, tt.want) || w.Header().Get("X-Cache") != wantCache {
+					t.Errorf("%s: players = %q, want %q", w.Header().Get("X-Cache"), got, tt.want)
+				}
+			}
+			// The cache holds the whole leaderboard whatever was filtered.
+			w := serve(h, http.MethodGet, "/rest/v1/leaderboard/eu", "")
+			var body struct {
+				Players []leaderboardEntry `json:"players"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || len(body.Players) != 4 {
+				t.Errorf("unfiltered after %q = %s, want all 4 players: %v", tt.query, w.Body, err)
+			}
+			if n := fake.calls.Load(); n != 1 {
+				t.Errorf("upstream calls = %d, want 1", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"time"
+	// Embed the timezone database so DEFAULT_TZ works on minimal images.
+	_ "time/tzdata"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+)
+
+var (
+	// defaultLang only picks the Content-Language header. Responses are
+	// not translated, so their text is English whatever the language.
+	defaultLang = cmp.Or(os.Getenv("DEFAULT_LANG"), "en")
+	defaultTZ   = cmp.Or(os.Getenv("DEFAULT_TZ"), "UTC")
+
+	defaultLanguage = language.English
+	defaultLocation = time.UTC
+)
+
+// loadLocale validates DEFAULT_LANG and DEFAULT_TZ and makes them the server
+// defaults. It is called once at startup.
+func loadLocale() error {
+	tag, err := language.Parse(defaultLang)
+	if err != nil {
+		return fmt.Errorf("invalid DEFAULT_LANG %q: %w", defaultLang, err)
+	}
+	loc, err := time.LoadLocation(defaultTZ)
+	if err != nil {
+		return fmt.Errorf("invalid DEFAULT_TZ %q: %w", defaultTZ, err)
+	}
+	defaultLanguage, defaultLocation = tag, loc
+	return nil
+}
+
+// requestLocale resolves the language and timezone for a request, honouring
+// ?lang= and ?tz= overrides of the server defaults. The language is only
+// reported in Content-Language; the timezone is used for updated_at.
+func requestLocale(c *gin.Context) (language.Tag, *time.Location, error) {
+	tag, loc := defaultLanguage, defaultLocation
+
+	if v := c.Query("lang"); v != "" {
+		t, err := language.Parse(v)
+		if err != nil {
+			return tag, loc, fmt.Errorf("Invalid lang: %s", v)
+		}
+		tag = t
+	}
+	if v := c.Query("tz"); v != "" {
+		l, err := time.LoadLocation(v)
+		if err != nil {
+			return tag, loc, fmt.Errorf("Invalid tz: %s", v)
+		}
+		loc = l
+	}
+	return tag, loc, nil
+}
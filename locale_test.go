@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLoadLocale(t *testing.T) {
+	tests := []struct {
+		name, lang, tz string
+		wantErr        string
+	}{
+		{"defaults", "en", "UTC", ""},
+		{"configured", "de-AT", "Europe/Vienna", ""},
+		{"bad lang", "not a tag!", "UTC", "DEFAULT_LANG"},
+		{"bad tz", "en", "Mars/Olympus", "DEFAULT_TZ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &defaultLang, tt.lang)
+			setVar(t, &defaultTZ, tt.tz)
+			setVar(t, &defaultLanguage, defaultLanguage)
+			setVar(t, &defaultLocation, defaultLocation)
+
+			err := loadLocale()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadLocale() = %v, want an error about %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadLocale() = %v", err)
+			}
+			if defaultLocation.String() != tt.tz {
+				t.Errorf("defaultLocation = %v, want %s", defaultLocation, tt.tz)
+			}
+		})
+	}
+}
+
+func TestRankHandlerLocale(t *testing.T) {
+	tests := []struct {
+		name       string
+		defaultTZ  string
+		query      string
+		wantStatus int
+		wantLang   string
+		wantOffset string
+	}{
+		{"server defaults", "UTC", "", http.StatusOK, "en", "Z"},
+		{"configured timezone", "Asia/Seoul", "", http.StatusOK, "en", "+09:00"},
+		{"tz override", "UTC", "?tz=America/Sao_Paulo", http.StatusOK, "en", "-03:00"},
+		{"lang only sets the header", "UTC", "?lang=de", http.StatusOK, "de", "Z"},
+		{"bad tz", "UTC", "?tz=Nowhere", http.StatusBadRequest, "", ""},
+		{"bad lang", "UTC", "?lang=%21%21", http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// testConfig loads the locale from DEFAULT_TZ, as startup does.
+			setVar(t, &defaultTZ, tt.defaultTZ)
+			setVar(t, &defaultLocation, defaultLocation)
+			s := newFakeServer(t, &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))})
+
+			w := serve(s.Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar"+tt.query, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			body := decodeBody(t, w.Body.Bytes())
+			if w.Code != http.StatusOK {
+				if body["code"] != codeInvalidLocale {
+					t.Errorf("code = %v, want %s", body["code"], codeInvalidLocale)
+				}
+				return
+			}
+			if got := w.Header().Get("Content-Language"); got != tt.wantLang {
+				t.Errorf("Content-Language = %q, want %q", got, tt.wantLang)
+			}
+			updated, _ := body["updated_at"].(string)
+			if !strings.HasSuffix(updated, tt.wantOffset) {
+				t.Errorf("updated_at = %q, want offset %s", updated, tt.wantOffset)
+			}
+			if msg := body["message"]; msg != "Platinum 1 [45RR] | Peak: Diamond 2" {
+				t.Errorf("message = %v, want the English rank", msg)
+			}
+		})
+	}
+}
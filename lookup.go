@@ -0,0 +1,280 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// fetchError describes a failure to get any response from upstream.
+func fetchError(err error) *apiError {
+	if errors.Is(err, context.Canceled) {
+		return newAPIError(statusClientClosedRequest, codeClientClosed, "Client closed request")
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return newAPIError(http.StatusGatewayTimeout, codeUpstreamTimeout, "External API did not answer in time")
+	}
+	var paused *pausedError
+	if errors.As(err, &paused) {
+		lerr := newAPIError(http.StatusServiceUnavailable, codeUpstreamPaused, "Upstream rate limit reached, try again later")
+		lerr.retryAfter = paused.remaining
+		return lerr
+	}
+	return newAPIError(http.StatusInternalServerError, codeUpstreamUnreachable, "Issue connecting to external API")
+}
+
+// playerNotFoundError is the error upstream 404s are reported as.
+func playerNotFoundError() *apiError {
+	return newAPIError(http.StatusNotFound, codePlayerNotFound, fmt.Sprintf("API returned status code: %d", http.StatusNotFound))
+}
+
+// upstreamStatusError describes a non-200 upstream response, forwarding the
+// sanitized upstream message when enabled.
+func upstreamStatusError(res *http.Response, apiKey string) *apiError {
+	code := codeUpstreamError
+	switch res.StatusCode {
+	case http.StatusNotFound:
+		code = codePlayerNotFound
+	case http.StatusTooManyRequests:
+		code = codeUpstreamRateLimited
+	}
+
+	lerr := newAPIError(res.StatusCode, code, fmt.Sprintf("API returned status code: %d", res.StatusCode))
+	if forwardUpstreamErrors {
+		if msg := upstreamErrorMessage(res.Body, apiKey); msg != "" {
+			lerr.body["upstream_message"] = msg
+		}
+	}
+	return lerr
+}
+
+// cleanPlayer trims a player's name and tag, rejecting either when nothing is
+// left or it contains a path separator, and refusing names on the denylist.
+func cleanPlayer(name, tag string) (string, string, *apiError) {
+	name, tag = strings.TrimSpace(name), strings.TrimSpace(tag)
+	if name == "" {
+		return "", "", newAPIError(http.StatusBadRequest, codeNameRequired, "Player name is required")
+	}
+	if tag == "" {
+		return "", "", newAPIError(http.StatusBadRequest, codeTagRequired, "Player tag is required")
+	}
+	if strings.ContainsAny(name, `/\`) || strings.ContainsAny(tag, `/\`) {
+		return "", "", newAPIError(http.StatusBadRequest, codeInvalidPlayer, "Player name and tag cannot contain / or \\")
+	}
+	if isDenied(name) {
+		return "", "", newAPIError(http.StatusForbidden, codePlayerDenied, "Lookups for this player are not allowed")
+	}
+	return name, tag, nil
+}
+
+// keyPart normalises a name or tag for use in a cache key: NFC so visually
+// identical names typed with different Unicode compositions match, then
+// Unicode case folding when fold, from CACHE_FOLD_CASE, is set.
+func keyPart(s string, fold bool) string {
+	s = norm.NFC.String(s)
+	if fold {
+		s = cases.Fold().String(s)
+	}
+	return s
+}
+
+// mmrCacheKey builds the cache key for a player from already decoded path
+// values, normalised by keyPart.
+func mmrCacheKey(region, name, tag string, fold bool) string {
+	return fmt.Sprintf("%s:%s:%s", region, keyPart(name, fold), keyPart(tag, fold))
+}
+
+// hasCurrentData reports whether an MMR data payload can be rendered as a rank.
+func hasCurrentData(data map[string]interface{}) bool {
+	_, ok := data["current_data"].(map[string]interface{})
+	return ok
+}
+
+// validRankData reports whether an MMR data payload has current_data in the
+// shape parseRank needs. Only such payloads are cached, so a cached entry
+// failing it is corrupt.
+func validRankData(data map[string]interface{}) bool {
+	currentData, ok := data["current_data"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, ok = currentData["currenttierpatched"].(string)
+	return ok
+}
+
+// accountCacheKey builds the cache key for a player's account details, which
+// are cached apart from MMR data.
+func accountCacheKey(name, tag string, fold bool) string {
+	return fmt.Sprintf("account:%s:%s", keyPart(name, fold), keyPart(tag, fold))
+}
+
+// historyCacheKey builds the cache key for a player's MMR history.
+func historyCacheKey(region, name, tag string, fold bool) string {
+	return "history:" + mmrCacheKey(region, name, tag, fold)
+}
+
+// resultSource says where a lookup result came from.
+type resultSource string
+
+const (
+	sourceCache    resultSource = "cache"
+	sourceStale    resultSource = "stale"
+	sourceNegative resultSource = "negative"
+	sourceUpstream resultSource = "upstream"
+)
+
+// lookupResult is the outcome of a successful lookup.
+type lookupResult struct {
+	data      map[string]interface{}
+	source    resultSource
+	fetchedAt time.Time
+	// expiresAt is when the data leaves our cache.
+	expiresAt time.Time
+}
+
+// cached reports whether the result was served without calling upstream.
+func (r lookupResult) cached() bool {
+	return r.source == sourceCache || r.source == sourceStale
+}
+
+// upstreamFetch fetches a payload from upstream with the given context.
+type upstreamFetch func(ctx context.Context) (map[string]interface{}, *apiError)
+
+// lookupMMR resolves a player's MMR data. Data without current_data is
+// reported as UPSTREAM_EMPTY since there is no rank to show.
+func (s *Server) lookupMMR(ctx context.Context, region, name, tag string) (lookupResult, *apiError) {
+	fetch := func(ctx context.Context) (map[string]interface{}, *apiError) {
+		return s.upstream.GetMMR(ctx, region, name, tag)
+	}
+	result, lerr := s.resolve(ctx, mmrCacheKey(region, name, tag, s.cfg.FoldNameCase), fetch, validRankData)
+	if lerr != nil {
+		return lookupResult{}, lerr
+	}
+	if !hasCurrentData(result.data) {
+		return lookupResult{}, newAPIError(http.StatusBadGateway, codeUpstreamEmpty, "External API returned no rank data")
+	}
+	return result, nil
+}
+
+// lookupAccount resolves a player's account details.
+func (s *Server) lookupAccount(ctx context.Context, region, name, tag string) (lookupResult, *apiError) {
+	fetch := func(ctx context.Context) (map[string]interface{}, *apiError) {
+		return s.upstream.GetAccount(ctx, region, name, tag)
+	}
+	return s.resolve(ctx, accountCacheKey(name, tag, s.cfg.FoldNameCase), fetch, nil)
+}
+
+// lookupHistory resolves a player's recent competitive games. The games are
+// under "items", newest first.
+func (s *Server) lookupHistory(ctx context.Context, region, name, tag string) (lookupResult, *apiError) {
+	fetch := func(ctx context.Context) (map[string]interface{}, *apiError) {
+		return s.upstream.GetHistory(ctx, region, name, tag)
+	}
+	return s.resolve(ctx, historyCacheKey(region, name, tag, s.cfg.FoldNameCase), fetch, nil)
+}
+
+// resolve is the single place a lookup decides where its answer comes from.
+// In order of precedence:
+//
+//  1. a fresh cache entry
+//  2. a stale entry within CACHE_STALE_TTL, refreshed in the background
+//  3. a remembered upstream 404
+//  4. upstream, through the per-request memo when there is one and shared
+//     with concurrent requests for the same key
+//
+// Only payloads passing valid are cached. A cached entry failing it is logged
+// as corrupt and treated as a miss.
+func (s *Server) resolve(ctx context.Context, cacheKey string, fetch upstreamFetch, valid func(map[string]interface{}) bool) (lookupResult, *apiError) {
+	cacheKey = upstreamQueryCacheKey(ctx, tenantCacheKey(ctx, cacheKey))
+	if query := upstreamQueryFrom(ctx); query != nil {
+		// Background refreshes run on their own context; keep the
+		// passthrough parameters the cache key was scoped to.
+		inner := fetch
+		fetch = func(ctx context.Context) (map[string]interface{}, *apiError) {
+			return inner(withUpstreamQuery(ctx, query))
+		}
+	}
+	s.hot.record(cacheKey, fetch, valid)
+
+	entry, found := s.cache.peek(cacheKey)
+	if found && valid != nil && !valid(entry.data) {
+		s.logger.Warn("Corrupt cache entry, refetching", slog.String("key", cacheKey))
+		found = false
+	}
+	if found {
+		result := lookupResult{data: entry.data, source: sourceCache, fetchedAt: entry.timestamp, expiresAt: entry.timestamp.Add(entry.ttl)}
+		if entry.fresh(s.now()) {
+			s.stats.hit()
+			return result, nil
+		}
+		s.stats.stale()
+		s.refreshInBackground(cacheKey, fetch, valid)
+		result.source = sourceStale
+		return result, nil
+	}
+
+	if s.notFound.has(cacheKey) {
+		s.stats.hit()
+		return lookupResult{source: sourceNegative}, playerNotFoundError()
+	}
+	s.countMiss(cacheKey)
+
+	fetchOnce := func() (lookupResult, *apiError) {
+		return s.flights.do(ctx, cacheKey, func() (lookupResult, *apiError) {
+			if !allowUpstream(ctx) {
+				return lookupResult{}, rateLimitedError()
+			}
+			return s.fetchData(ctx, cacheKey, fetch, valid)
+		})
+	}
+	if memo := fetchMemoFrom(ctx); memo != nil {
+		return memo.do(cacheKey, fetchOnce)
+	}
+	return fetchOnce()
+}
+
+// refreshInBackground refetches cacheKey without holding up the request that
+// found it stale. At most one refresh per key runs at a time. It outlives the
+// request, bounded by its own requestBudget, and is cancelled on shutdown.
+func (s *Server) refreshInBackground(cacheKey string, fetch upstreamFetch, valid func(map[string]interface{}) bool) {
+	if _, busy := s.refreshing.LoadOrStore(cacheKey, struct{}{}); busy {
+		return
+	}
+	started := s.background.goFunc(func(ctx context.Context) {
+		defer s.refreshing.Delete(cacheKey)
+		ctx, cancel := context.WithTimeout(ctx, requestBudget)
+		defer cancel()
+		s.fetchData(ctx, cacheKey, fetch, valid)
+	})
+	if !started {
+		s.refreshing.Delete(cacheKey)
+	}
+}
+
+// fetchData runs fetch and caches the payload under cacheKey when it passes
+// valid, remembering a player upstream does not know.
+func (s *Server) fetchData(ctx context.Context, cacheKey string, fetch upstreamFetch, valid func(map[string]interface{}) bool) (lookupResult, *apiError) {
+	data, lerr := fetch(ctx)
+	if lerr != nil {
+		if lerr.code == codePlayerNotFound {
+			s.notFound.add(cacheKey)
+		}
+		return lookupResult{}, lerr
+	}
+	s.notFound.remove(cacheKey)
+
+	ttl := s.cfg.CacheTTL
+	if (valid == nil || valid(data)) && fitsCache(cacheKey, data) {
+		ttl = cmp.Or(s.cache.set(cacheKey, data), s.cfg.CacheTTL)
+	}
+	now := s.now()
+	return lookupResult{data: data, source: sourceUpstream, fetchedAt: now, expiresAt: now.Add(ttl)}, nil
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSpecialCharacterTags(t *testing.T) {
+	tests := []struct {
+		name string
+		// first and again are the same player, encoded differently. wantName
+		// and wantTag are what upstream is sent: first, decoded once.
+		first, again      string
+		wantName, wantTag string
+	}{
+		{"ascii", "/rest/v1/rank/eu/foo/bar", "/rest/v1/rank/eu/foo/bar", "foo", "bar"},
+		{"encoded æ", "/rest/v1/rank/eu/J%C3%A6ger/%C3%A6", "/rest/v1/rank/eu/J%C3%A6ger/%C3%A6", "Jæger", "æ"},
+		{"numeric tag", "/rest/v1/rank/eu/foo/1234", "/rest/v1/rank/eu/foo/1234", "foo", "1234"},
+		{"space", "/rest/v1/rank/eu/Foo%20Bar/EUW", "/rest/v1/rank/eu/Foo%20Bar/EUW", "Foo Bar", "EUW"},
+		{"literal percent", "/rest/v1/rank/eu/100%25/x", "/rest/v1/rank/eu/100%25/x", "100%", "x"},
+		{"plus is not a space", "/rest/v1/rank/eu/a+b/c", "/rest/v1/rank/eu/a%2Bb/c", "a+b", "c"},
+		{"decomposed then composed", "/rest/v1/rank/eu/Jos%65%CC%81/t", "/rest/v1/rank/eu/Jos%C3%A9/t", "Jose\u0301", "t"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu    sync.Mutex
+				paths []string
+			)
+			cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				paths = append(paths, r.URL.Path)
+				mu.Unlock()
+				writeJSON(w, http.StatusOK, mmrBody)
+			})
+			h := newHTTPServer(t, cfg).Handler()
+
+			if w := serve(h, http.MethodGet, tt.first, ""); w.Code != http.StatusOK {
+				t.Fatalf("GET %s = %d: %s", tt.first, w.Code, w.Body)
+			}
+			w := serve(h, http.MethodGet, tt.again, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET %s = %d: %s", tt.again, w.Code, w.Body)
+			}
+			if got := w.Header().Get("X-Cache"); got != cacheHit {
+				t.Errorf("GET %s: X-Cache = %q, want the entry cached by %s", tt.again, got, tt.first)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(paths) != 1 {
+				t.Fatalf("upstream paths = %q, want one call", paths)
+			}
+			if want := "/eu/" + tt.wantName + "/" + tt.wantTag; !strings.HasSuffix(paths[0], want) {
+				t.Errorf("upstream path = %q, want it to end in %q", paths[0], want)
+			}
+		})
+	}
+}
+
+func TestCleanPlayer(t *testing.T) {
+	tests := []struct {
+		name, rawName, rawTag string
+		wantName, wantTag     string
+		wantCode              string
+	}{
+		{"plain", "foo", "bar", "foo", "bar", ""},
+		{"trimmed", "  foo ", "\tbar\n", "foo", "bar", ""},
+		{"inner space kept", "Foo Bar", "EUW", "Foo Bar", "EUW", ""},
+		{"empty name", "", "bar", "", "", codeNameRequired},
+		{"whitespace name", " \t ", "bar", "", "", codeNameRequired},
+		{"empty tag", "foo", "", "", "", codeTagRequired},
+		{"whitespace tag", "foo", "  ", "", "", codeTagRequired},
+		{"both empty reports the name", "", "", "", "", codeNameRequired},
+		{"slash", "fo/o", "bar", "", "", codeInvalidPlayer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, tag, lerr := cleanPlayer(tt.rawName, tt.rawTag)
+			if tt.wantCode != "" {
+				if lerr == nil || lerr.code != tt.wantCode || lerr.status != http.StatusBadRequest {
+					t.Fatalf("cleanPlayer(%q, %q) = %v, want 400 %s", tt.rawName, tt.rawTag, lerr, tt.wantCode)
+				}
+				return
+			}
+			if lerr != nil || name != tt.wantName || tag != tt.wantTag {
+				t.Errorf("cleanPlayer(%q, %q) = %q, %q, %v, want %q, %q", tt.rawName, tt.rawTag, name, tag, lerr, tt.wantName, tt.wantTag)
+			}
+		})
+	}
+}
+
+func TestRankHandlerBlankPlayer(t *testing.T) {
+	tests := []struct {
+		target   string
+		wantCode string
+	}{
+		{"/rest/v1/rank/eu//bar", codeNameRequired},
+		{"/rest/v1/rank/eu/%20/bar", codeNameRequired},
+		{"/rest/v1/rank/eu/%09%20/bar", codeNameRequired},
+		{"/rest/v1/rank/eu/foo/%20", codeTagRequired},
+		{"/rest/v1/rank/eu/foo/%20%0A", codeTagRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			fake := &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))}
+			s := newFakeServer(t, fake)
+
+			w := serve(s.Handler(), http.MethodGet, tt.target, "")
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400: %s", w.Code, w.Body)
+			}
+			if body := decodeBody(t, w.Body.Bytes()); body["code"] != tt.wantCode {
+				t.Errorf("code = %v, want %s", body["code"], tt.wantCode)
+			}
+			if n := fake.calls.Load(); n != 0 {
+				t.Errorf("upstream calls = %d, want none for a blank player", n)
+			}
+		})
+	}
+}
+
+func TestResolvePrecedence(t *testing.T) {
+	const key = "eu:foo:bar"
+	cached := map[string]interface{}{"from": "cache"}
+	fetched := map[string]interface{}{"from": "upstream"}
+	corrupt := map[string]interface{}{"corrupt": true}
+	valid := func(data map[string]interface{}) bool { return data["corrupt"] == nil }
+
+	tests := []struct {
+		name string
+		// stale enables serving stale entries.
+		stale bool
+		// cached is stored in the cache and aged by age.
+		cached   map[string]interface{}
+		age      time.Duration
+		notFound bool
+		// upstream fails with this status when non-zero.
+		upstream int
+
+		wantSource  resultSource
+		wantData    map[string]interface{}
+		wantCode    string
+		wantFetches int64
+	}{
+		{name: "fresh entry", cached: cached, wantSource: sourceCache, wantData: cached},
+		{name: "fresh entry beats a remembered 404", cached: cached, notFound: true, wantSource: sourceCache, wantData: cached},
+		{name: "stale entry", stale: true, cached: cached, age: defaultCacheTTL + time.Second, wantSource: sourceStale, wantData: cached, wantFetches: 1},
+		{name: "stale entry beats a remembered 404", stale: true, cached: cached, age: defaultCacheTTL + time.Second, notFound: true, wantSource: sourceStale, wantData: cached, wantFetches: 1},
+		{name: "expired without stale serving", cached: cached, age: defaultCacheTTL + time.Second, wantSource: sourceUpstream, wantData: fetched, wantFetches: 1},
+		{name: "remembered 404", notFound: true, wantSource: sourceNegative, wantCode: codePlayerNotFound},
+		{name: "corrupt entry", cached: corrupt, wantSource: sourceUpstream, wantData: fetched, wantFetches: 1},
+		{name: "upstream", wantSource: sourceUpstream, wantData: fetched, wantFetches: 1},
+		{name: "upstream 404", upstream: http.StatusNotFound, wantCode: codePlayerNotFound, wantFetches: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.stale {
+				setVar(t, &staleTTL, time.Minute)
+			}
+			setVar(t, &cacheTTLJitter, 0)
+			s := newFakeServer(t, &fakeMMRClient{})
+			clock := newFakeClock()
+			s.now = clock.now
+			if tt.cached != nil {
+				s.cache.set(key, tt.cached)
+			}
+			clock.advance(tt.age)
+			if tt.notFound {
+				s.notFound.add(key)
+			}
+
+			var fetches atomic.Int64
+			fetch := func(context.Context) (map[string]interface{}, *apiError) {
+				fetches.Add(1)
+				if tt.upstream != 0 {
+					return fails(tt.upstream)("eu", "foo", "bar")
+				}
+				return fetched, nil
+			}
+			result, lerr := s.resolve(context.Background(), key, fetch, valid)
+			// A stale answer is refreshed in the background.
+			s.background.wg.Wait()
+
+			if tt.wantCode != "" {
+				if lerr == nil || lerr.code != tt.wantCode {
+					t.Fatalf("resolve() error = %v, want %s", lerr, tt.wantCode)
+				}
+			} else if lerr != nil {
+				t.Fatalf("resolve() error = %v", lerr)
+			}
+			if result.source != tt.wantSource || !maps.Equal(result.data, tt.wantData) {
+				t.Errorf("resolve() = %v from %q, want %v from %q", result.data, result.source, tt.wantData, tt.wantSource)
+			}
+			if n := fetches.Load(); n != tt.wantFetches {
+				t.Errorf("upstream fetches = %d, want %d", n, tt.wantFetches)
+			}
+		})
+	}
+}
+
+func TestResolveRemembersNotFound(t *testing.T) {
+	s := newFakeServer(t, &fakeMMRClient{})
+	var fetches atomic.Int64
+	fetch := func(context.Context) (map[string]interface{}, *apiError) {
+		fetches.Add(1)
+		return fails(http.StatusNotFound)("eu", "foo", "bar")
+	}
+
+	for range 3 {
+		if _, lerr := s.resolve(context.Background(), "eu:foo:bar", fetch, nil); lerr == nil || lerr.code != codePlayerNotFound {
+			t.Fatalf("resolve() error = %v, want %s", lerr, codePlayerNotFound)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("upstream fetches = %d, want 1 with the 404 remembered", n)
+	}
+}
+
+func TestCorruptCacheEntry(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry map[string]interface{}
+	}{
+		{"no current_data", map[string]interface{}{"name": "foo"}},
+		{"current_data not an object", map[string]interface{}{"current_data": "Platinum 1"}},
+		{"null current_data", map[string]interface{}{"current_data": nil}},
+		{"tier name not a string", map[string]interface{}{"current_data": map[string]interface{}{"currenttierpatched": 15}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))}
+			var logs strings.Builder
+			s := NewServer(testConfig(t, "http://upstream.invalid"), slog.New(slog.NewTextHandler(&logs, nil)), fake)
+			s.cache.set(mmrCacheKey("eu", "foo", "bar", false), tt.entry)
+			h := s.Handler()
+
+			w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			if got := w.Header().Get("X-Cache"); got != "MISS" {
+				t.Errorf("X-Cache = %q, want MISS for a corrupt entry", got)
+			}
+			if n := fake.calls.Load(); n != 1 {
+				t.Errorf("upstream calls = %d, want the corrupt entry refetched once", n)
+			}
+			if !strings.Contains(logs.String(), "level=WARN") || !strings.Contains(logs.String(), "Corrupt cache entry") {
+				t.Errorf("logs = %s, want a corruption warning", logs.String())
+			}
+
+			// The refetched payload replaced the corrupt one.
+			w = serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+			if got := w.Header().Get("X-Cache"); got != "HIT" || fake.calls.Load() != 1 {
+				t.Errorf("X-Cache = %q after %d calls, want a HIT of the refetched entry", got, fake.calls.Load())
+			}
+		})
+	}
+}
+
+func TestNameCasePreservedUpstream(t *testing.T) {
+	tests := []struct {
+		name string
+		fold bool
+		// first and again are the same player in different case.
+		first, again string
+		// wantPaths are the player parts upstream is sent, as typed.
+		wantPaths []string
+		wantAgain string
+	}{
+		{"case sensitive keys", false, "Foo/EUW", "foo/euw", []string{"/eu/Foo/EUW", "/eu/foo/euw"}, cacheMiss},
+		{"folded keys", true, "Foo/EUW", "foo/euw", []string{"/eu/Foo/EUW"}, cacheHit},
+		{"folded non-ascii", true, "%C3%84rger/Stra%C3%9Fe", "%C3%A4RGER/STRASSE", []string{"/eu/Ärger/Straße"}, cacheHit},
+		{"same case", false, "Foo/EUW", "Foo/EUW", []string{"/eu/Foo/EUW"}, cacheHit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu    sync.Mutex
+				paths []string
+			)
+			cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				paths = append(paths, r.URL.Path)
+				mu.Unlock()
+				writeJSON(w, http.StatusOK, mmrBody)
+			})
+			cfg.FoldNameCase = tt.fold
+			h := newHTTPServer(t, cfg).Handler()
+
+			serve(h, http.MethodGet, "/rest/v1/rank/eu/"+tt.first, "")
+			w := serve(h, http.MethodGet, "/rest/v1/rank/eu/"+tt.again, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			if got := w.Header().Get("X-Cache"); got != tt.wantAgain {
+				t.Errorf("X-Cache = %q, want %q", got, tt.wantAgain)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(paths) != len(tt.wantPaths) {
+				t.Fatalf("upstream paths = %q, want %q", paths, tt.wantPaths)
+			}
+			for i, want := range tt.wantPaths {
+				if !strings.HasSuffix(paths[i], want) {
+					t.Errorf("upstream path = %q, want it to end in %q", paths[i], want)
+				}
+			}
+		})
+	}
+}
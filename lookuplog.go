@@ -0,0 +1,82 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// lookupLogSize is how many distinct players GET /rest/v1/recent remembers.
+// Zero disables the lookup log.
+var lookupLogSize = envInt("RECENT_LOOKUPS_SIZE", 100)
+
+// playerLookup is a player's entry in the lookup log.
+type playerLookup struct {
+	Region     string    `json:"region"`
+	Name       string    `json:"name"`
+	Tag        string    `json:"tag"`
+	LastLookup time.Time `json:"last_lookup"`
+	Lookups    int       `json:"lookups"`
+}
+
+// lookupLog remembers the players most recently looked up, one entry per
+// player. Once full, the player looked up longest ago is forgotten first.
+type lookupLog struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // of *playerLookup, most recent first
+	players map[string]*list.Element
+	// fold keys players like the cache does, so case variants share an
+	// entry under CACHE_FOLD_CASE.
+	fold bool
+	now  func() time.Time
+}
+
+func newLookupLog(size int, fold bool, now func() time.Time) *lookupLog {
+	return &lookupLog{size: size, order: list.New(), players: make(map[string]*list.Element), fold: fold, now: now}
+}
+
+// add records a lookup of the player.
+func (l *lookupLog) add(region, name, tag string) {
+	if l.size <= 0 {
+		return
+	}
+	key := mmrCacheKey(region, name, tag, l.fold)
+	now := l.now().UTC()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if el, ok := l.players[key]; ok {
+		p := el.Value.(*playerLookup)
+		p.LastLookup = now
+		p.Lookups++
+		l.order.MoveToFront(el)
+		return
+	}
+	l.players[key] = l.order.PushFront(&playerLookup{Region: region, Name: name, Tag: tag, LastLookup: now, Lookups: 1})
+	if l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		p := oldest.Value.(*playerLookup)
+		delete(l.players, mmrCacheKey(p.Region, p.Name, p.Tag, l.fold))
+	}
+}
+
+// recent returns the remembered players, most recently looked up first.
+func (l *lookupLog) recent() []playerLookup {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	players := make([]playerLookup, 0, l.order.Len())
+	for el := l.order.Front(); el != nil; el = el.Next() {
+		players = append(players, *el.Value.(*playerLookup))
+	}
+	return players
+}
+
+// handler serves the players most recently looked up.
+func (l *lookupLog) handler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"players": l.recent()})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLookupLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		lookups []string
+		// want are the remembered names and lookup counts, most recent first.
+		want []string
+	}{
+		{"empty", 3, nil, nil},
+		{"most recent first", 3, []string{"a", "b", "c"}, []string{"c:1", "b:1", "a:1"}},
+		{"repeat moves to the front", 3, []string{"a", "b", "a"}, []string{"a:2", "b:1"}},
+		{"oldest evicted", 2, []string{"a", "b", "c"}, []string{"c:1", "b:1"}},
+		{"a repeat survives eviction", 2, []string{"a", "b", "a", "c"}, []string{"c:1", "a:2"}},
+		{"evicted player starts over", 1, []string{"a", "b", "a"}, []string{"a:1"}},
+		{"disabled", 0, []string{"a", "b"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLookupLog(tt.size, false, time.Now)
+			for _, name := range tt.lookups {
+				l.add("eu", name, "t")
+			}
+			var got []string
+			for _, p := range l.recent() {
+				got = append(got, fmt.Sprintf("%s:%d", p.Name, p.Lookups))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("recent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecentHandler(t *testing.T) {
+	setVar(t, &lookupLogSize, 2)
+	fake := &fakeMMRClient{mmr: func(region, name, tag string) (map[string]interface{}, *apiError) {
+		if name == "ghost" {
+			return fails(http.StatusNotFound)(region, name, tag)
+		}
+		return rankData(15, "Platinum 1", 45, "Diamond 2"), nil
+	}}
+	s := opsServer(t, fake)
+	clock := newFakeClock()
+	s.now = clock.now
+	h := s.Handler()
+
+	for _, target := range []string{"/rest/v1/rank/eu/a/t", "/rest/v1/rank/eu/b/t", "/rest/v1/rank/na/a/t", "/rest/v1/rank/eu/a/t", "/rest/v1/rank/eu/ghost/t"} {
+		serve(h, http.MethodGet, target, "")
+		clock.advance(time.Second)
+	}
+
+	if w := serve(h, http.MethodGet, "/rest/v1/recent", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials = %d, want 401", w.Code)
+	}
+	w := serve(h, http.MethodGet, "/rest/v1/recent", "", "Authorization", "Bearer ops")
+	var body struct{ Players []playerLookup }
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("recent = %d %s: %v", w.Code, w.Body, err)
+	}
+
+	// The first eu a lookup and eu b were evicted in turn, and the failed
+	// ghost lookup never recorded.
+	start := newFakeClock().now()
+	want := []playerLookup{
+		{Region: "eu", Name: "a", Tag: "t", LastLookup: start.Add(3 * time.Second).UTC(), Lookups: 1},
+		{Region: "na", Name: "a", Tag: "t", LastLookup: start.Add(2 * time.Second).UTC(), Lookups: 1},
+	}
+	if len(body.Players) != len(want) {
+		t.Fatalf("players = %+v, want %+v", body.Players, want)
+	}
+	for i, p := range body.Players {
+		if p.Region != want[i].Region || p.Name != want[i].Name || p.Lookups != want[i].Lookups || !p.LastLookup.Equal(want[i].LastLookup) {
+			t.Errorf("players[%d] = %+v, want %+v", i, p, want[i])
+		}
+	}
+}
@@ -1,217 +1,108 @@
 package main
 
 import (
-	"cmp"
+	"context"
+	"errors"
+	"io/fs"
 	"log/slog"
-	"os"
-	"fmt"
 	"net/http"
-	"encoding/json"
-	"time"
-	"sync"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gin-gonic/gin"
-
-	sloggin "github.com/samber/slog-gin"
 )
 
-var validRegions = map[string]struct{}{
-    "eu":      {},
-    "na":      {},
-    "latam":   {},
-    "ap":      {},
-    "kr":      {},
-    "br":      {},
+var defaultRegions = map[string]regionInfo{
+	"eu":    {DisplayName: "Europe", TimeZone: "Europe/Berlin"},
+	"na":    {DisplayName: "North America", TimeZone: "America/Chicago"},
+	"latam": {DisplayName: "Latin America", TimeZone: "America/Mexico_City"},
+	"ap":    {DisplayName: "Asia Pacific", TimeZone: "Asia/Singapore"},
+	"kr":    {DisplayName: "Korea", TimeZone: "Asia/Seoul"},
+	"br":    {DisplayName: "Brazil", TimeZone: "America/Sao_Paulo"},
 }
 
-type cacheEntry struct {
-    data      map[string]interface{}
-    timestamp time.Time
-}
-
-var (
-    cache     = make(map[string]cacheEntry)
-    cacheMutex sync.RWMutex
-    cacheTTL  = 5 * time.Minute
-)
+func main() {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+
+	// SIGHUP ends the process by default, so it is caught before anything
+	// else: a reload asked for during startup waits for the reload loop
+	// instead of killing the server.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.Error("Invalid configuration", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	port := cfg.Port
 
-var httpClient = &http.Client{
-    Timeout: 10 * time.Second,
-    Transport: &http.Transport{
-        MaxIdleConns:        100,
-        MaxIdleConnsPerHost: 100,
-        IdleConnTimeout:     90 * time.Second,
-    },
-}
+	logConfig(logger, cfg)
+	upstream := newHTTPMMRClient(cfg, logger)
+	var client MMRClient = upstream
+	if cfg.Offline {
+		client = offlineMMRClient{}
+	}
+	s := NewServer(cfg, logger, client)
 
-func isValidRegion(region string) bool {
-    _, ok := validRegions[region]
-    return ok
-}
+	s.warmInBackground(upstream)
 
-func getFromCache(key string) (map[string]interface{}, bool) {
-    cacheMutex.RLock()
-    defer cacheMutex.RUnlock()
-    
-    if entry, exists := cache[key]; exists {
-        if time.Since(entry.timestamp) < cacheTTL {
-            return entry.data, true
-        }
-    }
-    return nil, false
-}
+	if snapshotPath != "" {
+		if n, err := s.cache.loadSnapshot(snapshotPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logger.Error("Failed to load cache snapshot", slog.String("error", err.Error()))
+		} else if err == nil {
+			logger.Info("Loaded cache snapshot", slog.Int("entries", n))
+		}
+	}
+	stopJanitor := s.startJanitor(janitorInterval)
 
-func setCache(key string, data map[string]interface{}) {
-    cacheMutex.Lock()
-    defer cacheMutex.Unlock()
-    
-    cache[key] = cacheEntry{
-        data:      data,
-        timestamp: time.Now(),
-    }
-}
+	if cfg.ValidateAPIKey && !cfg.Offline {
+		if err := checkAPIKey(cfg, logger, upstream); err != nil {
+			os.Exit(1)
+		}
+	}
 
-func main() {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
-	apiKey := os.Getenv("VALORANT_API_KEY")
-
-	gin.SetMode(gin.ReleaseMode)
-	r := gin.New()
-
-	r.Use(sloggin.New(logger))
-	r.Use(gin.Recovery())
-
-	{
-		v1 := r.Group("/rest/v1")
-		v1.GET("/rank/:region/:name/:tag", func(c *gin.Context) {
-			start := time.Now()
-	
-			format := c.Query("format")
-	
-			region := c.Param("region")
-			name := c.Param("name")
-			tag := c.Param("tag")
-	
-			if !isValidRegion(region) {
-				c.JSON(http.StatusBadRequest, gin.H{
-					"error": "Invalid Region: " + region,
-				})
-				return
-			}
-	
-			cacheKey := fmt.Sprintf("%s:%s:%s", region, name, tag)
-	
-			if cachedData, found := getFromCache(cacheKey); found {
-				if currentData, ok := cachedData["current_data"].(map[string]interface{}); ok {
-					rank, ok := currentData["currenttierpatched"].(string)
-					if !ok {
-						c.JSON(http.StatusInternalServerError, gin.H{
-							"error": "Invalid rank data type",
-						})
-						return
-					}
-					rr, ok := currentData["ranking_in_tier"].(float64)
-					if !ok {
-						c.JSON(http.StatusInternalServerError, gin.H{
-							"error": "Invalid RR data type",
-						})
-						return
-					}
-					
-					var highestRank string
-					if highestRankObj, ok := cachedData["highest_rank"].(map[string]interface{}); ok {
-						if patchedTier, ok := highestRankObj["patched_tier"].(string); ok {
-							highestRank = patchedTier
-						}
-					}
-	
-					latency := time.Since(start)
-	
-					if format == "text" {
-						c.String(http.StatusOK, fmt.Sprintf("%s [%dRR] | Peak: %s)", rank, int(rr), highestRank))
-						return
-					}
-	
-					c.JSON(http.StatusOK, gin.H{
-						"message": fmt.Sprintf("%s [%dRR] | Peak: %s", rank, int(rr), highestRank),
-						"latency:ms": latency.Milliseconds(),
-						"cached": true,
-					})
-					return
-				}
-			}
-	
-			res, err := httpClient.Get(fmt.Sprintf("https://api.henrikdev.xyz/valorant/v2/mmr/%s/%s/%s?api_key=%s", region, name, tag, apiKey))
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": "Issue connecting to external API",
-				})
-				return
-			}
-			defer res.Body.Close()
-	
-			if res.StatusCode != http.StatusOK {
-				c.JSON(res.StatusCode, gin.H{
-					"error": fmt.Sprintf("API returned status code: %d", res.StatusCode),
-				})
-				return
-			}
-	
-			var result map[string]interface{}
-			if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": "Failed to parse API response",
-				})
-				return
-			}
-	
-			if data, ok := result["data"].(map[string]interface{}); ok {
-				setCache(cacheKey, data)
-	
-				if currentData, ok := data["current_data"].(map[string]interface{}); ok {
-					rank, ok := currentData["currenttierpatched"].(string)
-					if !ok {
-						c.JSON(http.StatusInternalServerError, gin.H{
-							"error": "Invalid rank data type",
-						})
-						return
-					}
-					rr, ok := currentData["ranking_in_tier"].(float64)
-					if !ok {
-						c.JSON(http.StatusInternalServerError, gin.H{
-							"error": "Invalid RR data type",
-						})
-						return
-					}
-					
-					var highestRank string
-					if highestRankObj, ok := data["highest_rank"].(map[string]interface{}); ok {
-						if patchedTier, ok := highestRankObj["patched_tier"].(string); ok {
-							highestRank = patchedTier
-						}
-					}
-	
-					latency := time.Since(start)
-	
-					if format == "text" {
-						c.String(http.StatusOK, fmt.Sprintf("%s [%dRR] | Peak: %s", rank, int(rr), highestRank))
-						return
-					}
-	
-					c.JSON(http.StatusOK, gin.H{
-						"message": fmt.Sprintf("%s [%dRR] | Peak: %s", rank, int(rr), highestRank),
-						"latency:ms": latency.Milliseconds(),
-						"cached": false,
-					})
-					return
-				}
-			}
-		})
+	// gin already honours GIN_MODE; default to release instead of debug.
+	if os.Getenv(gin.EnvGinMode) == "" {
+		gin.SetMode(gin.ReleaseMode)
 	}
 
-	port := cmp.Or(os.Getenv("PORT"), "8080")
+	srv := s.HTTPServer()
 
-	logger.Info("Server starting", slog.String("port", port))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go reloadOnHangup(ctx, logger, hup)
 
-	r.Run((":" + port))
+	go func() {
+		logger.Info("Server starting", slog.String("port", port))
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Server failed", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+	}()
+
+	<-ctx.Done()
+	logger.Info("Shutting down")
+
+	// Order matters: stop background writers first, then let in-flight
+	// handlers finish, cancel the background work they may have started, and
+	// only then freeze the cache and snapshot it.
+	stopJanitor()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Graceful shutdown failed", slog.String("error", err.Error()))
+	}
+	s.background.stop()
+
+	s.cache.close()
+	if snapshotPath != "" {
+		if err := s.cache.saveSnapshot(snapshotPath); err != nil {
+			logger.Error("Failed to write cache snapshot", slog.String("error", err.Error()))
+		}
+	}
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+type fetchMemoKey struct{}
+
+// memoCall is one upstream fetch, shared by every caller asking for the same
+// cache key during a request.
+type memoCall struct {
+	done   chan struct{}
+	result lookupResult
+	err    *apiError
+}
+
+// fetchMemo deduplicates upstream fetches within a single request, so
+// features that need the same endpoint trigger only one call between them.
+type fetchMemo struct {
+	mu    sync.Mutex
+	calls map[string]*memoCall
+}
+
+// do returns the outcome of fetch for key, running it at most once per memo.
+// Concurrent callers for the same key wait for the first one to finish.
+func (m *fetchMemo) do(key string, fetch func() (lookupResult, *apiError)) (lookupResult, *apiError) {
+	m.mu.Lock()
+	if call, ok := m.calls[key]; ok {
+		m.mu.Unlock()
+		<-call.done
+		return call.result, call.err
+	}
+	call := &memoCall{done: make(chan struct{})}
+	m.calls[key] = call
+	m.mu.Unlock()
+
+	call.result, call.err = fetch()
+	close(call.done)
+	return call.result, call.err
+}
+
+// withFetchMemo returns a context carrying a fresh fetch memo.
+func withFetchMemo(ctx context.Context) context.Context {
+	return context.WithValue(ctx, fetchMemoKey{}, &fetchMemo{calls: make(map[string]*memoCall)})
+}
+
+// fetchMemoFrom returns the memo carried by ctx, or nil.
+func fetchMemoFrom(ctx context.Context) *fetchMemo {
+	memo, _ := ctx.Value(fetchMemoKey{}).(*fetchMemo)
+	return memo
+}
+
+// requestMemo gives every request its own fetch memo.
+func requestMemo() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(withFetchMemo(c.Request.Context()))
+		c.Next()
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFetchMemo(t *testing.T) {
+	memo := fetchMemoFrom(withFetchMemo(context.Background()))
+	var calls atomic.Int64
+	fetch := func() (lookupResult, *apiError) {
+		calls.Add(1)
+		return lookupResult{}, newAPIError(http.StatusServiceUnavailable, codeUpstreamError, "down")
+	}
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, lerr := memo.do("eu:foo:bar", fetch); lerr == nil || lerr.code != codeUpstreamError {
+				t.Errorf("do() = %v, want the shared failure", lerr)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Errorf("fetches for one key = %d, want 1", n)
+	}
+	memo.do("eu:other:bar", fetch)
+	if n := calls.Load(); n != 2 {
+		t.Errorf("fetches for two keys = %d, want 2", n)
+	}
+	if fetchMemoFrom(context.Background()) != nil {
+		t.Error("a context without a memo returned one")
+	}
+}
+
+func TestRequestMemoDeduplicatesFlags(t *testing.T) {
+	// Failures are not cached, so only the memo keeps the flags sharing an
+	// endpoint down to one call each.
+	var account, history atomic.Int64
+	fake := &fakeMMRClient{
+		mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2")),
+		account: func(region, name, tag string) (map[string]interface{}, *apiError) {
+			account.Add(1)
+			return fails(http.StatusServiceUnavailable)(region, name, tag)
+		},
+		history: func(region, name, tag string) (map[string]interface{}, *apiError) {
+			history.Add(1)
+			return fails(http.StatusServiceUnavailable)(region, name, tag)
+		},
+	}
+	h := newFakeServer(t, fake).Handler()
+
+	w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar?level=true&card=true&recent=true&estimate=true", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if a, n := account.Load(), history.Load(); a != 1 || n != 1 {
+		t.Errorf("account calls = %d, history calls = %d, want 1 each", a, n)
+	}
+	// A new request gets a new memo.
+	serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar?level=true&card=true", "")
+	if a := account.Load(); a != 2 {
+		t.Errorf("account calls = %d after a second request, want 2", a)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the latency histogram.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricKey labels a series. route is the gin route template rather than the
+// request path so path params don't blow up cardinality.
+type metricKey struct {
+	route  string
+	status string
+}
+
+type histogram struct {
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range latencyBuckets {
+		if v <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// metrics collects per route request counts and latencies and renders them
+// in the Prometheus text exposition format.
+type metrics struct {
+	mu       sync.Mutex
+	requests map[metricKey]uint64
+	latency  map[metricKey]*histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests: make(map[metricKey]uint64),
+		latency:  make(map[metricKey]*histogram),
+	}
+}
+
+// statusClass buckets a status code into 2xx, 4xx and so on.
+func statusClass(code int) string {
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// middleware records every request once it has been handled.
+func (m *metrics) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		key := metricKey{
+			route:  cmp.Or(c.FullPath(), "unmatched"),
+			status: statusClass(c.Writer.Status()),
+		}
+		elapsed := time.Since(start).Seconds()
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.requests[key]++
+		h, ok := m.latency[key]
+		if !ok {
+			h = &histogram{buckets: make([]uint64, len(latencyBuckets))}
+			m.latency[key] = h
+		}
+		h.observe(elapsed)
+	}
+}
+
+// handler serves the collected metrics.
+func (m *metrics) handler(c *gin.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]metricKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b metricKey) int {
+		return cmp.Or(cmp.Compare(a.route, b.route), cmp.Compare(a.status, b.status))
+	})
+
+	var b strings.Builder
+	b.WriteString("# HELP http_requests_total Requests handled, by route and status class.\n")
+	b.WriteString("# TYPE http_requests_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "http_requests_total{route=%q,status=%q} %d\n", k.route, k.status, m.requests[k])
+	}
+
+	b.WriteString("# HELP http_request_duration_seconds Request latency, by route and status class.\n")
+	b.WriteString("# TYPE http_request_duration_seconds histogram\n")
+	for _, k := range keys {
+		h := m.latency[k]
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(&b, "http_request_duration_seconds_bucket{route=%q,status=%q,le=%q} %d\n",
+				k.route, k.status, strconv.FormatFloat(bound, 'g', -1, 64), h.buckets[i])
+		}
+		fmt.Fprintf(&b, "http_request_duration_seconds_bucket{route=%q,status=%q,le=\"+Inf\"} %d\n", k.route, k.status, h.count)
+		fmt.Fprintf(&b, "http_request_duration_seconds_sum{route=%q,status=%q} %g\n", k.route, k.status, h.sum)
+		fmt.Fprintf(&b, "http_request_duration_seconds_count{route=%q,status=%q} %d\n", k.route, k.status, h.count)
+	}
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetricsLabels(t *testing.T) {
+	fake := &fakeMMRClient{
+		mmr: func(region, name, tag string) (map[string]interface{}, *apiError) {
+			if name == "missing" {
+				return fails(http.StatusNotFound)(region, name, tag)
+			}
+			return rankData(15, "Platinum 1", 45, "Diamond 2"), nil
+		},
+		leaderboard: leaderboardFrom(leaderboardJSON(3)),
+	}
+	h := newFakeServer(t, fake).Handler()
+
+	for _, target := range []string{
+		"/rest/v1/rank/eu/foo/bar",
+		"/rest/v1/rank/na/other/player",
+		"/rest/v1/rank/eu/missing/bar",
+		"/rest/v1/leaderboard/eu",
+		"/no/such/route",
+	} {
+		serve(h, http.MethodGet, target, "")
+	}
+	w := serve(h, http.MethodGet, "/metrics", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /metrics = %d", w.Code)
+	}
+	out := w.Body.String()
+
+	// Series are keyed by route template, so two players share one.
+	for _, want := range []string{
+		`http_requests_total{route="/rest/v1/rank/:region/:name/:tag",status="2xx"} 2`,
+		`http_requests_total{route="/rest/v1/rank/:region/:name/:tag",status="4xx"} 1`,
+		`http_requests_total{route="/rest/v1/leaderboard/:region",status="2xx"} 1`,
+		`http_requests_total{route="unmatched",status="4xx"} 1`,
+		`http_request_duration_seconds_count{route="/rest/v1/rank/:region/:name/:tag",status="2xx"} 2`,
+		`http_request_duration_seconds_count{route="/rest/v1/leaderboard/:region",status="2xx"} 1`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("metrics lack %s:\n%s", want, out)
+		}
+	}
+	for _, leak := range []string{"foo", "other", "missing"} {
+		if strings.Contains(out, leak) {
+			t.Errorf("metrics mention the player %q: labels must not come from path values", leak)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// requestBudget is the total time a request may take end to end.
+	requestBudget = envDuration("REQUEST_TIMEOUT", 10*time.Second)
+	// minUpstreamHeadroom is the least amount of budget that must remain for
+	// an upstream call to be worth attempting.
+	minUpstreamHeadroom = envDuration("UPSTREAM_MIN_HEADROOM", 500*time.Millisecond)
+)
+
+// requestTimeout attaches a deadline of d to every request context so work
+// done on behalf of the request can tell how much time it has left.
+func requestTimeout(d time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// clientDisconnectLog notes at debug level when a client went away before its
+// request finished. The context is captured before requestTimeout wraps it,
+// so only a real disconnect is reported and not the budget's own cancel.
+func clientDisconnectLog(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		c.Next()
+
+		if errors.Is(ctx.Err(), context.Canceled) {
+			logger.Debug("Client disconnected",
+				slog.String("route", c.FullPath()),
+				slog.Int("status", c.Writer.Status()),
+			)
+		}
+	}
+}
+
+// hasHeadroom reports whether ctx has at least min left before its deadline.
+// Contexts without a deadline always have headroom.
+func hasHeadroom(ctx context.Context, min time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Until(deadline) >= min
+}
+
+// rejectEncodedSlashes refuses paths containing an encoded / or \. Routing
+// happens on the decoded path, so such a segment would otherwise be split in
+// two and a name like "a%2Fb" looked up as the name "a" with the tag "b".
+func rejectEncodedSlashes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		escaped := strings.ToUpper(c.Request.URL.EscapedPath())
+		if strings.Contains(escaped, "%2F") || strings.Contains(escaped, "%5C") {
+			respondError(c, newAPIError(http.StatusBadRequest, codeInvalidPath, "Path segments cannot contain / or \\"))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// latestAPIVersion is the response format version served when a client does
+// not ask for one.
+const latestAPIVersion = "1"
+
+// supportedAPIVersions are the X-API-Version values clients may request.
+var supportedAPIVersions = map[string]bool{"1": true}
+
+// apiVersion checks the optional X-API-Version request header, refusing
+// versions this server cannot serve. The version served is echoed back, and
+// shared caches are told responses vary with it.
+func apiVersion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		version := cmp.Or(strings.TrimSpace(c.GetHeader("X-API-Version")), latestAPIVersion)
+		if !supportedAPIVersions[version] {
+			respondError(c, newAPIError(http.StatusBadRequest, codeUnsupportedVersion, "Unsupported API version: "+version))
+			c.Abort()
+			return
+		}
+		c.Header("X-API-Version", version)
+		c.Writer.Header().Add("Vary", "X-API-Version")
+		c.Next()
+	}
+}
+
+// deprecation marks every response of the group it is attached to as
+// deprecated, announcing sunset as the date after which it may be removed.
+func deprecation(sunset time.Time) gin.HandlerFunc {
+	sunsetHeader := sunset.UTC().Format(http.TimeFormat)
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Header("Sunset", sunsetHeader)
+		c.Next()
+	}
+}
+
+// securityHeaders sets conservative browser security headers on every
+// response. csp is only sent when non-empty.
+func securityHeaders(referrerPolicy, csp string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Header("Referrer-Policy", referrerPolicy)
+		if csp != "" {
+			c.Header("Content-Security-Policy", csp)
+		}
+		c.Next()
+	}
+}
+
+// slowRequestLog warns about every request that takes longer than threshold.
+func slowRequestLog(logger *slog.Logger, threshold time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		if latency := time.Since(start); latency > threshold {
+			logger.Warn("Slow request",
+				slog.String("route", cmp.Or(c.FullPath(), "unmatched")),
+				slog.Int("status", c.Writer.Status()),
+				slog.Int64("latency_ms", latency.Milliseconds()),
+				slog.String("threshold", threshold.String()),
+			)
+		}
+	}
+}
+
+// noStore forbids intermediaries from caching the route's responses.
+func noStore() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "no-store")
+		c.Next()
+	}
+}
+
+// setMaxAge replaces the route's Cache-Control with the time d the served
+// data has left in our cache, so intermediaries expire it when we do.
+func setMaxAge(c *gin.Context, d time.Duration) {
+	c.Header("Cache-Control", "max-age="+strconv.Itoa(int(max(d, 0).Round(time.Second).Seconds())))
+}
+
+// cacheFor lets intermediaries cache the route's responses for ttl, in line
+// with our own cache. Error responses override this with no-store.
+func cacheFor(ttl time.Duration) gin.HandlerFunc {
+	value := "max-age=" + strconv.Itoa(int(ttl.Seconds()))
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", value)
+		c.Next()
+	}
+}
+
+// clientAuth only lets through requests bearing token as a bearer token.
+func clientAuth(token string) gin.HandlerFunc {
+	want := []byte("Bearer " + token)
+	return func(c *gin.Context) {
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), want) != 1 {
+			respondError(c, newAPIError(http.StatusUnauthorized, codeUnauthorized, "Missing or invalid credentials"))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHasHeadroom(t *testing.T) {
+	tests := []struct {
+		name   string
+		budget time.Duration
+		min    time.Duration
+		want   bool
+	}{
+		{"plenty left", time.Minute, time.Second, true},
+		{"too little left", 100 * time.Millisecond, time.Second, false},
+		{"already expired", -time.Second, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), tt.budget)
+			defer cancel()
+			if got := hasHeadroom(ctx, tt.min); got != tt.want {
+				t.Errorf("hasHeadroom() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if !hasHeadroom(context.Background(), time.Hour) {
+		t.Error("hasHeadroom() = false for a context without deadline")
+	}
+}
+
+func TestNearlyExpiredBudgetFailsFast(t *testing.T) {
+	setVar(t, &requestBudget, 100*time.Millisecond)
+	setVar(t, &minUpstreamHeadroom, 500*time.Millisecond)
+	var calls atomic.Int64
+	cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		writeJSON(w, http.StatusOK, mmrBody)
+	})
+	s := newHTTPServer(t, cfg)
+
+	start := time.Now()
+	w := serve(s.Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503: %s", w.Code, w.Body)
+	}
+	if body := decodeBody(t, w.Body.Bytes()); body["code"] != codeInsufficientBudget {
+		t.Errorf("code = %v, want %s", body["code"], codeInsufficientBudget)
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("upstream calls = %d, want none", n)
+	}
+	if elapsed := time.Since(start); elapsed > requestBudget {
+		t.Errorf("request took %v, want it refused before the %v budget ran out", elapsed, requestBudget)
+	}
+}
+
+func TestBudgetBoundsRetriesAndFailover(t *testing.T) {
+	tests := []struct {
+		name string
+		// slow is how long each upstream call takes before failing with a
+		// 502; zero hangs until the caller gives up.
+		slow           time.Duration
+		attemptTimeout time.Duration
+		fallback       bool
+	}{
+		{"slow retries", 150 * time.Millisecond, 0, false},
+		{"slow retries and failover", 150 * time.Millisecond, 0, true},
+		{"hung attempts", 0, 100 * time.Millisecond, false},
+		{"hung attempts and failover", 0, 100 * time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &requestBudget, 400*time.Millisecond)
+			setVar(t, &minUpstreamHeadroom, 10*time.Millisecond)
+			setVar(t, &maxUpstreamRetries, 5)
+			setVar(t, &retryBackoff, 10*time.Millisecond)
+
+			var calls atomic.Int64
+			slow := func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				if tt.slow == 0 {
+					<-r.Context().Done()
+					return
+				}
+				select {
+				case <-time.After(tt.slow):
+					writeJSON(w, http.StatusBadGateway, `{"status":502}`)
+				case <-r.Context().Done():
+				}
+			}
+			cfg := newUpstream(t, slow)
+			cfg.UpstreamAttemptTimeout = tt.attemptTimeout
+			if tt.fallback {
+				fallback := httptest.NewServer(http.HandlerFunc(slow))
+				t.Cleanup(fallback.Close)
+				cfg.UpstreamFallbackURL = fallback.URL
+			}
+			s := newHTTPServer(t, cfg)
+
+			start := time.Now()
+			w := serve(s.Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+			elapsed := time.Since(start)
+			if w.Code != http.StatusGatewayTimeout {
+				t.Fatalf("status = %d, want 504: %s", w.Code, w.Body)
+			}
+			if body := decodeBody(t, w.Body.Bytes()); body["code"] != codeUpstreamTimeout {
+				t.Errorf("code = %v, want %s", body["code"], codeUpstreamTimeout)
+			}
+			if n := calls.Load(); n < 2 {
+				t.Errorf("upstream calls = %d, want the budget spent on several attempts", n)
+			}
+			if elapsed > requestBudget+100*time.Millisecond {
+				t.Errorf("request took %v, want it within the %v budget", elapsed, requestBudget)
+			}
+		})
+	}
+}
+
+func TestV1Deprecation(t *testing.T) {
+	sunset := time.Date(2027, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		sunset     time.Time
+		target     string
+		wantSunset string
+	}{
+		{"v1 with sunset", sunset, "/rest/v1/regions", "Mon, 01 Mar 2027 00:00:00 GMT"},
+		{"v1 errors too", sunset, "/rest/v1/rank/nowhere/foo/bar", "Mon, 01 Mar 2027 00:00:00 GMT"},
+		{"outside v1", sunset, "/metrics", ""},
+		{"no sunset configured", time.Time{}, "/rest/v1/regions", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, "http://upstream.invalid")
+			cfg.V1Sunset = tt.sunset
+			s := NewServer(cfg, discardLogger(), &fakeMMRClient{})
+
+			w := serve(s.Handler(), http.MethodGet, tt.target, "")
+			wantDeprecation := ""
+			if tt.wantSunset != "" {
+				wantDeprecation = "true"
+			}
+			if got := w.Header().Get("Deprecation"); got != wantDeprecation {
+				t.Errorf("Deprecation = %q, want %q", got, wantDeprecation)
+			}
+			if got := w.Header().Get("Sunset"); got != tt.wantSunset {
+				t.Errorf("Sunset = %q, want %q", got, tt.wantSunset)
+			}
+		})
+	}
+}
+
+func TestV1SunsetFromEnv(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"", time.Time{}, false},
+		{"2027-03-01", time.Date(2027, 3, 1, 0, 0, 0, 0, time.UTC), false},
+		{"March 2027", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("V1_SUNSET", tt.value)
+		cfg, err := loadConfig()
+		if (err != nil) != tt.wantErr || !cfg.V1Sunset.Equal(tt.want) {
+			t.Errorf("V1_SUNSET=%q: V1Sunset = %v, err = %v; want %v, error %v", tt.value, cfg.V1Sunset, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want map[string]string
+	}{
+		{"defaults", nil, map[string]string{
+			"X-Content-Type-Options":  "nosniff",
+			"Referrer-Policy":         "no-referrer",
+			"Content-Security-Policy": "",
+		}},
+		{"configured", map[string]string{"REFERRER_POLICY": "same-origin", "CONTENT_SECURITY_POLICY": "default-src 'none'"}, map[string]string{
+			"X-Content-Type-Options":  "nosniff",
+			"Referrer-Policy":         "same-origin",
+			"Content-Security-Policy": "default-src 'none'",
+		}},
+		{"disabled", map[string]string{"SECURITY_HEADERS": "false"}, map[string]string{
+			"X-Content-Type-Options":  "",
+			"Referrer-Policy":         "",
+			"Content-Security-Policy": "",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			s := newFakeServer(t, &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))})
+			h := s.Handler()
+
+			// Successes, errors and unmatched routes alike.
+			for _, target := range []string{"/rest/v1/rank/eu/foo/bar", "/rest/v1/rank/mars/foo/bar", "/metrics", "/nowhere"} {
+				w := serve(h, http.MethodGet, target, "")
+				for header, want := range tt.want {
+					if got := w.Header().Get(header); got != want {
+						t.Errorf("GET %s: %s = %q, want %q", target, header, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSlowRequestLog(t *testing.T) {
+	tests := []struct {
+		name      string
+		delay     time.Duration
+		threshold time.Duration
+		wantWarn  bool
+	}{
+		{"fast", 0, time.Second, false},
+		{"slow", 60 * time.Millisecond, 20 * time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, "http://upstream.invalid")
+			cfg.SlowRequestThreshold = tt.threshold
+			var logs bytes.Buffer
+			fake := &fakeMMRClient{mmr: func(string, string, string) (map[string]interface{}, *apiError) {
+				time.Sleep(tt.delay)
+				return rankData(15, "Platinum 1", 45, "Diamond 2"), nil
+			}}
+			s := NewServer(cfg, slog.New(slog.NewJSONHandler(&logs, nil)), fake)
+
+			serve(s.Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+
+			var warned bool
+			for _, line := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+				var entry struct {
+					Level, Msg, Route, Threshold string
+					LatencyMS                    int64 `json:"latency_ms"`
+				}
+				if err := json.Unmarshal(line, &entry); err != nil || entry.Msg != "Slow request" {
+					continue
+				}
+				warned = true
+				if entry.Level != "WARN" || entry.Route != "/rest/v1/rank/:region/:name/:tag" ||
+					entry.LatencyMS < tt.delay.Milliseconds() || entry.Threshold != tt.threshold.String() {
+					t.Errorf("slow request log = %s, want a warning with the route, latency and threshold", line)
+				}
+			}
+			if warned != tt.wantWarn {
+				t.Errorf("slow request warning logged: %v, want %v:\n%s", warned, tt.wantWarn, logs.String())
+			}
+		})
+	}
+}
+
+func TestCacheControlPerRoute(t *testing.T) {
+	setVar(t, &cacheTTLJitter, 0)
+	fake := &fakeMMRClient{
+		mmr: func(region, name, tag string) (map[string]interface{}, *apiError) {
+			if name == "missing" {
+				return fails(http.StatusNotFound)(region, name, tag)
+			}
+			return rankData(15, "Platinum 1", 45, "Diamond 2"), nil
+		},
+		leaderboard: leaderboardFrom(leaderboardJSON(3)),
+	}
+	cfg := testConfig(t, "http://upstream.invalid")
+	cfg.ClientAPIKey = "ops"
+	s := NewServer(cfg, discardLogger(), fake)
+	s.now = newFakeClock().now
+	h := s.Handler()
+
+	maxAge := func(d time.Duration) string { return "max-age=" + strconv.Itoa(int(d.Seconds())) }
+	tests := []struct {
+		method, target, body string
+		want                 string
+	}{
+		{http.MethodGet, "/rest/v1/rank/eu/foo/bar", "", maxAge(cfg.CacheTTL)},
+		{http.MethodGet, "/rest/v1/rank/eu/missing/bar", "", "no-store"},
+		{http.MethodGet, "/rest/v1/rank/mars/foo/bar", "", "no-store"},
+		{http.MethodGet, "/rest/v1/regions", "", maxAge(cfg.CacheTTL)},
+		{http.MethodGet, "/rest/v1/leaderboard/eu", "", maxAge(leaderboardTTL)},
+		{http.MethodGet, "/rest/v1/rank/eu/foo%23bar,baz%23qux", "", "no-store"},
+		{http.MethodPost, "/rest/v1/ranks", `{"players":[{"region":"eu","name":"foo","tag":"bar"}]}`, "no-store"},
+		{http.MethodGet, "/metrics", "", "no-store"},
+		{http.MethodGet, "/cache/stats", "", "no-store"},
+		{http.MethodGet, "/rest/v1/recent", "", "no-store"},
+	}
+	for _, tt := range tests {
+		w := serve(h, tt.method, tt.target, tt.body, "Authorization", "Bearer ops")
+		if got := w.Header().Get("Cache-Control"); got != tt.want {
+			t.Errorf("%s %s (%d): Cache-Control = %q, want %q", tt.method, tt.target, w.Code, got, tt.want)
+		}
+	}
+}
+
+func TestClientDisconnect(t *testing.T) {
+	arrived := make(chan struct{})
+	cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-r.Context().Done()
+	})
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	s := NewServer(cfg, logger, newHTTPMMRClient(cfg, discardLogger()))
+	h := s.Handler()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/rest/v1/rank/eu/foo/bar", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(w, req)
+	}()
+	<-arrived
+	cancel()
+	<-done
+
+	if w.Code != statusClientClosedRequest {
+		t.Errorf("status = %d, want %d", w.Code, statusClientClosedRequest)
+	}
+	var disconnected bool
+	for _, line := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+		var entry struct {
+			Level, Msg string
+			Status     int
+		}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatalf("decoding log %q: %v", line, err)
+		}
+		if entry.Level == "ERROR" {
+			t.Errorf("logged a misleading error: %s", line)
+		}
+		if entry.Msg == "Client disconnected" {
+			disconnected = entry.Level == "DEBUG" && entry.Status == statusClientClosedRequest
+		}
+	}
+	if !disconnected {
+		t.Errorf("no debug line for the disconnect:\n%s", logs.String())
+	}
+	// A client going away says nothing about upstream health.
+	if got := upstreamRetryBudget.remaining(); got != 10 {
+		t.Errorf("retry budget = %v, want it untouched", got)
+	}
+	if m := serve(h, http.MethodGet, "/metrics", "").Body.String(); strings.Contains(m, `status="5xx"`) {
+		t.Errorf("the disconnect was counted as a server error:\n%s", m)
+	}
+}
+
+func TestMaxAgeFollowsEntryAge(t *testing.T) {
+	setVar(t, &cacheTTLJitter, 0)
+	setVar(t, &staleTTL, time.Minute)
+	setVar(t, &leaderboardTTL, 15*time.Minute)
+	fake := &fakeMMRClient{
+		mmr:         returns(rankData(15, "Platinum 1", 45, "Diamond 2")),
+		leaderboard: leaderboardFrom(leaderboardJSON(3)),
+	}
+	s := newFakeServer(t, fake)
+	clock := newFakeClock()
+	s.now = clock.now
+	h := s.Handler()
+
+	tests := []struct {
+		target  string
+		advance time.Duration
+		want    string
+	}{
+		{"/rest/v1/rank/eu/foo/bar", 0, "max-age=300"},
+		{"/rest/v1/leaderboard/eu", 0, "max-age=900"},
+		{"/rest/v1/rank/eu/foo/bar", time.Minute, "max-age=240"},
+		{"/rest/v1/rank/eu/foo/bar", 100 * time.Second, "max-age=140"},
+		{"/rest/v1/leaderboard/eu", 0, "max-age=740"},
+		// Stale answers are already past their lifetime.
+		{"/rest/v1/rank/eu/foo/bar", 150 * time.Second, "max-age=0"},
+	}
+	for _, tt := range tests {
+		clock.advance(tt.advance)
+		w := serve(h, http.MethodGet, tt.target, "")
+		s.background.wg.Wait()
+		if got := w.Header().Get("Cache-Control"); got != tt.want {
+			t.Errorf("GET %s after %v: Cache-Control = %q, want %q", tt.target, tt.advance, got, tt.want)
+		}
+	}
+}
+
+func TestRejectEncodedSlashes(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+	}{
+		{"slash in name", "/rest/v1/rank/eu/foo%2Fbar/baz", http.StatusBadRequest},
+		{"lower case escape in tag", "/rest/v1/rank/eu/foo/ba%2fr", http.StatusBadRequest},
+		{"backslash", "/rest/v1/rank/eu/foo%5Cbar/baz", http.StatusBadRequest},
+		{"slash in a path batch", "/rest/v1/rank/eu/a%23b,c%2Fd%23e", http.StatusBadRequest},
+		{"slash in the query", "/rest/v1/rank/eu/foo/bar?ref=a%2Fb", http.StatusOK},
+		{"double encoded", "/rest/v1/rank/eu/foo%252Fbar/baz", http.StatusOK},
+		{"plain", "/rest/v1/rank/eu/foo/bar", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))}
+			w := serve(newFakeServer(t, fake).Handler(), http.MethodGet, tt.target, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus == http.StatusOK {
+				return
+			}
+			if body := decodeBody(t, w.Body.Bytes()); body["code"] != codeInvalidPath {
+				t.Errorf("code = %v, want %s", body["code"], codeInvalidPath)
+			}
+			if n := fake.calls.Load(); n != 0 {
+				t.Errorf("upstream calls = %d, want none", n)
+			}
+		})
+	}
+}
+
+func TestAPIVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      []string
+		target      string
+		wantStatus  int
+		wantVersion string
+	}{
+		{"unset defaults to the latest", nil, "/rest/v1/rank/eu/foo/bar", http.StatusOK, latestAPIVersion},
+		{"supported", []string{"X-API-Version", "1"}, "/rest/v1/rank/eu/foo/bar", http.StatusOK, "1"},
+		{"padded", []string{"X-API-Version", " 1 "}, "/rest/v1/rank/eu/foo/bar", http.StatusOK, "1"},
+		{"unsupported", []string{"X-API-Version", "2"}, "/rest/v1/rank/eu/foo/bar", http.StatusBadRequest, ""},
+		{"not a version", []string{"X-API-Version", "latest"}, "/rest/v1/regions", http.StatusBadRequest, ""},
+		{"outside v1", []string{"X-API-Version", "2"}, "/metrics", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))}
+			w := serve(newFakeServer(t, fake).Handler(), http.MethodGet, tt.target, "", tt.header...)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if got := w.Header().Get("X-API-Version"); got != tt.wantVersion {
+				t.Errorf("X-API-Version = %q, want %q", got, tt.wantVersion)
+			}
+			if tt.wantStatus == http.StatusBadRequest {
+				if body := decodeBody(t, w.Body.Bytes()); body["code"] != codeUnsupportedVersion {
+					t.Errorf("code = %v, want %s", body["code"], codeUnsupportedVersion)
+				}
+				if n := fake.calls.Load(); n != 0 {
+					t.Errorf("upstream calls = %d, want none", n)
+				}
+			}
+			if tt.wantVersion != "" && !strings.Contains(strings.Join(w.Header().Values("Vary"), ","), "X-API-Version") {
+				t.Errorf("Vary = %q, want X-API-Version", w.Header().Values("Vary"))
+			}
+		})
+	}
+}
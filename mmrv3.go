@@ -0,0 +1,72 @@
+package main
+
+import "fmt"
+
+// mmrPaths are the default UPSTREAM_MMR_PATH of each henrikdev MMR endpoint
+// version. v3 also takes a platform. v3 payloads are converted to the v2
+// shape as they arrive, so the cache and everything reading MMR data only
+// ever see v2.
+var mmrPaths = map[string]string{
+	"v2": "/valorant/v2/mmr/{region}/{name}/{tag}",
+	"v3": "/valorant/v3/mmr/{region}/pc/{name}/{tag}",
+}
+
+// validateMMRVersion rejects unknown UPSTREAM_MMR_VERSION values.
+func validateMMRVersion(version string) error {
+	if _, ok := mmrPaths[version]; !ok {
+		return fmt.Errorf("invalid UPSTREAM_MMR_VERSION %q, expected v2 or v3", version)
+	}
+	return nil
+}
+
+// mmrFromV3 rewrites a v3 MMR data payload in the v2 shape: current becomes
+// current_data, peak becomes highest_rank and the act wins of seasonal are
+// keyed by season under by_season. A payload without current gets no
+// current_data, just like a v2 payload without a rank.
+func mmrFromV3(data map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	if account, ok := data["account"].(map[string]interface{}); ok {
+		out["name"], out["tag"] = account["name"], account["tag"]
+	}
+
+	if current, ok := data["current"].(map[string]interface{}); ok {
+		tier, _ := current["tier"].(map[string]interface{})
+		out["current_data"] = map[string]interface{}{
+			"currenttier":             tier["id"],
+			"currenttierpatched":      tier["name"],
+			"ranking_in_tier":         current["rr"],
+			"mmr_change_to_last_game": current["last_change"],
+			"elo":                     current["elo"],
+		}
+	}
+
+	if peak, ok := data["peak"].(map[string]interface{}); ok {
+		tier, _ := peak["tier"].(map[string]interface{})
+		out["highest_rank"] = map[string]interface{}{
+			"patched_tier": tier["name"],
+			"tier":         tier["id"],
+		}
+	}
+
+	seasonal, _ := data["seasonal"].([]interface{})
+	bySeason := map[string]interface{}{}
+	for _, s := range seasonal {
+		season, _ := s.(map[string]interface{})
+		info, _ := season["season"].(map[string]interface{})
+		short, ok := info["short"].(string)
+		if !ok {
+			continue
+		}
+		actWins, _ := season["act_wins"].([]interface{})
+		wins := make([]interface{}, 0, len(actWins))
+		for _, w := range actWins {
+			win, _ := w.(map[string]interface{})
+			wins = append(wins, map[string]interface{}{"patched_tier": win["name"], "tier": win["id"]})
+		}
+		bySeason[short] = map[string]interface{}{"act_rank_wins": wins}
+	}
+	if len(bySeason) > 0 {
+		out["by_season"] = bySeason
+	}
+	return out
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestParseRankVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		body    string
+		want    rankInfo
+	}{
+		{"v2", "v2", mmrBody, rankInfo{Rank: "Platinum 1", Tier: 15, RR: 45, HighestRank: "Diamond 2"}},
+		{"v3", "v3", mmrV3Body, rankInfo{Rank: "Platinum 1", Tier: 15, RR: 45, HighestRank: "Diamond 2"}},
+		{"v3 radiant", "v3", `{"data":{"current":{"tier":{"id":27,"name":"Radiant"},"rr":512.5},"peak":{"tier":{"id":27,"name":"Radiant"}}}}`, rankInfo{Rank: "Radiant", Tier: 27, RR: 512.5, HighestRank: "Radiant"}},
+		{"v3 without a peak", "v3", `{"data":{"current":{"tier":{"id":3,"name":"Iron 1"},"rr":0}}}`, rankInfo{Rank: "Iron 1", Tier: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp struct{ Data map[string]interface{} }
+			if err := json.Unmarshal([]byte(tt.body), &resp); err != nil {
+				t.Fatal(err)
+			}
+			data := resp.Data
+			if tt.version == "v3" {
+				data = mmrFromV3(data)
+			}
+			got, lerr := parseRank(data)
+			if lerr != nil {
+				t.Fatalf("parseRank: %v", lerr)
+			}
+			if got != tt.want {
+				t.Errorf("parseRank() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMMRVersionResponses(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  string
+		body     string
+		query    string
+		wantPath string
+		// wantMessage is the rank message, or wantCode the error.
+		wantMessage string
+		wantCode    string
+		// wantAct is the act of act_wins, when asked for.
+		wantAct string
+	}{
+		{"v2", "v2", mmrBody, "", "/valorant/v2/mmr/eu/foo/bar", "Platinum 1 [45RR] | Peak: Diamond 2", "", ""},
+		{"v3", "v3", mmrV3Body, "", "/valorant/v3/mmr/eu/pc/foo/bar", "Platinum 1 [45RR] | Peak: Diamond 2", "", ""},
+		{"v3 act wins", "v3", mmrV3Body, "?actwins=true", "/valorant/v3/mmr/eu/pc/foo/bar", "Platinum 1 [45RR] | Peak: Diamond 2", "", "e9a1"},
+		{"v3 unrated", "v3", `{"status":200,"data":{"account":{"name":"n","tag":"t"}}}`, "", "/valorant/v3/mmr/eu/pc/foo/bar", "", codeUpstreamEmpty, ""},
+		{"v3 payload read as v2", "v2", mmrV3Body, "", "/valorant/v2/mmr/eu/foo/bar", "", codeUpstreamEmpty, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path string
+			cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				writeJSON(w, http.StatusOK, tt.body)
+			})
+			cfg.MMRVersion, cfg.MMRPath = tt.version, newMMRTemplate(tt.version)
+			w := serve(newHTTPServer(t, cfg).Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar"+tt.query, "")
+			if path != tt.wantPath {
+				t.Errorf("upstream path = %q, want %q", path, tt.wantPath)
+			}
+			body := decodeBody(t, w.Body.Bytes())
+			if tt.wantCode != "" {
+				if w.Code != http.StatusBadGateway || body["code"] != tt.wantCode {
+					t.Errorf("status = %d %v, want 502 %s", w.Code, body["code"], tt.wantCode)
+				}
+				return
+			}
+			if w.Code != http.StatusOK || body["message"] != tt.wantMessage {
+				t.Errorf("status = %d, message %v, want 200 %q", w.Code, body["message"], tt.wantMessage)
+			}
+			if tt.wantAct != "" {
+				wins, _ := body["act_wins"].(map[string]interface{})
+				if wins["act"] != tt.wantAct {
+					t.Errorf("act_wins = %v, want act %s", body["act_wins"], tt.wantAct)
+				}
+			}
+		})
+	}
+}
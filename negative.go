@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// negativeTTL is how long a player upstream reported as not found is
+// answered with 404 without asking again. It is independent of cacheTTL and
+// kept short, so a player who appears after a typo was looked up, or who
+// just created their account, is found again soon. Zero disables negative
+// caching.
+var negativeTTL = envDuration("NEGATIVE_CACHE_TTL", 30*time.Second)
+
+// negativeCache remembers cache keys upstream answered with 404.
+type negativeCache struct {
+	mu    sync.RWMutex
+	until map[string]time.Time
+	now   func() time.Time
+}
+
+func newNegativeCache(now func() time.Time) *negativeCache {
+	return &negativeCache{until: make(map[string]time.Time), now: now}
+}
+
+func (n *negativeCache) add(key string) {
+	if negativeTTL <= 0 {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.until[key] = n.now().Add(negativeTTL)
+}
+
+// remove forgets key, once upstream has answered for it after all.
+func (n *negativeCache) remove(key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.until, key)
+}
+
+func (n *negativeCache) has(key string) bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	until, ok := n.until[key]
+	return ok && n.now().Before(until)
+}
+
+// sweep drops expired keys.
+func (n *negativeCache) sweep() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := n.now()
+	for key, until := range n.until {
+		if !now.Before(until) {
+			delete(n.until, key)
+		}
+	}
+}
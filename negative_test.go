@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNegativeCacheExpiry(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		// ages are when, after the first 404, the player is looked up again
+		// with their account now existing; want is the status each gets.
+		ages []time.Duration
+		want []int
+	}{
+		{"default", 30 * time.Second, []time.Duration{10 * time.Second, 29 * time.Second, 30 * time.Second}, []int{http.StatusNotFound, http.StatusNotFound, http.StatusOK}},
+		{"longer than positive entries", 10 * time.Minute, []time.Duration{6 * time.Minute, 10 * time.Minute}, []int{http.StatusNotFound, http.StatusOK}},
+		{"disabled", 0, []time.Duration{0}, []int{http.StatusOK}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &negativeTTL, tt.ttl)
+			var exists atomic.Bool
+			fake := &fakeMMRClient{mmr: func(region, name, tag string) (map[string]interface{}, *apiError) {
+				if !exists.Load() {
+					return fails(http.StatusNotFound)(region, name, tag)
+				}
+				return rankData(15, "Platinum 1", 45, "Diamond 2"), nil
+			}}
+			s := newFakeServer(t, fake)
+			clock := newFakeClock()
+			s.now = clock.now
+			h := s.Handler()
+
+			if w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", ""); w.Code != http.StatusNotFound {
+				t.Fatalf("first lookup = %d, want 404: %s", w.Code, w.Body)
+			}
+			exists.Store(true)
+
+			start := clock.now()
+			for i, age := range tt.ages {
+				clock.advance(start.Add(age).Sub(clock.now()))
+				if w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", ""); w.Code != tt.want[i] {
+					t.Errorf("after %v: status = %d, want %d: %s", age, w.Code, tt.want[i], w.Body)
+				}
+			}
+			// Only the first lookup and the one that found the player
+			// reached upstream.
+			if n := fake.calls.Load(); n != 2 {
+				t.Errorf("upstream calls = %d, want 2", n)
+			}
+		})
+	}
+}
+
+func TestNegativeCacheSweep(t *testing.T) {
+	setVar(t, &negativeTTL, 30*time.Second)
+	clock := newFakeClock()
+	n := newNegativeCache(clock.now)
+	n.add("old")
+	clock.advance(20 * time.Second)
+	n.add("new")
+	clock.advance(10 * time.Second)
+
+	n.sweep()
+	if n.has("old") || len(n.until) != 1 {
+		t.Errorf("after sweep: %v, want only the unexpired key", n.until)
+	}
+	if !n.has("new") {
+		t.Error("the unexpired key was swept")
+	}
+	n.remove("new")
+	if n.has("new") {
+		t.Error("a removed key is still remembered")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseQueryAllowlist reads UPSTREAM_QUERY_ALLOWLIST, a comma separated list
+// of query parameter names.
+func parseQueryAllowlist(list string) map[string]bool {
+	allowed := make(map[string]bool)
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			allowed[p] = true
+		}
+	}
+	return allowed
+}
+
+// validateQueryAllowlist refuses to pass the api key parameter through, which
+// would let clients override the server's key.
+func validateQueryAllowlist(allowed map[string]bool) error {
+	if allowed["api_key"] {
+		return errors.New("UPSTREAM_QUERY_ALLOWLIST cannot include api_key")
+	}
+	return nil
+}
+
+type upstreamQueryKey struct{}
+
+// upstreamQuery carries the query parameters of a request that are in allowed
+// in its context, for fetchFrom to add to upstream URLs.
+func upstreamQuery(allowed map[string]bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := url.Values{}
+		for name, values := range c.Request.URL.Query() {
+			if allowed[name] {
+				query[name] = values
+			}
+		}
+		if len(query) > 0 {
+			c.Request = c.Request.WithContext(withUpstreamQuery(c.Request.Context(), query))
+		}
+		c.Next()
+	}
+}
+
+func withUpstreamQuery(ctx context.Context, query url.Values) context.Context {
+	return context.WithValue(ctx, upstreamQueryKey{}, query)
+}
+
+// upstreamQueryFrom returns the passthrough parameters carried by ctx, or nil.
+func upstreamQueryFrom(ctx context.Context) url.Values {
+	query, _ := ctx.Value(upstreamQueryKey{}).(url.Values)
+	return query
+}
+
+// upstreamQueryCacheKey scopes key to the passthrough parameters carried by
+// ctx, since they change what upstream answers. Encode sorts by name, so the
+// order they were given in does not matter.
+func upstreamQueryCacheKey(ctx context.Context, key string) string {
+	if query := upstreamQueryFrom(ctx); query != nil {
+		return key + "|" + query.Encode()
+	}
+	return key
+}
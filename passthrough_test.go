@@ -0,0 +1,88 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestUpstreamQueryPassthrough(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowlist string
+		query     string
+		want      url.Values
+	}{
+		{"allowlisted", "platform", "?platform=console", url.Values{"api_key": {"test-key"}, "platform": {"console"}}},
+		{"others dropped", "platform, mode", "?platform=pc&evil=1&format=text", url.Values{"api_key": {"test-key"}, "platform": {"pc"}}},
+		{"several allowlisted", "platform,mode", "?mode=competitive&platform=pc", url.Values{"api_key": {"test-key"}, "mode": {"competitive"}, "platform": {"pc"}}},
+		{"client api key ignored", "platform", "?api_key=stolen", url.Values{"api_key": {"test-key"}}},
+		{"no allowlist", "", "?platform=console", url.Values{"api_key": {"test-key"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got url.Values
+			cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				got = r.URL.Query()
+				writeJSON(w, http.StatusOK, mmrBody)
+			})
+			cfg.UpstreamQueryAllowlist = parseQueryAllowlist(tt.allowlist)
+			w := serve(newHTTPServer(t, cfg).Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar"+tt.query, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			if !maps.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("upstream query = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpstreamQueryScopesCache(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.URL.Query().Get("platform"))
+		mu.Unlock()
+		writeJSON(w, http.StatusOK, mmrBody)
+	})
+	cfg.UpstreamQueryAllowlist = parseQueryAllowlist("platform,mode")
+	h := newHTTPServer(t, cfg).Handler()
+
+	tests := []struct {
+		query     string
+		wantCache string
+	}{
+		{"?platform=pc", cacheMiss},
+		{"?platform=console", cacheMiss},
+		{"", cacheMiss},
+		{"?platform=pc&evil=1", cacheHit},
+		{"?mode=x&platform=pc", cacheMiss},
+		{"?platform=pc&mode=x", cacheHit},
+	}
+	for _, tt := range tests {
+		w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar"+tt.query, "")
+		if got := w.Header().Get("X-Cache"); got != tt.wantCache {
+			t.Errorf("%q: X-Cache = %q, want %q", tt.query, got, tt.wantCache)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"pc", "console", "", "pc"}; !slices.Equal(calls, want) {
+		t.Errorf("upstream platforms = %q, want %q", calls, want)
+	}
+}
+
+func TestValidateQueryAllowlist(t *testing.T) {
+	for list, wantErr := range map[string]bool{"": false, "platform": false, "platform, api_key": true} {
+		if err := validateQueryAllowlist(parseQueryAllowlist(list)); (err != nil) != wantErr {
+			t.Errorf("validateQueryAllowlist() with %q = %v, want an error: %v", list, err, wantErr)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// pathTemplate is an upstream path with {region}, {name} and {tag}
+// placeholders, configurable so a henrikdev API version bump doesn't need a
+// code change.
+type pathTemplate struct {
+	env      string
+	tmpl     string
+	required []string
+}
+
+func newPathTemplate(env, def string, required ...string) pathTemplate {
+	return pathTemplate{env: env, tmpl: cmp.Or(os.Getenv(env), def), required: required}
+}
+
+var (
+	historyTemplate     = newPathTemplate("UPSTREAM_HISTORY_PATH", "/valorant/v1/mmr-history/{region}/{name}/{tag}", "name", "tag")
+	accountTemplate     = newPathTemplate("UPSTREAM_ACCOUNT_PATH", "/valorant/v1/account/{name}/{tag}", "name", "tag")
+	leaderboardTemplate = newPathTemplate("UPSTREAM_LEADERBOARD_PATH", "/valorant/v1/leaderboard/{region}", "region")
+
+	pathTemplates = []pathTemplate{historyTemplate, accountTemplate, leaderboardTemplate}
+)
+
+var placeholderPattern = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// validate checks that the template is an absolute path using only known
+// placeholders and including every required one.
+func (t pathTemplate) validate() error {
+	if !strings.HasPrefix(t.tmpl, "/") {
+		return fmt.Errorf("%s must start with /: %q", t.env, t.tmpl)
+	}
+
+	var seen []string
+	for _, m := range placeholderPattern.FindAllStringSubmatch(t.tmpl, -1) {
+		switch m[1] {
+		case "region", "name", "tag":
+			seen = append(seen, m[1])
+		default:
+			return fmt.Errorf("%s has unknown placeholder {%s}", t.env, m[1])
+		}
+	}
+	if strings.ContainsAny(placeholderPattern.ReplaceAllString(t.tmpl, ""), "{}?#") {
+		return fmt.Errorf("%s has stray braces or a query: %q", t.env, t.tmpl)
+	}
+	for _, r := range t.required {
+		if !slices.Contains(seen, r) {
+			return fmt.Errorf("%s is missing placeholder {%s}", t.env, r)
+		}
+	}
+	return nil
+}
+
+// render substitutes path escaped values into the template. Values must
+// already be decoded; they are escaped exactly once here.
+func (t pathTemplate) render(region, name, tag string) string {
+	return strings.NewReplacer(
+		"{region}", url.PathEscape(region),
+		"{name}", url.PathEscape(name),
+		"{tag}", url.PathEscape(tag),
+	).Replace(t.tmpl)
+}
+
+// validatePathTemplates checks every configured template at startup.
+func validatePathTemplates() error {
+	var errs []error
+	for _, t := range pathTemplates {
+		if err := t.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// newMMRTemplate is the MMR path template for an UPSTREAM_MMR_VERSION:
+// UPSTREAM_MMR_PATH when set, the version's default path otherwise.
+func newMMRTemplate(version string) pathTemplate {
+	return newPathTemplate("UPSTREAM_MMR_PATH", cmp.Or(mmrPaths[version], mmrPaths["v2"]), "name", "tag")
+}
+
+// mmrPath is the upstream path of a player's MMR details under cfg.
+func mmrPath(cfg Config, region, name, tag string) string {
+	return cfg.MMRPath.render(region, name, tag)
+}
+
+// historyPath is the upstream path of a player's recent MMR changes.
+func historyPath(region, name, tag string) string {
+	return historyTemplate.render(region, name, tag)
+}
+
+// accountPath is the upstream path of a player's account details.
+func accountPath(name, tag string) string {
+	return accountTemplate.render("", name, tag)
+}
+
+// leaderboardPath is the upstream path of a region's leaderboard.
+func leaderboardPath(region string) string {
+	return leaderboardTemplate.render(region, "", "")
+}
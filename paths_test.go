@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func FuzzValidateAndBuildURL(f *testing.F) {
+	const base = "https://api.henrikdev.xyz"
+	f.Fuzz(func(t *testing.T, rawRegion, rawName, rawTag string) {
+		region, ok := canonicalRegion(rawRegion, defaultRegions)
+		if !ok {
+			return
+		}
+		name, tag, lerr := cleanPlayer(rawName, rawTag)
+		if lerr != nil {
+			return
+		}
+
+		for version := range mmrPaths {
+			cfg := Config{MMRPath: newMMRTemplate(version)}
+			raw := upstreamURL(base, mmrPath(cfg, region, name, tag), "k&y")
+			u, err := url.Parse(raw)
+			if err != nil {
+				t.Fatalf("upstreamURL(%q, %q, %q) = %q, which does not parse: %v", region, name, tag, raw, err)
+			}
+			if got := u.Scheme + "://" + u.Host; got != base {
+				t.Errorf("URL %q is on %q, want %q", raw, got, base)
+			}
+			if u.Fragment != "" || u.Query().Get("api_key") != "k&y" || len(u.Query()) != 1 {
+				t.Errorf("URL %q has query %q and fragment %q, want only the api key", raw, u.RawQuery, u.Fragment)
+			}
+			want := strings.NewReplacer("{region}", region, "{name}", name, "{tag}", tag).Replace(cfg.MMRPath.tmpl)
+			if u.Path != want {
+				t.Errorf("URL %q decodes to path %q, want %q", raw, u.Path, want)
+			}
+		}
+	})
+}
+
+func TestPathTemplateValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    string
+		wantErr string
+	}{
+		{"default", "/valorant/v2/mmr/{region}/{name}/{tag}", ""},
+		{"reordered", "/valorant/v3/by-name/{name}/{tag}/{region}", ""},
+		{"relative", "valorant/v2/mmr/{region}/{name}/{tag}", "must start with /"},
+		{"unknown placeholder", "/valorant/v2/mmr/{platform}/{name}/{tag}", "unknown placeholder {platform}"},
+		{"stray brace", "/valorant/v2/mmr/{region/{name}/{tag}", "stray braces"},
+		{"query", "/valorant/v2/mmr/{name}/{tag}?x=1", "stray braces or a query"},
+		{"missing tag", "/valorant/v2/mmr/{region}/{name}", "missing placeholder {tag}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := pathTemplate{env: "UPSTREAM_MMR_PATH", tmpl: tt.tmpl, required: []string{"name", "tag"}}.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), "UPSTREAM_MMR_PATH") {
+				t.Errorf("validate() = %v, want an UPSTREAM_MMR_PATH error about %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMMRPathOverride(t *testing.T) {
+	t.Setenv("UPSTREAM_MMR_PATH", "/valorant/v3/mmr/{region}/pc/{name}/{tag}")
+	var got atomic.Value
+	cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		got.Store(r.URL.EscapedPath())
+		writeJSON(w, http.StatusOK, mmrBody)
+	})
+
+	w := serve(newHTTPServer(t, cfg).Handler(), http.MethodGet, "/rest/v1/rank/eu/Foo%20Bar/%C3%A6", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if path, want := got.Load(), "/valorant/v3/mmr/eu/pc/Foo%20Bar/%C3%A6"; path != want {
+		t.Errorf("upstream path = %v, want %s", path, want)
+	}
+
+	t.Setenv("UPSTREAM_MMR_PATH", "/valorant/v3/mmr/{region}/{name}")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "UPSTREAM_MMR_PATH") {
+		t.Errorf("loadConfig() with a template missing {tag} = %v, want an UPSTREAM_MMR_PATH error", err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxUpstreamPause caps how long a single Retry-After can stop upstream calls.
+var maxUpstreamPause = envDuration("UPSTREAM_MAX_PAUSE", 5*time.Minute)
+
+// upstreamPause is the shared "not before" time set when henrikdev answers
+// 429 with Retry-After. Until it passes no upstream call is made at all, so
+// the whole process respects the quota window rather than each request
+// backing off on its own.
+var upstreamPause pauseGate
+
+type pauseGate struct {
+	mu        sync.Mutex
+	notBefore time.Time
+}
+
+// remaining returns how long upstream calls are still paused for.
+func (g *pauseGate) remaining() time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return max(time.Until(g.notBefore), 0)
+}
+
+// pauseFor stops upstream calls for d, capped at maxUpstreamPause. An
+// existing longer pause is kept.
+func (g *pauseGate) pauseFor(d time.Duration) {
+	until := time.Now().Add(min(d, maxUpstreamPause))
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if until.After(g.notBefore) {
+		g.notBefore = until
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given either as seconds or as
+// an HTTP date.
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// pausedError is returned instead of calling upstream while paused.
+type pausedError struct {
+	remaining time.Duration
+}
+
+func (e *pausedError) Error() string {
+	return fmt.Sprintf("upstream paused for %s after a rate limit", e.remaining)
+}
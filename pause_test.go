@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"absent", "", 0, false},
+		{"seconds", "30", 30 * time.Second, true},
+		{"zero", "0", 0, true},
+		{"negative", "-5", 0, false},
+		{"garbage", "soon", 0, false},
+		{"past date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseRetryAfter(tt.value)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+
+	future := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got, ok := parseRetryAfter(future); !ok || got < 58*time.Second || got > time.Minute {
+		t.Errorf("parseRetryAfter(%q) = %v, %v, want about a minute", future, got, ok)
+	}
+}
+
+func TestPauseGate(t *testing.T) {
+	setVar(t, &maxUpstreamPause, time.Minute)
+	var g pauseGate
+	if d := g.remaining(); d != 0 {
+		t.Fatalf("remaining() = %v before any pause", d)
+	}
+	g.pauseFor(30 * time.Second)
+	g.pauseFor(time.Second)
+	if d := g.remaining(); d < 29*time.Second || d > 30*time.Second {
+		t.Errorf("remaining() = %v, want the longer 30s pause kept", d)
+	}
+	g.pauseFor(time.Hour)
+	if d := g.remaining(); d < 59*time.Second || d > time.Minute {
+		t.Errorf("remaining() = %v, want the pause capped at a minute", d)
+	}
+}
+
+func TestRetryAfterSuppressesUpstream(t *testing.T) {
+	var calls atomic.Int64
+	cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if strings.HasSuffix(r.URL.Path, "/eu/limited/t") {
+			w.Header().Set("Retry-After", "30")
+			writeJSON(w, http.StatusTooManyRequests, `{"status":429}`)
+			return
+		}
+		writeJSON(w, http.StatusOK, mmrBody)
+	})
+	h := newHTTPServer(t, cfg).Handler()
+
+	tests := []struct {
+		name       string
+		player     string
+		expire     bool
+		wantStatus int
+		wantCode   string
+		// wantCalls is the total of upstream calls made so far.
+		wantCalls int64
+	}{
+		{"cached before the pause", "foo", false, http.StatusOK, "", 1},
+		{"rate limited", "limited", false, http.StatusTooManyRequests, codeUpstreamRateLimited, 2},
+		{"paused", "other", false, http.StatusServiceUnavailable, codeUpstreamPaused, 2},
+		{"still paused", "another", false, http.StatusServiceUnavailable, codeUpstreamPaused, 2},
+		{"cache served while paused", "foo", false, http.StatusOK, "", 2},
+		{"resumed after the window", "other", true, http.StatusOK, "", 3},
+	}
+	for _, tt := range tests {
+		if tt.expire {
+			upstreamPause.mu.Lock()
+			upstreamPause.notBefore = time.Now()
+			upstreamPause.mu.Unlock()
+		}
+		w := serve(h, http.MethodGet, "/rest/v1/rank/eu/"+tt.player+"/t", "")
+		if w.Code != tt.wantStatus {
+			t.Fatalf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.wantStatus, w.Body)
+		}
+		if tt.wantCode != "" {
+			if code := decodeBody(t, w.Body.Bytes())["code"]; code != tt.wantCode {
+				t.Errorf("%s: code = %v, want %s", tt.name, code, tt.wantCode)
+			}
+		}
+		if tt.wantCode == codeUpstreamPaused {
+			if secs, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || secs < 1 || secs > 30 {
+				t.Errorf("%s: Retry-After = %q, want the rest of the 30s window", tt.name, w.Header().Get("Retry-After"))
+			}
+		}
+		if n := calls.Load(); n != tt.wantCalls {
+			t.Errorf("%s: upstream calls = %d, want %d", tt.name, n, tt.wantCalls)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// maxQuotaClients caps how many clients a quota tracks, so a flood of unique
+// addresses cannot grow it without bound. At the cap the least recently seen
+// client is forgotten if it has been idle for a window; otherwise new clients
+// share a single overflow allowance.
+var maxQuotaClients = envInt("RATE_LIMIT_MAX_BUCKETS", 10000)
+
+// overflowClient is the key new clients share while a quota is full.
+const overflowClient = "\x00overflow"
+
+// quotaWindow tracks one client's usage in the current and previous fixed
+// windows.
+type quotaWindow struct {
+	start time.Time
+	curr  int
+	prev  int
+	// lastUsed and el place the client in the quota's recency list.
+	lastUsed time.Time
+	el       *list.Element
+}
+
+// quota is a per-client sliding window counter. Usage from the previous
+// window is weighted by how much of it still overlaps the sliding window,
+// which approximates a true sliding log without storing every event.
+type quota struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	clients   map[string]*quotaWindow
+	recency   *list.List // of client keys, most recently seen first
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func newQuota(limit int, window time.Duration, now func() time.Time) *quota {
+	return &quota{
+		limit:   limit,
+		window:  window,
+		clients: make(map[string]*quotaWindow),
+		recency: list.New(),
+		now:     now,
+	}
+}
+
+// allow records n units of usage for key and reports whether it fits in the
+// quota. Rejected usage is not recorded.
+func (q *quota) allow(key string, n int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	q.sweep(now)
+
+	w, ok := q.clients[key]
+	if !ok {
+		if len(q.clients) >= maxQuotaClients && !q.evictIdle(now) {
+			key = overflowClient
+			w, ok = q.clients[key]
+		}
+		if !ok {
+			w = &quotaWindow{start: now, el: q.recency.PushFront(key)}
+			q.clients[key] = w
+		}
+	}
+	w.lastUsed = now
+	q.recency.MoveToFront(w.el)
+
+	if elapsed := now.Sub(w.start); elapsed >= 2*q.window {
+		w.start, w.curr, w.prev = now, 0, 0
+	} else if elapsed >= q.window {
+		w.start, w.curr, w.prev = w.start.Add(q.window), 0, w.curr
+	}
+
+	overlap := 1 - float64(now.Sub(w.start))/float64(q.window)
+	if float64(w.prev)*overlap+float64(w.curr+n) > float64(q.limit) {
+		return false
+	}
+	w.curr += n
+	return true
+}
+
+// active returns how many clients currently have usage tracked.
+func (q *quota) active() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.clients)
+}
+
+// sweep drops clients that have been idle for long enough that they no longer
+// count against their quota. Callers must hold q.mu.
+func (q *quota) sweep(now time.Time) {
+	if now.Sub(q.lastSweep) < q.window {
+		return
+	}
+	q.lastSweep = now
+	for key, w := range q.clients {
+		if now.Sub(w.start) >= 2*q.window {
+			q.forget(key, w)
+		}
+	}
+}
+
+// evictIdle forgets the least recently seen client to make room for a new
+// one, provided it has been idle for a whole window. It reports whether room
+// was made. Callers must hold q.mu.
+func (q *quota) evictIdle(now time.Time) bool {
+	oldest := q.recency.Back()
+	if oldest == nil {
+		return false
+	}
+	key := oldest.Value.(string)
+	w := q.clients[key]
+	if now.Sub(w.lastUsed) < q.window {
+		return false
+	}
+	q.forget(key, w)
+	return true
+}
+
+// forget drops a client. Callers must hold q.mu.
+func (q *quota) forget(key string, w *quotaWindow) {
+	q.recency.Remove(w.el)
+	delete(q.clients, key)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestQuotaBucketCap(t *testing.T) {
+	// step is a request from a client after advance has passed, and whether
+	// it is allowed.
+	type step struct {
+		advance time.Duration
+		client  string
+		want    bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		// wantTracked are the clients holding a bucket afterwards, sorted.
+		wantTracked []string
+	}{
+		{"under the cap", []step{
+			{0, "a", true},
+			{0, "b", true},
+			{0, "a", true},
+			{0, "a", false},
+		}, []string{"a", "b"}},
+		{"new clients share the overflow", []step{
+			{0, "a", true},
+			{0, "b", true},
+			{0, "c", true},
+			{0, "d", true},
+			{0, "e", false},
+			{0, "c", false},
+			// Clients that got a bucket keep their own allowance.
+			{0, "a", true},
+			{0, "b", true},
+		}, []string{overflowClient, "a", "b"}},
+		{"idle clients are evicted", []step{
+			{0, "a", true},
+			{0, "b", true},
+			{time.Minute, "c", true},
+			{0, "c", true},
+			{0, "c", false},
+		}, []string{"b", "c"}},
+		{"busy clients keep limiting", []step{
+			{0, "a", true},
+			{0, "a", true},
+			{0, "b", true},
+			{30 * time.Second, "c", true},
+			{0, "a", false},
+		}, []string{overflowClient, "a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &maxQuotaClients, 2)
+			clock := newFakeClock()
+			q := newQuota(2, time.Minute, clock.now)
+			for i, st := range tt.steps {
+				clock.advance(st.advance)
+				if got := q.allow(st.client, 1); got != st.want {
+					t.Errorf("step %d, %s: allow = %v, want %v", i, st.client, got, st.want)
+				}
+			}
+			if got := slices.Sorted(maps.Keys(q.clients)); !slices.Equal(got, tt.wantTracked) {
+				t.Errorf("tracked clients = %q, want %q", got, tt.wantTracked)
+			}
+			if q.recency.Len() != len(q.clients) {
+				t.Errorf("recency list holds %d clients, map %d", q.recency.Len(), len(q.clients))
+			}
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	rrPerTier   = 100
+	radiantTier = 27
+	// minRankedTier is the lowest tier of an actual rank; below it a player
+	// is unrated.
+	minRankedTier = 3
+)
+
+// legacyLatencyKey keeps sending the old "latency:ms" key alongside
+// "latency_ms" for clients that have not moved over yet.
+var legacyLatencyKey = os.Getenv("LEGACY_LATENCY_KEY") == "true"
+
+// unrankedStatus is the status sent for unranked players: 200 with the usual
+// body, or 204 with none.
+var unrankedStatus = envInt("UNRANKED_STATUS", http.StatusOK)
+
+// validateUnrankedStatus rejects UNRANKED_STATUS values other than 200 and 204.
+func validateUnrankedStatus() error {
+	if unrankedStatus != http.StatusOK && unrankedStatus != http.StatusNoContent {
+		return fmt.Errorf("invalid UNRANKED_STATUS %d, expected 200 or 204", unrankedStatus)
+	}
+	return nil
+}
+
+// rankInfo is the part of an MMR payload that rank responses are built from.
+type rankInfo struct {
+	Rank        string
+	Tier        int
+	RR          float64
+	HighestRank string
+}
+
+// message renders the human readable rank summary.
+func (r rankInfo) message() string {
+	return r.messageAs(rrInt)
+}
+
+// messageAs renders the rank summary with the RR shown in format f.
+func (r rankInfo) messageAs(f rrFormat) string {
+	_, label := f.render(r.RR)
+	return fmt.Sprintf("%s [%s] | Peak: %s", r.Rank, label, r.HighestRank)
+}
+
+// rrFormat is how ?rr_format= renders ranking_in_tier.
+type rrFormat string
+
+const (
+	rrInt     rrFormat = "int"
+	rrFloat   rrFormat = "float"
+	rrPercent rrFormat = "percent"
+)
+
+// parseRRFormat validates ?rr_format=, defaulting to int.
+func parseRRFormat(raw string) (rrFormat, *apiError) {
+	switch f := rrFormat(cmp.Or(raw, string(rrInt))); f {
+	case rrInt, rrFloat, rrPercent:
+		return f, nil
+	}
+	return "", newAPIError(http.StatusBadRequest, codeInvalidRRFormat, "Invalid rr_format, expected int, float or percent")
+}
+
+// render returns rr as f formats it, both as a value for the rr field and as
+// the label used in messages. percent is the share of rrPerTier, the RR a
+// tier is worth, rounded to a whole percent.
+func (f rrFormat) render(rr float64) (interface{}, string) {
+	switch f {
+	case rrFloat:
+		return rr, strconv.FormatFloat(rr, 'f', -1, 64) + "RR"
+	case rrPercent:
+		pct := int(math.Round(rr / rrPerTier * 100))
+		return pct, strconv.Itoa(pct) + "%"
+	}
+	return int(rr), strconv.Itoa(int(rr)) + "RR"
+}
+
+// ranked reports whether the player has a rank at all.
+func (r rankInfo) ranked() bool {
+	return r.Tier >= minRankedTier
+}
+
+// value orders ranks numerically: every tier is worth rrPerTier, plus the RR
+// earned within it. Unranked players are worth 0.
+func (r rankInfo) value() int {
+	if !r.ranked() {
+		return 0
+	}
+	return r.Tier*rrPerTier + int(r.RR)
+}
+
+// rrToNext returns the RR still needed to reach the next tier. It returns nil
+// for Radiant, which has no next tier, and for unranked players.
+func rrToNext(tier int, rr float64) *int {
+	if tier < minRankedTier || tier >= radiantTier {
+		return nil
+	}
+	remaining := max(rrPerTier-int(rr), 0)
+	return &remaining
+}
+
+// numberValue reads a JSON number however it was decoded: float64 from
+// encoding/json, json.Number from a decoder using UseNumber, or a numeric
+// string from a sloppy upstream.
+func numberValue(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// parseRank extracts rank details from an MMR data payload that is known to
+// contain current_data.
+func parseRank(data map[string]interface{}) (rankInfo, *apiError) {
+	currentData, _ := data["current_data"].(map[string]interface{})
+
+	rank, ok := currentData["currenttierpatched"].(string)
+	if !ok {
+		return rankInfo{}, newAPIError(http.StatusInternalServerError, codeInvalidRankData, "Invalid rank data type")
+	}
+	// A bad RR is not worth failing the whole response over.
+	rr, ok := numberValue(currentData["ranking_in_tier"])
+	if !ok {
+		slog.Warn("Unreadable RR in upstream data, using 0", slog.Any("ranking_in_tier", currentData["ranking_in_tier"]))
+	}
+	tier, _ := numberValue(currentData["currenttier"])
+
+	var highestRank string
+	if highestRankObj, ok := data["highest_rank"].(map[string]interface{}); ok {
+		if patchedTier, ok := highestRankObj["patched_tier"].(string); ok {
+			highestRank = patchedTier
+		}
+	}
+
+	return rankInfo{
+		Rank:        rank,
+		Tier:        int(tier),
+		RR:          rr,
+		HighestRank: highestRank,
+	}, nil
+}
+
+// accountLevel extracts the account level from an account data payload, or
+// nil when it is unavailable.
+func accountLevel(account map[string]interface{}) *int {
+	level, ok := numberValue(account["account_level"])
+	if !ok {
+		return nil
+	}
+	l := int(level)
+	return &l
+}
+
+// accountCard extracts the wide player card, the banner shown in game, from an
+// account data payload, or nil when it is unavailable.
+func accountCard(account map[string]interface{}) *string {
+	card, _ := account["card"].(map[string]interface{})
+	wide, ok := card["wide"].(string)
+	if !ok || wide == "" {
+		return nil
+	}
+	return &wide
+}
+
+// actWin is one ranked win of an act, the tier it was won at.
+type actWin struct {
+	Tier int    `json:"tier"`
+	Rank string `json:"rank"`
+}
+
+// actWins is the rank triangle of the latest act a player has played.
+type actWins struct {
+	Act  string   `json:"act"`
+	Wins []actWin `json:"wins"`
+}
+
+// actKey matches the by_season keys of an MMR payload, such as "e9a3".
+var actKey = regexp.MustCompile(`^e(\d+)a(\d+)$`)
+
+// latestActWins extracts the act rank wins of the most recent act with any
+// from an MMR data payload's by_season, or nil when there are none. Acts are
+// ordered by episode and act number, since by_season is an unordered object.
+func latestActWins(data map[string]interface{}) *actWins {
+	seasons, _ := data["by_season"].(map[string]interface{})
+
+	var (
+		latest                   *actWins
+		latestEpisode, latestAct int
+	)
+	for act, v := range seasons {
+		m := actKey.FindStringSubmatch(act)
+		season, ok := v.(map[string]interface{})
+		if m == nil || !ok {
+			continue
+		}
+		wins, _ := season["act_rank_wins"].([]interface{})
+		if len(wins) == 0 {
+			continue
+		}
+		episode, _ := strconv.Atoi(m[1])
+		number, _ := strconv.Atoi(m[2])
+		if latest != nil && (episode < latestEpisode || episode == latestEpisode && number <= latestAct) {
+			continue
+		}
+
+		result := &actWins{Act: act, Wins: []actWin{}}
+		for _, w := range wins {
+			win, _ := w.(map[string]interface{})
+			tier, _ := numberValue(win["tier"])
+			rank, _ := win["patched_tier"].(string)
+			result.Wins = append(result.Wins, actWin{Tier: int(tier), Rank: rank})
+		}
+		latest, latestEpisode, latestAct = result, episode, number
+	}
+	return latest
+}
+
+// wantsProtobuf reports whether the client asked for a RankResponse, with
+// ?format=protobuf or by preferring application/x-protobuf in Accept.
+func wantsProtobuf(c *gin.Context) bool {
+	if c.Query("format") == "protobuf" {
+		return true
+	}
+	return c.GetHeader("Accept") != "" && c.NegotiateFormat(binding.MIMEJSON, binding.MIMEPROTOBUF) == binding.MIMEPROTOBUF
+}
+
+// respondRank writes the rank response built from an MMR data payload. extra
+// fields are merged into JSON responses. Unranked players get an empty 204
+// when UNRANKED_STATUS asks for it.
+func (s *Server) respondRank(c *gin.Context, result lookupResult, start time.Time, extra gin.H) {
+	c.Writer.Header().Add("Vary", "Accept")
+	setCacheHeader(c, result.source)
+	setMaxAge(c, result.expiresAt.Sub(s.now()))
+
+	info, lerr := parseRank(result.data)
+	if lerr != nil {
+		respondError(c, lerr)
+		return
+	}
+
+	if !info.ranked() && unrankedStatus == http.StatusNoContent {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	rrFmt, _ := parseRRFormat(c.Query("rr_format"))
+	message := info.messageAs(rrFmt)
+	progress := c.Query("progress") == "true"
+	toNext := rrToNext(info.Tier, info.RR)
+
+	latency := time.Since(start)
+
+	// The compact format is "<tier>,<rr>" in plain text and nothing else,
+	// for clients that count bytes. Unranked players are "0,0".
+	if c.Query("format") == "compact" {
+		tier, rr := 0, 0
+		if info.ranked() {
+			tier, rr = info.Tier, int(info.RR)
+		}
+		c.String(http.StatusOK, "%d,%d", tier, rr)
+		return
+	}
+
+	if c.Query("format") == "discord" {
+		_, rr := rrFmt.render(info.RR)
+		updatedAt, _ := extra["updated_at"].(string)
+		c.JSON(http.StatusOK, discordEmbed(strings.TrimSpace(c.Param("name")), strings.TrimSpace(c.Param("tag")), info, rr, updatedAt))
+		return
+	}
+
+	if wantsProtobuf(c) {
+		resp := &RankResponse{
+			Message:     message,
+			Cached:      result.cached(),
+			Rank:        info.Rank,
+			Tier:        int32(info.Tier),
+			Rr:          int32(info.RR),
+			HighestRank: info.HighestRank,
+		}
+		if c.Query("latency") != "false" {
+			resp.LatencyMs = latency.Milliseconds()
+		}
+		if progress && toNext != nil {
+			resp.RrToNext = proto.Int32(int32(*toNext))
+		}
+		resp.UpdatedAt, _ = extra["updated_at"].(string)
+		c.ProtoBuf(http.StatusOK, resp)
+		return
+	}
+
+	if c.Query("format") == "text" {
+		if progress && toNext != nil {
+			message += fmt.Sprintf(" | %dRR to rank up", *toNext)
+		}
+		c.String(http.StatusOK, message)
+		return
+	}
+
+	resp := gin.H{
+		"message": message,
+		"cached":  result.cached(),
+	}
+	if c.Query("latency") != "false" {
+		resp["latency_ms"] = latency.Milliseconds()
+		if legacyLatencyKey {
+			resp["latency:ms"] = latency.Milliseconds()
+		}
+	}
+	if c.Query("rr_format") != "" {
+		resp["rr"], _ = rrFmt.render(info.RR)
+	}
+	if progress {
+		resp["rr_to_next"] = toNext
+	}
+	for k, v := range extra {
+		resp[k] = v
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,226 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.2
+// 	protoc        (unknown)
+// source: rank.proto
+
+package main
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// RankResponse is the protobuf form of a rank response, served for
+// ?format=protobuf or Accept: application/x-protobuf.
+type RankResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Message string `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	Cached  bool   `protobuf:"varint,2,opt,name=cached,proto3" json:"cached,omitempty"`
+	// latency_ms is 0 when the client asked for ?latency=false.
+	LatencyMs   int64  `protobuf:"varint,3,opt,name=latency_ms,json=latencyMs,proto3" json:"latency_ms,omitempty"`
+	Rank        string `protobuf:"bytes,4,opt,name=rank,proto3" json:"rank,omitempty"`
+	Tier        int32  `protobuf:"varint,5,opt,name=tier,proto3" json:"tier,omitempty"`
+	Rr          int32  `protobuf:"varint,6,opt,name=rr,proto3" json:"rr,omitempty"`
+	HighestRank string `protobuf:"bytes,7,opt,name=highest_rank,json=highestRank,proto3" json:"highest_rank,omitempty"`
+	// rr_to_next is only sent with ?progress=true, and never for Radiant or
+	// unranked players.
+	RrToNext  *int32 `protobuf:"varint,8,opt,name=rr_to_next,json=rrToNext,proto3,oneof" json:"rr_to_next,omitempty"`
+	UpdatedAt string `protobuf:"bytes,9,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+}
+
+func (x *RankResponse) Reset() {
+	*x = RankResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rank_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RankResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RankResponse) ProtoMessage() {}
+
+func (x *RankResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rank_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RankResponse.ProtoReflect.Descriptor instead.
+func (*RankResponse) Descriptor() ([]byte, []int) {
+	return file_rank_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *RankResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *RankResponse) GetCached() bool {
+	if x != nil {
+		return x.Cached
+	}
+	return false
+}
+
+func (x *RankResponse) GetLatencyMs() int64 {
+	if x != nil {
+		return x.LatencyMs
+	}
+	return 0
+}
+
+func (x *RankResponse) GetRank() string {
+	if x != nil {
+		return x.Rank
+	}
+	return ""
+}
+
+func (x *RankResponse) GetTier() int32 {
+	if x != nil {
+		return x.Tier
+	}
+	return 0
+}
+
+func (x *RankResponse) GetRr() int32 {
+	if x != nil {
+		return x.Rr
+	}
+	return 0
+}
+
+func (x *RankResponse) GetHighestRank() string {
+	if x != nil {
+		return x.HighestRank
+	}
+	return ""
+}
+
+func (x *RankResponse) GetRrToNext() int32 {
+	if x != nil && x.RrToNext != nil {
+		return *x.RrToNext
+	}
+	return 0
+}
+
+func (x *RankResponse) GetUpdatedAt() string {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return ""
+}
+
+var File_rank_proto protoreflect.FileDescriptor
+
+var file_rank_proto_rawDesc = []byte{
+	0x0a, 0x0a, 0x72, 0x61, 0x6e, 0x6b, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x07, 0x72, 0x61,
+	0x6e, 0x6b, 0x2e, 0x76, 0x31, 0x22, 0x8b, 0x02, 0x0a, 0x0c, 0x52, 0x61, 0x6e, 0x6b, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67,
+	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65,
+	0x12, 0x16, 0x0a, 0x06, 0x63, 0x61, 0x63, 0x68, 0x65, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08,
+	0x52, 0x06, 0x63, 0x61, 0x63, 0x68, 0x65, 0x64, 0x12, 0x1d, 0x0a, 0x0a, 0x6c, 0x61, 0x74, 0x65,
+	0x6e, 0x63, 0x79, 0x5f, 0x6d, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x6c, 0x61,
+	0x74, 0x65, 0x6e, 0x63, 0x79, 0x4d, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x72, 0x61, 0x6e, 0x6b, 0x18,
+	0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x72, 0x61, 0x6e, 0x6b, 0x12, 0x12, 0x0a, 0x04, 0x74,
+	0x69, 0x65, 0x72, 0x18, 0x05, 0x20, 0x01, 0x28, 0x05, 0x52, 0x04, 0x74, 0x69, 0x65, 0x72, 0x12,
+	0x0e, 0x0a, 0x02, 0x72, 0x72, 0x18, 0x06, 0x20, 0x01, 0x28, 0x05, 0x52, 0x02, 0x72, 0x72, 0x12,
+	0x21, 0x0a, 0x0c, 0x68, 0x69, 0x67, 0x68, 0x65, 0x73, 0x74, 0x5f, 0x72, 0x61, 0x6e, 0x6b, 0x18,
+	0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x68, 0x69, 0x67, 0x68, 0x65, 0x73, 0x74, 0x52, 0x61,
+	0x6e, 0x6b, 0x12, 0x21, 0x0a, 0x0a, 0x72, 0x72, 0x5f, 0x74, 0x6f, 0x5f, 0x6e, 0x65, 0x78, 0x74,
+	0x18, 0x08, 0x20, 0x01, 0x28, 0x05, 0x48, 0x00, 0x52, 0x08, 0x72, 0x72, 0x54, 0x6f, 0x4e, 0x65,
+	0x78, 0x74, 0x88, 0x01, 0x01, 0x12, 0x1d, 0x0a, 0x0a, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x64,
+	0x5f, 0x61, 0x74, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x75, 0x70, 0x64, 0x61, 0x74,
+	0x65, 0x64, 0x41, 0x74, 0x42, 0x0d, 0x0a, 0x0b, 0x5f, 0x72, 0x72, 0x5f, 0x74, 0x6f, 0x5f, 0x6e,
+	0x65, 0x78, 0x74, 0x42, 0x09, 0x5a, 0x07, 0x2e, 0x2f, 0x3b, 0x6d, 0x61, 0x69, 0x6e, 0x62, 0x06,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_rank_proto_rawDescOnce sync.Once
+	file_rank_proto_rawDescData = file_rank_proto_rawDesc
+)
+
+func file_rank_proto_rawDescGZIP() []byte {
+	file_rank_proto_rawDescOnce.Do(func() {
+		file_rank_proto_rawDescData = protoimpl.X.CompressGZIP(file_rank_proto_rawDescData)
+	})
+	return file_rank_proto_rawDescData
+}
+
+var file_rank_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_rank_proto_goTypes = []any{
+	(*RankResponse)(nil), // 0: rank.v1.RankResponse
+}
+var file_rank_proto_depIdxs = []int32{
+	0, // [0:0] is the sub-list for method output_type
+	0, // [0:0] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_rank_proto_init() }
+func file_rank_proto_init() {
+	if File_rank_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_rank_proto_msgTypes[0].Exporter = func(v any, i int) any {
+			switch v := v.(*RankResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_rank_proto_msgTypes[0].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_rank_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_rank_proto_goTypes,
+		DependencyIndexes: file_rank_proto_depIdxs,
+		MessageInfos:      file_rank_proto_msgTypes,
+	}.Build()
+	File_rank_proto = out.File
+	file_rank_proto_rawDesc = nil
+	file_rank_proto_goTypes = nil
+	file_rank_proto_depIdxs = nil
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// intPtr returns a pointer to n.
+func intPtr(n int) *int { return &n }
+
+func TestRRToNext(t *testing.T) {
+	tests := []struct {
+		name string
+		tier int
+		rr   float64
+		want *int
+	}{
+		{"mid-tier", 15, 45, intPtr(55)},
+		{"fresh promotion", 16, 0, intPtr(100)},
+		{"fractional rr", 15, 45.7, intPtr(55)},
+		{"immortal 3", 26, 90, intPtr(10)},
+		{"past the tier cap", 15, 120, intPtr(0)},
+		{"radiant", radiantTier, 550, nil},
+		{"unranked", 0, 0, nil},
+		{"unrated", minRankedTier - 1, 10, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rrToNext(tt.tier, tt.rr)
+			switch {
+			case got == nil && tt.want == nil:
+			case got == nil || tt.want == nil || *got != *tt.want:
+				t.Errorf("rrToNext(%d, %v) = %v, want %v", tt.tier, tt.rr, deref(got), deref(tt.want))
+			}
+		})
+	}
+}
+
+// deref shows an optional int in test failures.
+func deref(n *int) interface{} {
+	if n == nil {
+		return nil
+	}
+	return *n
+}
+
+func TestRankProgress(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  map[string]interface{}
+		query string
+		want  interface{}
+		field bool
+	}{
+		{"mid-tier", rankData(15, "Platinum 1", 45, "Diamond 2"), "?progress=true", float64(55), true},
+		{"radiant", rankData(radiantTier, "Radiant", 550, "Radiant"), "?progress=true", nil, true},
+		{"not requested", rankData(15, "Platinum 1", 45, "Diamond 2"), "", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeServer(t, &fakeMMRClient{mmr: returns(tt.data)})
+			w := serve(s.Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar"+tt.query, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			got, ok := decodeBody(t, w.Body.Bytes())["rr_to_next"]
+			if ok != tt.field || got != tt.want {
+				t.Errorf("rr_to_next = %v (present %v), want %v (present %v)", got, ok, tt.want, tt.field)
+			}
+		})
+	}
+}
+
+func TestRankProgressText(t *testing.T) {
+	s := newFakeServer(t, &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))})
+	w := serve(s.Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar?progress=true&format=text", "")
+	if want := "Platinum 1 [45RR] | Peak: Diamond 2 | 55RR to rank up"; w.Body.String() != want {
+		t.Errorf("body = %q, want %q", w.Body, want)
+	}
+}
+
+func TestNumberValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		v      interface{}
+		want   float64
+		wantOK bool
+	}{
+		{"float64", 45.0, 45, true},
+		{"json.Number", json.Number("45"), 45, true},
+		{"fractional json.Number", json.Number("45.5"), 45.5, true},
+		{"bad json.Number", json.Number("4five"), 0, false},
+		{"numeric string", "45", 45, true},
+		{"padded string", " 45 ", 45, true},
+		{"word", "forty", 0, false},
+		{"int", 45, 0, false},
+		{"missing", nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := numberValue(tt.v)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("numberValue(%#v) = %v, %v, want %v, %v", tt.v, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseRankRR(t *testing.T) {
+	tests := []struct {
+		name     string
+		rr       interface{}
+		wantRR   float64
+		wantWarn bool
+	}{
+		{"float64", 45.0, 45, false},
+		{"json.Number", json.Number("45"), 45, false},
+		{"numeric string", "45", 45, false},
+		{"unreadable", "lots", 0, true},
+		{"missing", nil, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			old := slog.Default()
+			slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+			t.Cleanup(func() { slog.SetDefault(old) })
+
+			data := rankData(15, "Platinum 1", 0, "Diamond 2")
+			data["current_data"].(map[string]interface{})["ranking_in_tier"] = tt.rr
+			info, lerr := parseRank(data)
+			if lerr != nil {
+				t.Fatalf("parseRank() error = %v, want the rank with RR defaulted", lerr)
+			}
+			if info.RR != tt.wantRR || info.Rank != "Platinum 1" {
+				t.Errorf("parseRank() = %+v, want Platinum 1 on %v RR", info, tt.wantRR)
+			}
+			if warned := strings.Contains(logs.String(), "level=WARN"); warned != tt.wantWarn {
+				t.Errorf("warned = %v, want %v: %s", warned, tt.wantWarn, logs.String())
+			}
+		})
+	}
+}
+
+func TestUnrankedStatus(t *testing.T) {
+	unranked := rankData(0, "Unrated", 0, "")
+	ranked := rankData(15, "Platinum 1", 45, "Diamond 2")
+	tests := []struct {
+		name       string
+		status     int
+		data       map[string]interface{}
+		wantStatus int
+	}{
+		{"default unranked", http.StatusOK, unranked, http.StatusOK},
+		{"204 unranked", http.StatusNoContent, unranked, http.StatusNoContent},
+		{"204 ranked", http.StatusNoContent, ranked, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &unrankedStatus, tt.status)
+			s := newFakeServer(t, &fakeMMRClient{mmr: returns(tt.data)})
+
+			w := serve(s.Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus == http.StatusNoContent && w.Body.Len() != 0 {
+				t.Errorf("204 carries a body: %q", w.Body)
+			}
+			if tt.wantStatus == http.StatusOK && decodeBody(t, w.Body.Bytes())["message"] == nil {
+				t.Errorf("200 has no message: %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestValidateUnrankedStatus(t *testing.T) {
+	for status, wantErr := range map[int]bool{200: false, 204: false, 404: true, 0: true} {
+		setVar(t, &unrankedStatus, status)
+		if err := validateUnrankedStatus(); (err != nil) != wantErr {
+			t.Errorf("validateUnrankedStatus() with %d = %v, want an error: %v", status, err, wantErr)
+		}
+	}
+}
+
+func TestLatencyKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		legacy     bool
+		query      string
+		wantKey    bool
+		wantLegacy bool
+	}{
+		{"default", false, "", true, false},
+		{"suppressed", false, "?latency=false", false, false},
+		{"legacy key", true, "", true, true},
+		{"legacy key suppressed", true, "?latency=false", false, false},
+		{"explicitly on", false, "?latency=true", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &legacyLatencyKey, tt.legacy)
+			s := newFakeServer(t, &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))})
+
+			w := serve(s.Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar"+tt.query, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			body := decodeBody(t, w.Body.Bytes())
+			latency, ok := body["latency_ms"]
+			if ok != tt.wantKey {
+				t.Errorf("latency_ms present = %v, want %v: %s", ok, tt.wantKey, w.Body)
+			}
+			if _, isNumber := latency.(float64); ok && !isNumber {
+				t.Errorf("latency_ms = %#v, want a number", latency)
+			}
+			if _, ok := body["latency:ms"]; ok != tt.wantLegacy {
+				t.Errorf("latency:ms present = %v, want %v: %s", ok, tt.wantLegacy, w.Body)
+			}
+		})
+	}
+}
+
+func TestCompactFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		want string
+	}{
+		{"ranked", rankData(15, "Platinum 1", 45, "Diamond 2"), "15,45"},
+		{"fractional rr", rankData(15, "Platinum 1", 45.7, "Diamond 2"), "15,45"},
+		{"radiant", rankData(radiantTier, "Radiant", 550, "Radiant"), "27,550"},
+		{"unranked", rankData(0, "Unrated", 0, ""), "0,0"},
+		{"unrated tier", rankData(minRankedTier-1, "Unrated", 20, ""), "0,0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeServer(t, &fakeMMRClient{mmr: returns(tt.data)})
+			w := serve(s.Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar?format=compact&progress=true&level=true", "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("body = %q, want exactly %q", got, tt.want)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+				t.Errorf("Content-Type = %q, want text/plain", ct)
+			}
+		})
+	}
+}
+
+// actWinsPayload is an MMR data payload with the given by_season JSON.
+func actWinsPayload(t *testing.T, bySeason string) map[string]interface{} {
+	t.Helper()
+	data := rankData(15, "Platinum 1", 45, "Diamond 2")
+	var seasons map[string]interface{}
+	if err := json.Unmarshal([]byte(bySeason), &seasons); err != nil {
+		t.Fatal(err)
+	}
+	data["by_season"] = seasons
+	return data
+}
+
+func TestLatestActWins(t *testing.T) {
+	const sample = `{
+		"e9a1": {"act_rank_wins": [{"patched_tier": "Gold 1", "tier": 12}]},
+		"e9a2": {"act_rank_wins": [{"patched_tier": "Platinum 2", "tier": 16}, {"patched_tier": "Gold 3", "tier": "14"}]},
+		"e9a3": {"error": "No data Available"},
+		"e10a1": {"act_rank_wins": []},
+		"e8a3": {"act_rank_wins": [{"patched_tier": "Diamond 1", "tier": 18}]}
+	}`
+	tests := []struct {
+		name     string
+		bySeason string
+		want     *actWins
+	}{
+		{"latest act with wins", sample, &actWins{Act: "e9a2", Wins: []actWin{{16, "Platinum 2"}, {14, "Gold 3"}}}},
+		{"episodes compare as numbers", `{
+			"e9a3": {"act_rank_wins": [{"patched_tier": "Gold 1", "tier": 12}]},
+			"e10a1": {"act_rank_wins": [{"patched_tier": "Silver 1", "tier": 9}]}
+		}`, &actWins{Act: "e10a1", Wins: []actWin{{9, "Silver 1"}}}},
+		{"unknown keys ignored", `{"total": {"act_rank_wins": [{"tier": 3}]}}`, nil},
+		{"no wins anywhere", `{"e9a1": {"error": "No data Available"}}`, nil},
+		{"no seasons", `{}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := latestActWins(actWinsPayload(t, tt.bySeason)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("latestActWins() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if got := latestActWins(rankData(15, "Platinum 1", 45, "Diamond 2")); got != nil {
+		t.Errorf("latestActWins() without by_season = %+v, want nil", got)
+	}
+}
+
+func TestRankHandlerActWins(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string]interface{}
+		query   string
+		want    string
+		wantKey bool
+	}{
+		{"requested", actWinsPayload(t, `{"e9a2": {"act_rank_wins": [{"patched_tier": "Platinum 2", "tier": 16}]}}`),
+			"?actwins=true", `{"act":"e9a2","wins":[{"tier":16,"rank":"Platinum 2"}]}`, true},
+		{"absent from the payload", rankData(15, "Platinum 1", 45, "Diamond 2"), "?actwins=true", "null", true},
+		{"not requested", actWinsPayload(t, `{"e9a2": {"act_rank_wins": [{"patched_tier": "Platinum 2", "tier": 16}]}}`),
+			"", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeServer(t, &fakeMMRClient{mmr: returns(tt.data)})
+			w := serve(s.Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar"+tt.query, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			var body map[string]json.RawMessage
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			got, ok := body["act_wins"]
+			if ok != tt.wantKey || string(got) != tt.want {
+				t.Errorf("act_wins = %s (present %v), want %s (present %v)", got, ok, tt.want, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestRankProtobuf(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string]interface{}
+		query   string
+		accept  string
+		want    *RankResponse
+		latency bool
+	}{
+		{"format query", rankData(15, "Platinum 1", 45, "Diamond 2"), "?format=protobuf", "", &RankResponse{
+			Message: "Platinum 1 [45RR] | Peak: Diamond 2", Rank: "Platinum 1", Tier: 15, Rr: 45, HighestRank: "Diamond 2",
+		}, true},
+		{"accept header", rankData(15, "Platinum 1", 45, "Diamond 2"), "", "application/x-protobuf", &RankResponse{
+			Message: "Platinum 1 [45RR] | Peak: Diamond 2", Rank: "Platinum 1", Tier: 15, Rr: 45, HighestRank: "Diamond 2",
+		}, true},
+		{"with progress", rankData(15, "Platinum 1", 45, "Diamond 2"), "?format=protobuf&progress=true&latency=false", "", &RankResponse{
+			Message: "Platinum 1 [45RR] | Peak: Diamond 2", Rank: "Platinum 1", Tier: 15, Rr: 45, HighestRank: "Diamond 2", RrToNext: proto.Int32(55),
+		}, false},
+		{"radiant has no progress", rankData(radiantTier, "Radiant", 550, "Radiant"), "?format=protobuf&progress=true", "", &RankResponse{
+			Message: "Radiant [550RR] | Peak: Radiant", Rank: "Radiant", Tier: radiantTier, Rr: 550, HighestRank: "Radiant",
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeServer(t, &fakeMMRClient{mmr: returns(tt.data)})
+			var header []string
+			if tt.accept != "" {
+				header = []string{"Accept", tt.accept}
+			}
+			w := serve(s.Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar"+tt.query, "", header...)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/x-protobuf" {
+				t.Errorf("Content-Type = %q, want application/x-protobuf", ct)
+			}
+
+			var got RankResponse
+			if err := proto.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if _, err := time.Parse(time.RFC3339, got.UpdatedAt); err != nil {
+				t.Errorf("updated_at = %q, want an RFC 3339 time", got.UpdatedAt)
+			}
+			if !tt.latency && got.LatencyMs != 0 {
+				t.Errorf("latency_ms = %d, want 0 when suppressed", got.LatencyMs)
+			}
+			// Fields that vary between runs are not part of the comparison.
+			got.UpdatedAt, got.LatencyMs = "", 0
+			if !proto.Equal(&got, tt.want) {
+				t.Errorf("response = %v, want %v", &got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRankJSONByDefault(t *testing.T) {
+	s := newFakeServer(t, &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))})
+	for _, accept := range []string{"", "application/json", "*/*", "application/json, application/x-protobuf;q=0.5"} {
+		w := serve(s.Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar", "", "Accept", accept)
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Errorf("Accept %q: Content-Type = %q, want JSON", accept, ct)
+		}
+	}
+}
+
+func TestRRFormat(t *testing.T) {
+	tests := []struct {
+		format      string
+		wantMessage string
+		// wantRR is the rr field, absent without ?rr_format=.
+		wantRR interface{}
+	}{
+		{"", "Platinum 1 [45RR] | Peak: Diamond 2", nil},
+		{"int", "Platinum 1 [45RR] | Peak: Diamond 2", float64(45)},
+		{"float", "Platinum 1 [45.7RR] | Peak: Diamond 2", 45.7},
+		{"percent", "Platinum 1 [46%] | Peak: Diamond 2", float64(46)},
+	}
+	for _, tt := range tests {
+		t.Run(cmp.Or(tt.format, "default"), func(t *testing.T) {
+			s := newFakeServer(t, &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45.7, "Diamond 2"))})
+			h := s.Handler()
+			w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar?rr_format="+tt.format, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			body := decodeBody(t, w.Body.Bytes())
+			if body["message"] != tt.wantMessage {
+				t.Errorf("message = %q, want %q", body["message"], tt.wantMessage)
+			}
+			if rr, ok := body["rr"]; rr != tt.wantRR || ok != (tt.wantRR != nil) {
+				t.Errorf("rr = %v, want %v", rr, tt.wantRR)
+			}
+
+			w = serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar?format=text&rr_format="+tt.format, "")
+			if got := w.Body.String(); got != tt.wantMessage {
+				t.Errorf("text body = %q, want %q", got, tt.wantMessage)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		fake := &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45.7, "Diamond 2"))}
+		w := serve(newFakeServer(t, fake).Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar?rr_format=hex", "")
+		if body := decodeBody(t, w.Body.Bytes()); w.Code != http.StatusBadRequest || body["code"] != codeInvalidRRFormat {
+			t.Errorf("status = %d %v, want 400 %s", w.Code, body["code"], codeInvalidRRFormat)
+		}
+		if n := fake.calls.Load(); n != 0 {
+			t.Errorf("upstream calls = %d, want 0 for an invalid format", n)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validateRateLimitMode rejects unknown RATE_LIMIT_MODE values.
+func validateRateLimitMode(mode string) error {
+	switch mode {
+	case "all", "upstream":
+		return nil
+	}
+	return fmt.Errorf("invalid RATE_LIMIT_MODE %q, expected all or upstream", mode)
+}
+
+func rateLimitedError() *apiError {
+	return newAPIError(http.StatusTooManyRequests, codeRateLimited, "Too many requests, try again later")
+}
+
+type upstreamAllowanceKey struct{}
+
+// allowUpstream reports whether the request of ctx may make another upstream
+// lookup. Contexts without a request, such as background refreshes, always
+// may.
+func allowUpstream(ctx context.Context) bool {
+	allow, ok := ctx.Value(upstreamAllowanceKey{}).(func() bool)
+	return !ok || allow()
+}
+
+// rateLimiter enforces the limit of q per client. In "all" mode every
+// request counts and is refused up front. In "upstream" mode the request
+// proceeds and each upstream lookup it would make is charged through
+// allowUpstream instead.
+func rateLimiter(q *quota, mode string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := clientKey(c)
+		if mode == "upstream" {
+			allow := func() bool { return q.allow(key, 1) }
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), upstreamAllowanceKey{}, allow))
+			c.Next()
+			return
+		}
+		if !q.allow(key, 1) {
+			respondError(c, rateLimitedError())
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// clientAddrs are the addresses test clients connect from.
+var clientAddrs = map[string]string{
+	"alice": "198.51.100.1:40000",
+	"bob":   "198.51.100.2:40000",
+}
+
+func TestRateLimitModes(t *testing.T) {
+	// step is one request of a client for a player, and the status it gets.
+	type step struct {
+		client, player string
+		want           int
+	}
+	tests := []struct {
+		mode  string
+		steps []step
+	}{
+		{"all", []step{
+			{"alice", "a", http.StatusOK},
+			{"alice", "a", http.StatusOK},
+			{"alice", "a", http.StatusTooManyRequests},
+			{"alice", "b", http.StatusTooManyRequests},
+			{"bob", "b", http.StatusOK},
+		}},
+		{"upstream", []step{
+			{"alice", "a", http.StatusOK},
+			{"alice", "a", http.StatusOK},
+			{"alice", "b", http.StatusOK},
+			// Out of lookups, alice is still served what is cached...
+			{"alice", "a", http.StatusOK},
+			{"alice", "b", http.StatusOK},
+			// ...but nothing that would go upstream.
+			{"alice", "c", http.StatusTooManyRequests},
+			{"bob", "c", http.StatusOK},
+			{"alice", "c", http.StatusOK},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			fake := &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))}
+			cfg := testConfig(t, "http://upstream.invalid")
+			cfg.RateLimit, cfg.RateLimitWindow, cfg.RateLimitMode = 2, time.Minute, tt.mode
+			h := NewServer(cfg, discardLogger(), fake).Handler()
+
+			for i, st := range tt.steps {
+				w := serveFrom(h, clientAddrs[st.client], http.MethodGet, "/rest/v1/rank/eu/"+st.player+"/t", "")
+				if w.Code != st.want {
+					t.Fatalf("step %d, %s asking for %s: status = %d, want %d: %s", i, st.client, st.player, w.Code, st.want, w.Body)
+				}
+				if st.want == http.StatusTooManyRequests {
+					if body := decodeBody(t, w.Body.Bytes()); body["code"] != codeRateLimited {
+						t.Errorf("step %d: code = %v, want %s", i, body["code"], codeRateLimited)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestValidateRateLimitMode(t *testing.T) {
+	for mode, wantErr := range map[string]bool{"all": false, "upstream": false, "": true, "cache": true} {
+		if err := validateRateLimitMode(mode); (err != nil) != wantErr {
+			t.Errorf("validateRateLimitMode(%q) = %v, want an error: %v", mode, err, wantErr)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import "math"
+
+// recentGames is how many of the latest games the recent summary covers.
+var recentGames = envInt("RECENT_GAMES", 5)
+
+// recentSummary describes a player's latest competitive results.
+type recentSummary struct {
+	Wins    int      `json:"wins"`
+	Losses  int      `json:"losses"`
+	Draws   int      `json:"draws"`
+	Results []string `json:"results"`
+}
+
+// summarizeRecent derives wins and losses from the RR change of the latest n
+// games in an MMR history payload, newest first. It returns nil when no
+// history is available.
+func summarizeRecent(history map[string]interface{}, n int) *recentSummary {
+	games, _ := history["items"].([]interface{})
+	if len(games) == 0 {
+		return nil
+	}
+
+	summary := &recentSummary{Results: []string{}}
+	for _, g := range games[:min(n, len(games))] {
+		game, ok := g.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		change, _ := numberValue(game["mmr_change_to_last_game"])
+		switch {
+		case change > 0:
+			summary.Wins++
+			summary.Results = append(summary.Results, "W")
+		case change < 0:
+			summary.Losses++
+			summary.Results = append(summary.Results, "L")
+		default:
+			summary.Draws++
+			summary.Results = append(summary.Results, "D")
+		}
+	}
+	return summary
+}
+
+// estimateGames is how many of the latest games the rank up estimate
+// averages over.
+var estimateGames = envInt("ESTIMATE_GAMES", 10)
+
+// minEstimateGames is the least history an estimate is made from.
+const minEstimateGames = 3
+
+// rankUpEstimate projects the recent RR trend onto the next tier.
+type rankUpEstimate struct {
+	GamesAveraged int     `json:"games_averaged"`
+	AvgRRPerGame  float64 `json:"avg_rr_per_game"`
+	// GamesToRankUp is nil when the trend is flat or negative, or there is
+	// no next tier to reach.
+	GamesToRankUp *int `json:"games_to_rank_up"`
+}
+
+// estimateRankUp averages the RR change of the latest n games of an MMR
+// history payload and estimates how many more games reach the next tier,
+// toNext RR away. It returns nil with fewer than minEstimateGames games.
+func estimateRankUp(history map[string]interface{}, toNext *int, n int) *rankUpEstimate {
+	games, _ := history["items"].([]interface{})
+
+	total, counted := 0.0, 0
+	for _, g := range games[:min(n, len(games))] {
+		game, ok := g.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		change, ok := numberValue(game["mmr_change_to_last_game"])
+		if !ok {
+			continue
+		}
+		total += change
+		counted++
+	}
+	if counted < minEstimateGames {
+		return nil
+	}
+
+	avg := total / float64(counted)
+	estimate := &rankUpEstimate{GamesAveraged: counted, AvgRRPerGame: math.Round(avg*10) / 10}
+	if toNext != nil && avg > 0 {
+		games := int(math.Ceil(float64(*toNext) / avg))
+		estimate.GamesToRankUp = &games
+	}
+	return estimate
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// historyData decodes a sample MMR history payload with one game per RR
+// change, newest first, the way upstream responses are decoded.
+func historyData(t *testing.T, changes ...int) map[string]interface{} {
+	t.Helper()
+	items := make([]map[string]int, len(changes))
+	for i, c := range changes {
+		items[i] = map[string]int{"mmr_change_to_last_game": c}
+	}
+	b, _ := json.Marshal(map[string]interface{}{"items": items})
+	var data map[string]interface{}
+	if err := json.Unmarshal(b, &data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestSummarizeRecent(t *testing.T) {
+	tests := []struct {
+		name    string
+		history map[string]interface{}
+		n       int
+		want    *recentSummary
+	}{
+		{"no history", map[string]interface{}{}, 5, nil},
+		{"no games", historyData(t), 5, nil},
+		{"mixed", historyData(t, 18, -15, 0, 21), 5, &recentSummary{Wins: 2, Losses: 1, Draws: 1, Results: []string{"W", "L", "D", "W"}}},
+		{"only the latest n", historyData(t, -10, -12, 20, 20, 20), 2, &recentSummary{Losses: 2, Results: []string{"L", "L"}}},
+		{"numbers not decoded as float64", map[string]interface{}{"items": []interface{}{
+			map[string]interface{}{"mmr_change_to_last_game": json.Number("-12")},
+			map[string]interface{}{"mmr_change_to_last_game": "15"},
+			map[string]interface{}{"mmr_change_to_last_game": json.Number("0")},
+		}}, 5, &recentSummary{Wins: 1, Losses: 1, Draws: 1, Results: []string{"L", "W", "D"}}},
+		{"malformed game skipped", map[string]interface{}{"items": []interface{}{"nope", map[string]interface{}{"mmr_change_to_last_game": 5.0}}}, 5, &recentSummary{Wins: 1, Results: []string{"W"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := summarizeRecent(tt.history, tt.n); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("summarizeRecent() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRankHandlerRecent(t *testing.T) {
+	setVar(t, &recentGames, 3)
+	history := historyData(t, 20, -18, 22, 19)
+	fake := &fakeMMRClient{
+		mmr:     returns(rankData(15, "Platinum 1", 45, "Diamond 2")),
+		history: returns(history),
+	}
+	h := newFakeServer(t, fake).Handler()
+
+	w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar?recent=true", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var body struct {
+		Recent *recentSummary `json:"recent"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	want := &recentSummary{Wins: 2, Losses: 1, Results: []string{"W", "L", "W"}}
+	if !reflect.DeepEqual(body.Recent, want) {
+		t.Errorf("recent = %+v, want %+v", body.Recent, want)
+	}
+	if n := fake.calls.Load(); n != 2 {
+		t.Errorf("upstream calls = %d, want one MMR and one history call", n)
+	}
+
+	// The history is cached on its own: a second lookup needs no calls, and
+	// without ?recent=true there is no summary.
+	serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar?recent=true", "")
+	if n := fake.calls.Load(); n != 2 {
+		t.Errorf("upstream calls = %d after a repeat, want 2", n)
+	}
+	w = serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+	if _, ok := decodeBody(t, w.Body.Bytes())["recent"]; ok {
+		t.Errorf("recent is set without ?recent=true: %s", w.Body)
+	}
+}
+
+func TestEstimateRankUp(t *testing.T) {
+	tests := []struct {
+		name    string
+		history map[string]interface{}
+		toNext  *int
+		n       int
+		want    *rankUpEstimate
+	}{
+		{"no history", map[string]interface{}{}, intPtr(55), 10, nil},
+		{"too few games", historyData(t, 20, 20), intPtr(55), 10, nil},
+		{"rising", historyData(t, 20, -10, 20), intPtr(55), 10, &rankUpEstimate{GamesAveraged: 3, AvgRRPerGame: 10, GamesToRankUp: intPtr(6)}},
+		{"average rounded", historyData(t, 10, 11, 11), intPtr(55), 10, &rankUpEstimate{GamesAveraged: 3, AvgRRPerGame: 10.7, GamesToRankUp: intPtr(6)}},
+		{"only the latest n", historyData(t, 30, 30, 30, -100), intPtr(55), 3, &rankUpEstimate{GamesAveraged: 3, AvgRRPerGame: 30, GamesToRankUp: intPtr(2)}},
+		{"falling", historyData(t, -10, -5, -15), intPtr(55), 10, &rankUpEstimate{GamesAveraged: 3, AvgRRPerGame: -10}},
+		{"flat", historyData(t, 0, 0, 0), intPtr(55), 10, &rankUpEstimate{GamesAveraged: 3}},
+		{"no next tier", historyData(t, 20, 20, 20), nil, 10, &rankUpEstimate{GamesAveraged: 3, AvgRRPerGame: 20}},
+		{"already there", historyData(t, 20, 20, 20), intPtr(0), 10, &rankUpEstimate{GamesAveraged: 3, AvgRRPerGame: 20, GamesToRankUp: intPtr(0)}},
+		{"malformed games skipped", map[string]interface{}{"items": []interface{}{
+			"nope", map[string]interface{}{}, map[string]interface{}{"mmr_change_to_last_game": 10.0},
+			map[string]interface{}{"mmr_change_to_last_game": "20"}, map[string]interface{}{"mmr_change_to_last_game": 30.0},
+		}}, intPtr(55), 10, &rankUpEstimate{GamesAveraged: 3, AvgRRPerGame: 20, GamesToRankUp: intPtr(3)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := estimateRankUp(tt.history, tt.toNext, tt.n); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("estimateRankUp() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRankHandlerEstimate(t *testing.T) {
+	tests := []struct {
+		name    string
+		history fakeResult
+		query   string
+		want    *rankUpEstimate
+		wantSet bool
+	}{
+		{"estimated", returns(historyData(t, 20, -10, 20)), "?estimate=true", &rankUpEstimate{GamesAveraged: 3, AvgRRPerGame: 10, GamesToRankUp: intPtr(6)}, true},
+		{"history unavailable", fails(http.StatusBadGateway), "?estimate=true", nil, true},
+		{"not asked for", returns(historyData(t, 20, -10, 20)), "", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2")), history: tt.history}
+			w := serve(newFakeServer(t, fake).Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar"+tt.query, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			var body map[string]json.RawMessage
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			raw, ok := body["estimate"]
+			if ok != tt.wantSet {
+				t.Fatalf("estimate present = %v, want %v: %s", ok, tt.wantSet, w.Body)
+			}
+			var got *rankUpEstimate
+			if ok {
+				if err := json.Unmarshal(raw, &got); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("estimate = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"cmp"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// regionAliases maps common alternative spellings onto canonical regions.
+var regionAliases = map[string]string{
+	"euw":   "eu",
+	"eune":  "eu",
+	"us":    "na",
+	"apac":  "ap",
+	"asia":  "ap",
+	"korea": "kr",
+	"latm":  "latam",
+	"lan":   "latam",
+	"las":   "latam",
+}
+
+// regionInfo is the metadata /rest/v1/regions reports for a region.
+type regionInfo struct {
+	DisplayName string `json:"display_name"`
+	// TimeZone is a representative IANA zone for the region's players.
+	TimeZone string `json:"time_zone,omitempty"`
+}
+
+// parseRegions parses a comma separated region list, returning def when the
+// list is empty. Listed regions keep their metadata from def; others are
+// named after their upper-cased code.
+func parseRegions(list string, def map[string]regionInfo) map[string]regionInfo {
+	regions := make(map[string]regionInfo)
+	for _, r := range strings.Split(list, ",") {
+		if r = strings.ToLower(strings.TrimSpace(r)); r != "" {
+			info, ok := def[r]
+			if !ok {
+				info = regionInfo{DisplayName: strings.ToUpper(r)}
+			}
+			regions[r] = info
+		}
+	}
+	if len(regions) == 0 {
+		return def
+	}
+	return regions
+}
+
+// isValidRegion reports whether region is one of the server's regions.
+func (s *Server) isValidRegion(region string) bool {
+	_, ok := s.regions[region]
+	return ok
+}
+
+// sortedRegions returns the valid regions in a stable order.
+func (s *Server) sortedRegions() []string {
+	return slices.Sorted(maps.Keys(s.regions))
+}
+
+// activeAliases returns the aliases that point at a currently valid region.
+func (s *Server) activeAliases() map[string]string {
+	aliases := make(map[string]string)
+	for alias, region := range regionAliases {
+		if s.isValidRegion(region) {
+			aliases[alias] = region
+		}
+	}
+	return aliases
+}
+
+// regionsHandler lists the valid regions, their metadata and the aliases
+// accepted for them.
+func (s *Server) regionsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"regions":  s.sortedRegions(),
+		"metadata": s.regions,
+		"aliases":  s.activeAliases(),
+	})
+}
+
+// maxRegionCacheEntries bounds the normalization cache.
+var maxRegionCacheEntries = envInt("REGION_CACHE_SIZE", 1024)
+
+// regionCache memoizes the canonical region for raw inputs seen before. Only
+// inputs that resolve to a valid region are stored, and storing stops once
+// the cache is full, so arbitrary client input cannot grow it.
+type regionCache struct {
+	mu sync.RWMutex
+	m  map[string]string
+}
+
+func newRegionCache() *regionCache {
+	return &regionCache{m: make(map[string]string)}
+}
+
+// canonicalRegion resolves raw region input to the region of valid it names,
+// directly or through an alias. It is the uncached core of normalizeRegion.
+func canonicalRegion(raw string, valid map[string]regionInfo) (string, bool) {
+	region := strings.ToLower(strings.TrimSpace(raw))
+	if alias, ok := regionAliases[region]; ok {
+		region = alias
+	}
+	_, ok := valid[region]
+	return region, ok
+}
+
+// normalizeRegion resolves raw region input to its canonical, valid form. It
+// reports false when the input does not name a known region.
+func (s *Server) normalizeRegion(raw string) (string, bool) {
+	s.regionCache.mu.RLock()
+	region, ok := s.regionCache.m[raw]
+	s.regionCache.mu.RUnlock()
+	if ok {
+		return region, true
+	}
+
+	region, ok = canonicalRegion(raw, s.regions)
+	if !ok {
+		return "", false
+	}
+
+	s.regionCache.mu.Lock()
+	if len(s.regionCache.m) < maxRegionCacheEntries {
+		s.regionCache.m[raw] = region
+	}
+	s.regionCache.mu.Unlock()
+
+	return region, true
+}
+
+// parseRegion validates region input from any source, so path, query and body
+// values get the same normalization and the same INVALID_REGION error.
+func (s *Server) parseRegion(raw string) (string, *apiError) {
+	region, ok := s.normalizeRegion(raw)
+	if !ok {
+		lerr := newAPIError(http.StatusBadRequest, codeInvalidRegion, "Invalid Region: "+raw)
+		lerr.text = "Unknown region, try one of: " + strings.Join(s.sortedRegions(), ", ")
+		return "", lerr
+	}
+	return region, nil
+}
+
+// requestRegion extracts and validates the region of a request, preferring
+// the :region path parameter, then ?region= and then DEFAULT_REGION.
+func (s *Server) requestRegion(c *gin.Context) (string, *apiError) {
+	raw := cmp.Or(c.Param("region"), c.Query("region"), s.cfg.DefaultRegion)
+	return s.parseRegion(raw)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestParseRegions(t *testing.T) {
+	tests := []struct {
+		name string
+		list string
+		want map[string]regionInfo
+	}{
+		{"empty keeps defaults", "", defaultRegions},
+		{"only commas keeps defaults", " , ,", defaultRegions},
+		{"known regions keep metadata", "EU, kr", map[string]regionInfo{
+			"eu": defaultRegions["eu"],
+			"kr": defaultRegions["kr"],
+		}},
+		{"unknown regions are named after their code", "eu,pbe", map[string]regionInfo{
+			"eu":  defaultRegions["eu"],
+			"pbe": {DisplayName: "PBE"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRegions(tt.list, defaultRegions); !maps.Equal(got, tt.want) {
+				t.Errorf("parseRegions(%q) = %v, want %v", tt.list, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegionsHandlerMetadata(t *testing.T) {
+	s := newFakeServer(t, &fakeMMRClient{})
+
+	w := serve(s.Handler(), http.MethodGet, "/rest/v1/regions", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	var body struct {
+		Regions  []string              `json:"regions"`
+		Metadata map[string]regionInfo `json:"metadata"`
+		Aliases  map[string]string     `json:"aliases"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Regions) != len(defaultRegions) {
+		t.Errorf("regions = %v, want the %d defaults", body.Regions, len(defaultRegions))
+	}
+	if !maps.Equal(body.Metadata, defaultRegions) {
+		t.Errorf("metadata = %v, want %v", body.Metadata, defaultRegions)
+	}
+	if got := body.Metadata["eu"]; got.DisplayName != "Europe" || got.TimeZone != "Europe/Berlin" {
+		t.Errorf("eu metadata = %+v", got)
+	}
+	if body.Aliases["euw"] != "eu" {
+		t.Errorf("aliases[euw] = %q, want eu", body.Aliases["euw"])
+	}
+}
+
+func TestRegionsHandler(t *testing.T) {
+	tests := []struct {
+		validRegions string
+		wantRegions  []string
+		wantAliases  map[string]string
+	}{
+		{"", slices.Sorted(maps.Keys(defaultRegions)), regionAliases},
+		{"eu,kr", []string{"eu", "kr"}, map[string]string{"euw": "eu", "eune": "eu", "korea": "kr"}},
+		{" NA ", []string{"na"}, map[string]string{"us": "na"}},
+		{"pbe", []string{"pbe"}, map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.validRegions, func(t *testing.T) {
+			t.Setenv("VALID_REGIONS", tt.validRegions)
+			cfg := testConfig(t, "http://upstream.invalid")
+			s := NewServer(cfg, discardLogger(), &fakeMMRClient{})
+
+			w := serve(s.Handler(), http.MethodGet, "/rest/v1/regions", "")
+			var body struct {
+				Regions []string          `json:"regions"`
+				Aliases map[string]string `json:"aliases"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK {
+				t.Fatalf("status = %d %s: %v", w.Code, w.Body, err)
+			}
+			if !slices.Equal(body.Regions, tt.wantRegions) {
+				t.Errorf("regions = %q, want %q", body.Regions, tt.wantRegions)
+			}
+			if !maps.Equal(body.Aliases, tt.wantAliases) {
+				t.Errorf("aliases = %v, want %v", body.Aliases, tt.wantAliases)
+			}
+		})
+	}
+}
+
+func TestNormalizeRegionCache(t *testing.T) {
+	setVar(t, &maxRegionCacheEntries, 3)
+	s := newFakeServer(t, &fakeMMRClient{})
+
+	tests := []struct {
+		raw    string
+		want   string
+		wantOK bool
+	}{
+		{"eu", "eu", true},
+		{" EUW ", "eu", true},
+		{"Korea", "kr", true},
+		{"pbe", "", false},
+		{"", "", false},
+		{"lan", "latam", true},
+	}
+	for range 2 {
+		for _, tt := range tests {
+			if got, ok := s.normalizeRegion(tt.raw); got != tt.want || ok != tt.wantOK {
+				t.Errorf("normalizeRegion(%q) = %q, %v, want %q, %v", tt.raw, got, ok, tt.want, tt.wantOK)
+			}
+		}
+	}
+
+	want := map[string]string{"eu": "eu", " EUW ": "eu", "Korea": "kr"}
+	if !maps.Equal(s.regionCache.m, want) {
+		t.Errorf("cache = %v, want %v", s.regionCache.m, want)
+	}
+}
+
+func BenchmarkNormalizeRegion(b *testing.B) {
+	for _, bb := range []struct {
+		name    string
+		entries int
+	}{
+		{"hit", 1024},
+		{"miss", 0},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			setVar(b, &maxRegionCacheEntries, bb.entries)
+			s := &Server{regions: defaultRegions, regionCache: newRegionCache()}
+			s.normalizeRegion(" EUW ")
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				s.normalizeRegion(" EUW ")
+			}
+		})
+	}
+}
+
+func FuzzCanonicalRegion(f *testing.F) {
+	f.Fuzz(func(t *testing.T, raw string) {
+		region, ok := canonicalRegion(raw, defaultRegions)
+		if !ok {
+			return
+		}
+		if _, known := defaultRegions[region]; !known {
+			t.Fatalf("canonicalRegion(%q) = %q, which is not a valid region", raw, region)
+		}
+		if again, ok := canonicalRegion(region, defaultRegions); !ok || again != region {
+			t.Fatalf("canonicalRegion(%q) = %q, %v; want it to be canonical", region, again, ok)
+		}
+	})
+}
+
+func TestRegionSourcesAgree(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	fake := &fakeMMRClient{mmr: func(region, name, tag string) (map[string]interface{}, *apiError) {
+		mu.Lock()
+		got = append(got, region)
+		mu.Unlock()
+		return rankData(15, "Platinum 1", 45, "Diamond 2"), nil
+	}}
+	cfg := testConfig(t, "http://upstream.invalid")
+	// The default region lets /rank/:name/:tag take ?region=.
+	cfg.DefaultRegion = "na"
+	h := NewServer(cfg, discardLogger(), fake).Handler()
+
+	tests := []struct {
+		raw        string
+		wantRegion string
+	}{
+		{"eu", "eu"},
+		{"EU", "eu"},
+		{" euw ", "eu"},
+		{"Korea", "kr"},
+		{"mars", ""},
+		{"e u", ""},
+	}
+	for i, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			// Distinct players keep every lookup off earlier cache entries.
+			tag := strconv.Itoa(i)
+			path := serve(h, http.MethodGet, "/rest/v1/rank/"+url.PathEscape(tt.raw)+"/path/"+tag, "")
+			query := serve(h, http.MethodGet, "/rest/v1/rank/query/"+tag+"?region="+url.QueryEscape(tt.raw), "")
+			body, _ := json.Marshal(map[string]interface{}{"players": []batchPlayer{{Region: tt.raw, Name: "batch", Tag: tag}}})
+			batch := serve(h, http.MethodPost, "/rest/v1/ranks", string(body))
+
+			if path.Code != query.Code || (path.Code != http.StatusOK && path.Body.String() != query.Body.String()) {
+				t.Errorf("path answered %d %s, query answered %d %s", path.Code, path.Body, query.Code, query.Body)
+			}
+			var results struct{ Results []batchResult }
+			if err := json.Unmarshal(batch.Body.Bytes(), &results); err != nil || len(results.Results) != 1 {
+				t.Fatalf("batch answered %d %s", batch.Code, batch.Body)
+			}
+			result := results.Results[0]
+
+			if tt.wantRegion == "" {
+				if path.Code != http.StatusBadRequest || decodeBody(t, path.Body.Bytes())["code"] != codeInvalidRegion {
+					t.Errorf("path answered %d %s, want 400 %s", path.Code, path.Body, codeInvalidRegion)
+				}
+				if want := decodeBody(t, path.Body.Bytes())["error"]; result.Error != want {
+					t.Errorf("batch error = %q, want %q like the path", result.Error, want)
+				}
+				return
+			}
+			if path.Code != http.StatusOK || result.Error != "" {
+				t.Fatalf("path answered %d %s, batch error %q, want success", path.Code, path.Body, result.Error)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if want := []string{tt.wantRegion, tt.wantRegion, tt.wantRegion}; !slices.Equal(got, want) {
+				t.Errorf("upstream regions = %q, want %q", got, want)
+			}
+			got = nil
+		})
+	}
+}
+
+func TestDefaultRegion(t *testing.T) {
+	tests := []struct {
+		name          string
+		defaultRegion string
+		target        string
+		wantStatus    int
+		wantRegion    string
+	}{
+		{"region-less route", "eu", "/rest/v1/rank/foo/bar", http.StatusOK, "eu"},
+		{"region given in the query", "eu", "/rest/v1/rank/foo/bar?region=kr", http.StatusOK, "kr"},
+		{"region given in the path", "eu", "/rest/v1/rank/na/foo/bar", http.StatusOK, "na"},
+		{"path batch still served", "eu", "/rest/v1/rank/na/foo%23bar", http.StatusOK, "na"},
+		{"no default region", "", "/rest/v1/rank/foo/bar", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			fake := &fakeMMRClient{mmr: func(region, name, tag string) (map[string]interface{}, *apiError) {
+				got = append(got, region)
+				return rankData(15, "Platinum 1", 45, "Diamond 2"), nil
+			}}
+			cfg := testConfig(t, "http://upstream.invalid")
+			cfg.DefaultRegion = tt.defaultRegion
+			h := NewServer(cfg, discardLogger(), fake).Handler()
+
+			w := serve(h, http.MethodGet, tt.target, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			var want []string
+			if tt.wantRegion != "" {
+				want = []string{tt.wantRegion}
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("upstream regions = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+)
+
+// reloadFiles rereads the denylist and roster files. Each is parsed in full
+// before it replaces the one in use, so requests never see a partial list,
+// and one that fails keeps serving its previous contents.
+func reloadFiles() error {
+	return errors.Join(loadDenylist(), loadRoster())
+}
+
+// reloadOnHangup reloads the denylist and roster files on every signal
+// received on hup until ctx is done. The caller registers hup for SIGHUP, so
+// the signal is caught from the moment it does rather than from when this
+// goroutine gets to run.
+func reloadOnHangup(ctx context.Context, logger *slog.Logger, hup <-chan os.Signal) {
+	for {
+		select {
+		case <-hup:
+			if err := reloadFiles(); err != nil {
+				logger.Error("Failed to reload files, keeping the previous contents", slog.String("error", err.Error()))
+				continue
+			}
+			logger.Info("Reloaded files",
+				slog.Int("denylist_entries", denylistLen()),
+				slog.Int("roster_teams", len(rosterTeams())),
+			)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe to log to from another goroutine.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// take returns what was logged so far and empties the buffer.
+func (b *syncBuffer) take() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.buf.String()
+	b.buf.Reset()
+	return s
+}
+
+func TestReloadOnHangup(t *testing.T) {
+	useDenylist(t, "exact", "oldguy")
+	useRoster(t, `{"old": [{"region": "eu", "name": "a", "tag": "t"}]}`)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	t.Cleanup(func() { signal.Stop(hup) })
+
+	logs := &syncBuffer{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reloadOnHangup(ctx, slog.New(slog.NewTextHandler(logs, nil)), hup)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	fake := &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))}
+	h := newFakeServer(t, fake).Handler()
+
+	// Each step rewrites both files and sends SIGHUP; want are the statuses
+	// of the players and teams afterwards.
+	tests := []struct {
+		name     string
+		denylist string
+		roster   string
+		wantLog  string
+		want     map[string]int
+	}{
+		{"new lists take effect", "newguy", `{"new": [{"region": "eu", "name": "a", "tag": "t"}]}`, "Reloaded files", map[string]int{
+			"/rest/v1/rank/eu/newguy/t": http.StatusForbidden,
+			"/rest/v1/rank/eu/oldguy/t": http.StatusOK,
+			"/rest/v1/team/new":         http.StatusOK,
+			"/rest/v1/team/old":         http.StatusNotFound,
+		}},
+		{"a bad file keeps its previous contents", "thirdguy", `{"third": [`, "Failed to reload files", map[string]int{
+			"/rest/v1/rank/eu/thirdguy/t": http.StatusForbidden,
+			"/rest/v1/rank/eu/newguy/t":   http.StatusOK,
+			"/rest/v1/team/new":           http.StatusOK,
+		}},
+	}
+	for _, tt := range tests {
+		if err := os.WriteFile(denylistPath, []byte(tt.denylist), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(rosterPath, []byte(tt.roster), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		logs.take()
+
+		var logged string
+		deadline := time.Now().Add(2 * time.Second)
+		for !strings.Contains(logged, tt.wantLog) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: logged %q, want %q", tt.name, logged, tt.wantLog)
+			}
+			syscall.Kill(os.Getpid(), syscall.SIGHUP)
+			time.Sleep(10 * time.Millisecond)
+			logged += logs.take()
+		}
+
+		for target, want := range tt.want {
+			if w := serve(h, http.MethodGet, target, ""); w.Code != want {
+				t.Errorf("%s: %s = %d, want %d: %s", tt.name, target, w.Code, want, w.Body)
+			}
+		}
+	}
+}
+
+func TestHangupBeforeReloadLoopIsQueued(t *testing.T) {
+	useDenylist(t, "exact", "oldguy")
+	useRoster(t, `{"old": [{"region": "eu", "name": "a", "tag": "t"}]}`)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	t.Cleanup(func() { signal.Stop(hup) })
+
+	// The hangup arrives before the reload loop runs, as during startup.
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	if err := os.WriteFile(denylistPath, []byte("newguy"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	logs := &syncBuffer{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reloadOnHangup(ctx, slog.New(slog.NewTextHandler(logs, nil)), hup)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	var logged string
+	for deadline := time.Now().Add(2 * time.Second); !strings.Contains(logged, "Reloaded files"); {
+		if time.Now().After(deadline) {
+			t.Fatalf("logged %q, want the queued hangup to reload", logged)
+		}
+		time.Sleep(10 * time.Millisecond)
+		logged += logs.take()
+	}
+	if got := denylistLen(); got != 1 || !isDenied("newguy") {
+		t.Errorf("denylist has %d entries, want just newguy after the reload", got)
+	}
+}
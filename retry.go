@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// maxUpstreamRetries is how many extra attempts a failed upstream call may
+	// make, subject to the shared retry budget.
+	maxUpstreamRetries = envInt("UPSTREAM_MAX_RETRIES", 1)
+	// maxDecodeRetries is how many times a 200 whose body fails to decode,
+	// usually because it was truncated, is fetched again. It draws on the
+	// same retry budget but is counted separately from maxUpstreamRetries.
+	maxDecodeRetries = envInt("UPSTREAM_DECODE_RETRIES", 1)
+	// retryBackoff is the base delay before a retry, multiplied by the attempt.
+	retryBackoff = envDuration("UPSTREAM_RETRY_BACKOFF", 100*time.Millisecond)
+
+	upstreamRetryBudget = newRetryBudget(float64(envInt("RETRY_BUDGET_TOKENS", 10)), 0.1)
+
+	// upstreamLastSuccess is the Unix nanosecond time henrikdev last answered
+	// without a server error or rate limit, zero if it never has.
+	upstreamLastSuccess atomic.Int64
+)
+
+// retryBudget is a process wide token bucket limiting retries, modelled on
+// gRPC retry throttling. Every failure costs a token and every success earns
+// back a fraction of one; retries are only allowed while more than half the
+// bucket remains. Under a sustained outage the bucket drains and calls
+// degrade to a single attempt instead of multiplying load.
+type retryBudget struct {
+	mu     sync.Mutex
+	tokens float64
+	max    float64
+	ratio  float64
+}
+
+func newRetryBudget(max, ratio float64) *retryBudget {
+	return &retryBudget{tokens: max, max: max, ratio: ratio}
+}
+
+func (b *retryBudget) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.tokens+b.ratio, b.max)
+}
+
+func (b *retryBudget) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = max(b.tokens-1, 0)
+}
+
+// remaining returns the tokens left in the bucket.
+func (b *retryBudget) remaining() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens
+}
+
+func (b *retryBudget) canRetry() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens > b.max/2
+}
+
+// retryable reports whether an upstream outcome is worth another attempt.
+func retryable(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return res.StatusCode >= http.StatusInternalServerError
+}
+
+// fetchWithRetry calls fetchUpstream, retrying connection errors and 5xx
+// responses while attempts, the retry budget and the request deadline allow.
+// Backoff included, it never runs past the deadline of ctx.
+// While upstream is paused by a Retry-After no call is made and a
+// *pausedError is returned. While the quota henrikdev reports is low, calls
+// are paced by upstreamQuota.
+func (h *httpMMRClient) fetchWithRetry(ctx context.Context, region, path string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if d := upstreamPause.remaining(); d > 0 {
+			return nil, &pausedError{remaining: d}
+		}
+		// Pace calls while the reported quota is low. A call that cannot
+		// wait its turn within the deadline is refused like a pause.
+		if d, ok := upstreamQuota.delay(ctx, h.cfg.UpstreamQuotaLowWater); d > 0 {
+			if !ok {
+				return nil, &pausedError{remaining: d}
+			}
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		res, err := h.fetchUpstream(ctx, region, path)
+		if err == nil {
+			upstreamQuota.observe(res.Header)
+		}
+		if err == nil && res.StatusCode == http.StatusTooManyRequests {
+			if d, ok := parseRetryAfter(res.Header.Get("Retry-After")); ok {
+				upstreamPause.pauseFor(d)
+			}
+		}
+		if !retryable(res, err) {
+			upstreamRetryBudget.success()
+			if res.StatusCode != http.StatusTooManyRequests {
+				upstreamLastSuccess.Store(time.Now().UnixNano())
+			}
+			return res, err
+		}
+		if ctx.Err() != nil {
+			// The caller gave up; that says nothing about upstream health.
+			return res, err
+		}
+		upstreamRetryBudget.failure()
+
+		// A retry that cannot finish within the request deadline is not
+		// started; the failure in hand is more useful than a timeout.
+		backoff := retryBackoff * time.Duration(attempt+1)
+		if attempt >= maxUpstreamRetries || !upstreamRetryBudget.canRetry() || !hasHeadroom(ctx, backoff+minUpstreamHeadroom) {
+			return res, err
+		}
+		if res != nil {
+			res.Body.Close()
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
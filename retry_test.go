@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryBudget(t *testing.T) {
+	tests := []struct {
+		name       string
+		failures   int
+		successes  int
+		wantRetry  bool
+		wantTokens float64
+	}{
+		{"full", 0, 0, true, 10},
+		{"above half", 4, 0, true, 6},
+		{"at half", 5, 0, false, 5},
+		{"empty", 20, 0, false, 0},
+		{"earning back", 5, 10, true, 6},
+		{"capped at max", 0, 50, true, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newRetryBudget(10, 0.1)
+			for range tt.failures {
+				b.failure()
+			}
+			for range tt.successes {
+				b.success()
+			}
+			if got := b.canRetry(); got != tt.wantRetry {
+				t.Errorf("canRetry() = %v, want %v", got, tt.wantRetry)
+			}
+			if got := b.remaining(); got < tt.wantTokens-0.01 || got > tt.wantTokens+0.01 {
+				t.Errorf("remaining() = %v, want %v", got, tt.wantTokens)
+			}
+		})
+	}
+}
+
+func TestRetriesStopWhenBudgetExhausted(t *testing.T) {
+	setVar(t, &maxUpstreamRetries, 1)
+	setVar(t, &retryBackoff, 0)
+	var calls atomic.Int64
+	cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		writeJSON(w, http.StatusInternalServerError, `{"status":500}`)
+	})
+	h := newHTTPServer(t, cfg).Handler()
+
+	// Each failed call costs one of the 10 tokens and retries need more than
+	// 5 left: the first two requests retry once, the rest make one call.
+	var perRequest []int64
+	for i := range 8 {
+		before := calls.Load()
+		w := serve(h, http.MethodGet, fmt.Sprintf("/rest/v1/rank/eu/p%d/t", i), "")
+		if w.Code < http.StatusInternalServerError {
+			t.Fatalf("request %d: status = %d, want an upstream failure", i, w.Code)
+		}
+		perRequest = append(perRequest, calls.Load()-before)
+	}
+	if want := []int64{2, 2, 1, 1, 1, 1, 1, 1}; !slices.Equal(perRequest, want) {
+		t.Errorf("upstream calls per request = %v, want %v", perRequest, want)
+	}
+	if got := upstreamRetryBudget.remaining(); got != 0 {
+		t.Errorf("budget left = %v, want it drained", got)
+	}
+}
+
+func TestUpstreamAttemptTimeout(t *testing.T) {
+	tests := []struct {
+		name           string
+		attemptTimeout time.Duration
+		budget         time.Duration
+		// hang is how many calls hang before upstream answers.
+		hang      int64
+		wantCode  int
+		wantCalls int64
+		// maxElapsed bounds how long the request takes.
+		maxElapsed time.Duration
+	}{
+		{"hung attempt retried", 100 * time.Millisecond, 2 * time.Second, 1, http.StatusOK, 2, 500 * time.Millisecond},
+		{"no attempt timeout", 0, 400 * time.Millisecond, 1, http.StatusGatewayTimeout, 1, 600 * time.Millisecond},
+		{"budget shorter than an attempt", time.Second, 300 * time.Millisecond, 1, http.StatusGatewayTimeout, 1, 500 * time.Millisecond},
+		{"answer within the attempt timeout", 100 * time.Millisecond, 2 * time.Second, 0, http.StatusOK, 1, 300 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &requestBudget, tt.budget)
+			setVar(t, &minUpstreamHeadroom, 10*time.Millisecond)
+			setVar(t, &maxUpstreamRetries, 2)
+			setVar(t, &retryBackoff, time.Millisecond)
+			var calls atomic.Int64
+			cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) <= tt.hang {
+					<-r.Context().Done()
+					return
+				}
+				writeJSON(w, http.StatusOK, mmrBody)
+			})
+			cfg.UpstreamAttemptTimeout = tt.attemptTimeout
+			h := newHTTPServer(t, cfg).Handler()
+
+			start := time.Now()
+			w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+			elapsed := time.Since(start)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if n := calls.Load(); n != tt.wantCalls {
+				t.Errorf("upstream calls = %d, want %d", n, tt.wantCalls)
+			}
+			if elapsed > tt.maxElapsed {
+				t.Errorf("request took %v, want at most %v", elapsed, tt.maxElapsed)
+			}
+		})
+	}
+}
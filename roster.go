@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// rosterPath is a JSON file mapping team ids to their players, served by
+	// GET /rest/v1/team/:team.
+	rosterPath = os.Getenv("ROSTER_PATH")
+
+	// rosters is swapped whole by loadRoster, at startup and on every
+	// reload. Team ids are lower case.
+	rosters atomic.Pointer[map[string][]batchPlayer]
+)
+
+// loadRoster reads ROSTER_PATH, an object of team id to a list of
+// {"region", "name", "tag"} players. Teams may not be larger than a batch. It
+// is called at startup and on every reload; a roster that fails to load
+// leaves the previous one in place.
+func loadRoster() error {
+	if rosterPath == "" {
+		return nil
+	}
+	b, err := os.ReadFile(rosterPath)
+	if err != nil {
+		return err
+	}
+	var teams map[string][]batchPlayer
+	if err := json.Unmarshal(b, &teams); err != nil {
+		return fmt.Errorf("invalid roster %s: %w", rosterPath, err)
+	}
+
+	loaded := make(map[string][]batchPlayer, len(teams))
+	for team, players := range teams {
+		if len(players) == 0 || len(players) > maxBatchSize {
+			return fmt.Errorf("roster team %q must have between 1 and %d players", team, maxBatchSize)
+		}
+		loaded[strings.ToLower(team)] = players
+	}
+	rosters.Store(&loaded)
+	return nil
+}
+
+// rosterTeams returns the teams of the current roster, or nil without one.
+func rosterTeams() map[string][]batchPlayer {
+	if teams := rosters.Load(); teams != nil {
+		return *teams
+	}
+	return nil
+}
+
+// teamHandler looks up every player of a roster team.
+func (s *Server) teamHandler(c *gin.Context) {
+	players, ok := rosterTeams()[strings.ToLower(c.Param("team"))]
+	if !ok {
+		respondError(c, newAPIError(http.StatusNotFound, codeTeamNotFound, "Unknown team"))
+		return
+	}
+	if !s.batchQuota.allow(clientKey(c), len(players)) {
+		respondError(c, newAPIError(http.StatusTooManyRequests, codeQuotaExceeded, "Batch lookup quota exceeded"))
+		return
+	}
+
+	writeResults(c, s.lookupPlayers(c.Request.Context(), players))
+}
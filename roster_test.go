@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// useRoster loads roster, a JSON object of teams, as the roster for the
+// duration of a test.
+func useRoster(t *testing.T, roster string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "roster.json")
+	if err := os.WriteFile(path, []byte(roster), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	old := rosters.Load()
+	t.Cleanup(func() { rosters.Store(old) })
+	setVar(t, &rosterPath, path)
+	if err := loadRoster(); err != nil {
+		t.Fatalf("loadRoster: %v", err)
+	}
+}
+
+func TestTeamHandler(t *testing.T) {
+	useRoster(t, `{
+		"Sentinels": [
+			{"region": "na", "name": "tenz", "tag": "sen"},
+			{"region": "na", "name": "zekken", "tag": "sen"},
+			{"region": "eu", "name": "ghost", "tag": "404"}
+		]
+	}`)
+	fake := &fakeMMRClient{mmr: func(region, name, tag string) (map[string]interface{}, *apiError) {
+		if name == "ghost" {
+			return fails(http.StatusNotFound)(region, name, tag)
+		}
+		return rankData(24, "Immortal 1", 30, "Radiant"), nil
+	}}
+	h := newFakeServer(t, fake).Handler()
+
+	tests := []struct {
+		name       string
+		team       string
+		wantStatus int
+	}{
+		{"configured team", "sentinels", http.StatusOK},
+		{"case insensitive", "SENTINELS", http.StatusOK},
+		{"unknown team", "fnatic", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, http.MethodGet, "/rest/v1/team/"+tt.team, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if body := decodeBody(t, w.Body.Bytes()); body["code"] != codeTeamNotFound {
+					t.Errorf("code = %v, want %s", body["code"], codeTeamNotFound)
+				}
+				return
+			}
+
+			var body struct{ Results []batchResult }
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, r := range body.Results {
+				names = append(names, r.Name)
+			}
+			if want := []string{"tenz", "zekken", "ghost"}; !slices.Equal(names, want) {
+				t.Fatalf("results for %v, want every member in roster order, %v", names, want)
+			}
+			for _, r := range body.Results[:2] {
+				if r.Rank != "Immortal 1" || r.Error != "" {
+					t.Errorf("%s = %+v, want Immortal 1", r.Name, r)
+				}
+			}
+			if ghost := body.Results[2]; ghost.Error == "" || ghost.Rank != "" {
+				t.Errorf("ghost = %+v, want the lookup error on its own result", ghost)
+			}
+		})
+	}
+}
+
+func TestLoadRosterErrors(t *testing.T) {
+	useRoster(t, `{"sen": [{"region": "na", "name": "tenz", "tag": "sen"}]}`)
+	setVar(t, &maxBatchSize, 2)
+
+	tests := []struct {
+		name    string
+		roster  string
+		wantErr string
+	}{
+		{"not json", `{"sen": [`, "invalid roster"},
+		{"empty team", `{"sen": []}`, "between 1 and 2 players"},
+		{"team too large", `{"sen": [{}, {}, {}]}`, "between 1 and 2 players"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "roster.json")
+			if err := os.WriteFile(path, []byte(tt.roster), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			setVar(t, &rosterPath, path)
+			if err := loadRoster(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadRoster() = %v, want an error containing %q", err, tt.wantErr)
+			}
+			if _, ok := rosterTeams()["sen"]; !ok || len(rosterTeams()) != 1 {
+				t.Errorf("rosterTeams() = %v, want the previous roster kept", rosterTeams())
+			}
+		})
+	}
+}
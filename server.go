@@ -0,0 +1,296 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	sloggin "github.com/samber/slog-gin"
+)
+
+// Server is one configured instance of the API: its Config, cache, upstream
+// client and request handling state. Servers built from different Configs
+// run independently. They do share the process wide upstream pause, retry
+// budget and quota, which describe the health of henrikdev itself, the
+// denylist and rosters, and the tuning knobs each component reads from the
+// environment, such as cache jitter or upstream retries.
+type Server struct {
+	cfg      Config
+	logger   *slog.Logger
+	apiKey   string
+	upstream MMRClient
+
+	regions     map[string]regionInfo
+	regionCache *regionCache
+
+	cache    *memCache
+	stats    cacheStats
+	notFound *negativeCache
+	// refreshing holds the cache keys with a background refresh in flight.
+	refreshing sync.Map
+	flights    *flightGroup
+	background *backgroundGroup
+	hot        *hotKeys
+
+	batchQuota *quota
+	rateLimit  *quota
+	batchSlots semaphore
+	metrics    *metrics
+	errors     *errorLog
+	lookups    *lookupLog
+
+	// now is the server's clock, used for cache lifetimes, quota windows and
+	// the timestamps it reports. It defaults to time.Now and may be replaced
+	// before serving. Latency, request deadlines and the process wide
+	// upstream state keep real time.
+	now func() time.Time
+}
+
+// NewServer builds a Server from cfg that gets its data from upstream. cfg is
+// expected to have come from loadConfig.
+func NewServer(cfg Config, logger *slog.Logger, upstream MMRClient) *Server {
+	s := &Server{
+		cfg:         cfg,
+		logger:      logger,
+		apiKey:      cfg.APIKey,
+		upstream:    upstream,
+		regions:     cfg.Regions,
+		regionCache: newRegionCache(),
+		flights:     newFlightGroup(cfg.CacheCoalesceWindow),
+		background:  newBackgroundGroup(),
+		hot:         newHotKeys(),
+		batchSlots:  newSemaphore(batchConcurrency),
+		metrics:     newMetrics(),
+		now:         time.Now,
+	}
+	// Components read the clock through s so a replaced s.now reaches them.
+	clock := func() time.Time { return s.now() }
+	s.cache = newMemCache(cfg.CacheTTL, clock)
+	s.stats.now = clock
+	s.notFound = newNegativeCache(clock)
+	s.batchQuota = newQuota(batchQuotaLimit, batchQuotaWindow, clock)
+	s.rateLimit = newQuota(cfg.RateLimit, cfg.RateLimitWindow, clock)
+	s.errors = newErrorLog(errorLogSize, clock)
+	s.lookups = newLookupLog(lookupLogSize, cfg.FoldNameCase, clock)
+	return s
+}
+
+func newHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: 100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// HTTPServer returns the http.Server serving Handler on the configured port,
+// with its connection timeouts set.
+func (s *Server) HTTPServer() *http.Server {
+	return &http.Server{
+		Addr:              ":" + s.cfg.Port,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: s.cfg.ReadHeaderTimeout,
+		ReadTimeout:       s.cfg.ReadTimeout,
+		WriteTimeout:      s.cfg.WriteTimeout,
+		IdleTimeout:       s.cfg.IdleTimeout,
+	}
+}
+
+// Handler builds the router with every middleware and route of the server.
+func (s *Server) Handler() http.Handler {
+	r := gin.New()
+	// Route on the decoded path so params reach handlers decoded exactly once.
+	r.UseRawPath = false
+	r.UnescapePathValues = true
+	// Only trusted proxies may name the client, which keys rate limits.
+	if err := r.SetTrustedProxies(s.cfg.TrustedProxies); err != nil {
+		s.logger.Error("Invalid trusted proxies, trusting none", slog.String("error", err.Error()))
+		r.SetTrustedProxies(nil)
+	}
+
+	r.Use(sloggin.New(s.logger))
+	r.Use(gin.Recovery())
+	r.Use(s.metrics.middleware())
+	r.Use(s.errors.middleware())
+	r.Use(slowRequestLog(s.logger, s.cfg.SlowRequestThreshold))
+	r.Use(clientDisconnectLog(s.logger))
+	r.Use(rejectEncodedSlashes())
+	if s.cfg.StrictQuery {
+		r.Use(rejectUnknownQuery(s.cfg.UpstreamQueryAllowlist))
+	}
+	r.Use(requestTimeout(requestBudget))
+	r.Use(requestMemo())
+	if logUpstreamAttempts {
+		r.Use(upstreamAttempts())
+	}
+	r.Use(cacheTenant())
+	if len(s.cfg.UpstreamQueryAllowlist) > 0 {
+		r.Use(upstreamQuery(s.cfg.UpstreamQueryAllowlist))
+	}
+	if s.cfg.SecurityHeaders {
+		r.Use(securityHeaders(s.cfg.ReferrerPolicy, s.cfg.ContentSecurityPolicy))
+	}
+
+	r.GET("/metrics", noStore(), s.metrics.handler)
+
+	// Operational routes only exist when a client key is configured.
+	if s.cfg.ClientAPIKey != "" {
+		ops := r.Group("/", noStore(), clientAuth(s.cfg.ClientAPIKey))
+		ops.GET("/cache/stats", s.cacheStatsHandler)
+		ops.GET("/cache/health", s.cacheHealthHandler)
+		ops.GET("/cache/:region/:name/:tag", s.cacheEntryHandler)
+		ops.POST("/cache/stats/reset", s.cacheStatsResetHandler)
+		ops.GET("/cache/export", s.cacheExportHandler)
+		ops.POST("/cache/import", s.cacheImportHandler)
+		ops.GET("/debug/errors", s.errors.handler)
+		ops.GET("/healthz/detailed", s.detailedHealthHandler)
+	}
+
+	v1 := r.Group("/rest/v1", apiVersion())
+	if s.cfg.RateLimit > 0 {
+		v1.Use(rateLimiter(s.rateLimit, s.cfg.RateLimitMode))
+	}
+	if !s.cfg.V1Sunset.IsZero() {
+		v1.Use(deprecation(s.cfg.V1Sunset))
+	}
+	v1.GET("/regions", cacheFor(s.cfg.CacheTTL), s.regionsHandler)
+	if s.cfg.ClientAPIKey != "" {
+		v1.GET("/recent", noStore(), clientAuth(s.cfg.ClientAPIKey), s.lookups.handler)
+	}
+	v1.POST("/ranks", noStore(), s.batchHandler)
+	v1.POST("/ranks/top", noStore(), s.topHandler)
+	v1.GET("/team/:team", noStore(), s.teamHandler)
+	v1.GET("/leaderboard/:region", cacheFor(leaderboardTTL), s.leaderboardHandler)
+	if s.cfg.DefaultRegion != "" {
+		v1.GET("/rank/:region/:name", s.shortRankHandler)
+	} else {
+		v1.GET("/rank/:region/:name", noStore(), s.pathBatchHandler)
+	}
+	v1.GET("/rank/:region/:name/:tag", cacheFor(s.cfg.CacheTTL), s.rankHandler)
+
+	return r
+}
+
+// shortRankHandler serves the two routes sharing /rank/:region/:name once
+// DEFAULT_REGION is set: the GET batch form, recognised by the # of its
+// name#tag pairs, and /rank/:name/:tag in the default region.
+func (s *Server) shortRankHandler(c *gin.Context) {
+	if strings.Contains(c.Param("name"), "#") {
+		c.Header("Cache-Control", "no-store")
+		s.pathBatchHandler(c)
+		return
+	}
+	c.Params = gin.Params{
+		{Key: "name", Value: c.Param("region")},
+		{Key: "tag", Value: c.Param("name")},
+	}
+	c.Header("Cache-Control", "max-age="+strconv.Itoa(int(s.cfg.CacheTTL.Seconds())))
+	s.rankHandler(c)
+}
+
+// rankHandler serves a single player's rank, with optional extras.
+func (s *Server) rankHandler(c *gin.Context) {
+	start := time.Now()
+
+	region, lerr := s.requestRegion(c)
+	if lerr != nil {
+		respondError(c, lerr)
+		return
+	}
+
+	name, tag, lerr := cleanPlayer(c.Param("name"), c.Param("tag"))
+	if lerr != nil {
+		respondError(c, lerr)
+		return
+	}
+
+	lang, loc, err := requestLocale(c)
+	if err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, codeInvalidLocale, err.Error()))
+		return
+	}
+	c.Header("Content-Language", lang.String())
+	if _, lerr := parseRRFormat(c.Query("rr_format")); lerr != nil {
+		respondError(c, lerr)
+		return
+	}
+
+	ctx := c.Request.Context()
+	extra := gin.H{}
+
+	// Optional extras are fetched alongside the MMR call. Each goroutine
+	// owns its variable; they are merged into extra after Wait.
+	var (
+		wg     sync.WaitGroup
+		level  *int
+		card   *string
+		recent *recentSummary
+		trend  map[string]interface{}
+	)
+	wantLevel := c.Query("level") == "true"
+	wantCard := c.Query("card") == "true"
+	if wantLevel || wantCard {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			account, _ := s.lookupAccount(ctx, region, name, tag)
+			level = accountLevel(account.data)
+			card = accountCard(account.data)
+		}()
+	}
+	wantRecent := c.Query("recent") == "true"
+	wantEstimate := c.Query("estimate") == "true"
+	if wantRecent || wantEstimate {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			history, _ := s.lookupHistory(ctx, region, name, tag)
+			recent = summarizeRecent(history.data, recentGames)
+			trend = history.data
+		}()
+	}
+
+	result, lerr := s.lookupMMR(ctx, region, name, tag)
+	wg.Wait()
+	if wantLevel {
+		extra["account_level"] = level
+	}
+	if wantCard {
+		extra["card_url"] = card
+	}
+	if wantRecent {
+		extra["recent"] = recent
+	}
+	if debugRequested(c) {
+		debug := gin.H{"upstream_url": s.debugUpstreamURL(region, mmrPath(s.cfg, region, name, tag))}
+		extra["debug"] = debug
+		if lerr != nil {
+			lerr = lerr.with("debug", debug)
+		}
+	}
+	if lerr != nil {
+		respondError(c, lerr)
+		return
+	}
+	if wantEstimate {
+		var toNext *int
+		if info, err := parseRank(result.data); err == nil {
+			toNext = rrToNext(info.Tier, info.RR)
+		}
+		extra["estimate"] = estimateRankUp(trend, toNext, estimateGames)
+	}
+	if c.Query("actwins") == "true" {
+		extra["act_wins"] = latestActWins(result.data)
+	}
+	s.lookups.add(region, name, tag)
+	extra["updated_at"] = result.fetchedAt.In(loc).Format(time.RFC3339)
+	s.respondRank(c, result, start, extra)
+}
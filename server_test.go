@@ -0,0 +1,422 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// decodeBody decodes a JSON response body.
+func decodeBody(t *testing.T, body []byte) map[string]interface{} {
+	t.Helper()
+	var m map[string]interface{}
+	if err := json.Unmarshal(body, &m); err != nil {
+		t.Fatalf("decoding %s: %v", body, err)
+	}
+	return m
+}
+
+func TestRankHandlerWithFakeClient(t *testing.T) {
+	tests := []struct {
+		name       string
+		mmr        fakeResult
+		wantStatus int
+		wantCode   string
+		wantMsg    string
+	}{
+		{
+			name:       "success",
+			mmr:        returns(rankData(15, "Platinum 1", 45, "Diamond 2")),
+			wantStatus: http.StatusOK,
+			wantMsg:    "Platinum 1 [45RR] | Peak: Diamond 2",
+		},
+		{
+			name:       "upstream 404",
+			mmr:        fails(http.StatusNotFound),
+			wantStatus: http.StatusNotFound,
+			wantCode:   codePlayerNotFound,
+		},
+		{
+			name:       "upstream 5xx",
+			mmr:        fails(http.StatusServiceUnavailable),
+			wantStatus: http.StatusServiceUnavailable,
+			wantCode:   codeUpstreamError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeMMRClient{mmr: tt.mmr}
+			s := newFakeServer(t, fake)
+
+			w := serve(s.Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			body := decodeBody(t, w.Body.Bytes())
+			if tt.wantCode != "" && body["code"] != tt.wantCode {
+				t.Errorf("code = %v, want %s", body["code"], tt.wantCode)
+			}
+			if tt.wantMsg != "" && body["message"] != tt.wantMsg {
+				t.Errorf("message = %v, want %q", body["message"], tt.wantMsg)
+			}
+			if n := fake.calls.Load(); n != 1 {
+				t.Errorf("upstream calls = %d, want 1", n)
+			}
+		})
+	}
+}
+
+func TestDebugLeavesSharedErrorsAlone(t *testing.T) {
+	// A flight hands every waiter the same error, so the fake does too.
+	shared := newAPIError(http.StatusServiceUnavailable, codeUpstreamError, "API returned status code: 503")
+	fake := &fakeMMRClient{mmr: func(string, string, string) (map[string]interface{}, *apiError) { return nil, shared }}
+	s := newFakeServer(t, fake)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := serve(s.Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar?debug=true", "")
+			var body map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body["debug"] == nil {
+				t.Errorf("response %s has no debug details", w.Body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, ok := shared.body["debug"]; ok {
+		t.Errorf("shared error body was modified: %v", shared.body)
+	}
+}
+
+func TestServersAreIndependent(t *testing.T) {
+	setVar(t, &cacheTTLJitter, 0)
+	var (
+		mu sync.Mutex
+		// last is the latest upstream path requested through each X-Proxy.
+		last = map[string]string{}
+	)
+	base := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		last[r.Header.Get("X-Proxy")] = r.URL.Path
+		mu.Unlock()
+		if strings.HasPrefix(r.URL.Path, "/valorant/v3/") {
+			writeJSON(w, http.StatusOK, mmrV3Body)
+			return
+		}
+		writeJSON(w, http.StatusOK, mmrBody)
+	})
+
+	cfgA := base
+	cfgA.Regions = map[string]regionInfo{"eu": defaultRegions["eu"]}
+	cfgA.CacheTTL = time.Minute
+	cfgA.StrictQuery = true
+	cfgA.RateLimit, cfgA.RateLimitWindow, cfgA.RateLimitMode = 3, time.Minute, "all"
+	cfgA.UpstreamHeaders = http.Header{"X-Proxy": {"a"}}
+
+	cfgB := base
+	cfgB.CacheTTL = 10 * time.Minute
+	cfgB.StrictQuery = false
+	cfgB.RateLimit = 0
+	cfgB.MMRVersion, cfgB.MMRPath = "v3", newMMRTemplate("v3")
+	cfgB.FoldNameCase = true
+	cfgB.UpstreamHeaders = http.Header{"X-Proxy": {"b"}}
+
+	a, b := newHTTPServer(t, cfgA).Handler(), newHTTPServer(t, cfgB).Handler()
+	const rank = "Platinum 1 [45RR] | Peak: Diamond 2"
+
+	tests := []struct {
+		name       string
+		h          http.Handler
+		target     string
+		wantStatus int
+		wantCache  string
+		wantMaxAge string
+	}{
+		{"a fetches", a, "/rest/v1/rank/eu/foo/bar", http.StatusOK, cacheMiss, "max-age=60"},
+		{"a caches", a, "/rest/v1/rank/eu/foo/bar", http.StatusOK, cacheHit, ""},
+		{"b has its own cache", b, "/rest/v1/rank/eu/foo/bar", http.StatusOK, cacheMiss, "max-age=600"},
+		{"a only knows eu", a, "/rest/v1/rank/kr/foo/bar", http.StatusBadRequest, "", ""},
+		{"b knows kr", b, "/rest/v1/rank/kr/foo/bar", http.StatusOK, cacheMiss, ""},
+		{"a is strict", a, "/rest/v1/rank/eu/foo/bar?formt=text", http.StatusBadRequest, "", ""},
+		{"b is not", b, "/rest/v1/rank/eu/foo/bar?formt=text", http.StatusOK, cacheHit, ""},
+		{"a is rate limited", a, "/rest/v1/rank/eu/foo/bar", http.StatusTooManyRequests, "", ""},
+		{"b is not rate limited", b, "/rest/v1/rank/eu/foo/bar", http.StatusOK, cacheHit, ""},
+		{"b folds name case", b, "/rest/v1/rank/eu/FOO/Bar", http.StatusOK, cacheHit, ""},
+	}
+	for _, tt := range tests {
+		w := serve(tt.h, http.MethodGet, tt.target, "")
+		if w.Code != tt.wantStatus {
+			t.Fatalf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.wantStatus, w.Body)
+		}
+		if tt.wantCache != "" && w.Header().Get("X-Cache") != tt.wantCache {
+			t.Errorf("%s: X-Cache = %q, want %q", tt.name, w.Header().Get("X-Cache"), tt.wantCache)
+		}
+		if tt.wantMaxAge != "" && w.Header().Get("Cache-Control") != tt.wantMaxAge {
+			t.Errorf("%s: Cache-Control = %q, want %q", tt.name, w.Header().Get("Cache-Control"), tt.wantMaxAge)
+		}
+		if w.Code == http.StatusOK {
+			if msg := decodeBody(t, w.Body.Bytes())["message"]; msg != rank {
+				t.Errorf("%s: message = %v, want %q", tt.name, msg, rank)
+			}
+		}
+	}
+
+	want := map[string]string{
+		"a": "/valorant/v2/mmr/eu/foo/bar",
+		"b": "/valorant/v3/mmr/kr/pc/foo/bar",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !maps.Equal(last, want) {
+		t.Errorf("latest upstream paths by X-Proxy = %v, want %v", last, want)
+	}
+}
+
+func TestRankHandlerAccountLevel(t *testing.T) {
+	var accountCalls atomic.Int64
+	fake := &fakeMMRClient{
+		mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2")),
+		account: func(region, name, tag string) (map[string]interface{}, *apiError) {
+			accountCalls.Add(1)
+			if name == "private" {
+				return fails(http.StatusNotFound)(region, name, tag)
+			}
+			return map[string]interface{}{"account_level": float64(42)}, nil
+		},
+	}
+	h := newFakeServer(t, fake).Handler()
+
+	tests := []struct {
+		name      string
+		target    string
+		wantLevel interface{}
+		wantKey   bool
+		// wantCalls is the total of account lookups made so far.
+		wantCalls int64
+	}{
+		{"not requested", "/rest/v1/rank/eu/foo/bar", nil, false, 0},
+		{"requested", "/rest/v1/rank/eu/foo/bar?level=true", float64(42), true, 1},
+		{"served from the account cache", "/rest/v1/rank/eu/foo/bar?level=true", float64(42), true, 1},
+		{"not requested again", "/rest/v1/rank/eu/foo/bar?level=false", nil, false, 1},
+		{"account unavailable", "/rest/v1/rank/eu/private/bar?level=true", nil, true, 2},
+	}
+	for _, tt := range tests {
+		w := serve(h, http.MethodGet, tt.target, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200: %s", tt.name, w.Code, w.Body)
+		}
+		body := decodeBody(t, w.Body.Bytes())
+		level, ok := body["account_level"]
+		if ok != tt.wantKey || level != tt.wantLevel {
+			t.Errorf("%s: account_level = %v (present %v), want %v (present %v)", tt.name, level, ok, tt.wantLevel, tt.wantKey)
+		}
+		if n := accountCalls.Load(); n != tt.wantCalls {
+			t.Errorf("%s: account lookups = %d, want %d", tt.name, n, tt.wantCalls)
+		}
+	}
+}
+
+func TestDebugUpstreamURL(t *testing.T) {
+	tests := []struct {
+		mode      string
+		mmr       fakeResult
+		wantDebug bool
+	}{
+		{gin.DebugMode, returns(rankData(15, "Platinum 1", 45, "Diamond 2")), true},
+		{gin.DebugMode, fails(http.StatusServiceUnavailable), true},
+		{gin.TestMode, returns(rankData(15, "Platinum 1", 45, "Diamond 2")), true},
+		{gin.ReleaseMode, returns(rankData(15, "Platinum 1", 45, "Diamond 2")), false},
+		{gin.ReleaseMode, fails(http.StatusServiceUnavailable), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			gin.SetMode(tt.mode)
+			t.Cleanup(func() { gin.SetMode(gin.TestMode) })
+			// Debug mode prints every route as it is registered.
+			setVar(t, &gin.DefaultWriter, io.Discard)
+			cfg := testConfig(t, "https://upstream.example")
+			cfg.APIKey = "secret-key"
+			s := NewServer(cfg, discardLogger(), &fakeMMRClient{mmr: tt.mmr})
+
+			w := serve(s.Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar?debug=true", "")
+			if strings.Contains(w.Body.String(), "secret-key") {
+				t.Fatalf("response leaks the api key: %s", w.Body)
+			}
+			debug, ok := decodeBody(t, w.Body.Bytes())["debug"].(map[string]interface{})
+			if !tt.wantDebug {
+				if ok || strings.Contains(w.Body.String(), "upstream_url") {
+					t.Errorf("release mode response has debug details: %s", w.Body)
+				}
+				return
+			}
+			if want := "https://upstream.example/valorant/v2/mmr/eu/foo/bar?api_key=[REDACTED]"; debug["upstream_url"] != want {
+				t.Errorf("upstream_url = %v, want %s", debug["upstream_url"], want)
+			}
+		})
+	}
+}
+
+func TestRankHandlerCard(t *testing.T) {
+	const wide = "https://media.valorant-api.com/playercards/abc/wideart.png"
+	var accountCalls atomic.Int64
+	fake := &fakeMMRClient{
+		mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2")),
+		account: func(region, name, tag string) (map[string]interface{}, *apiError) {
+			accountCalls.Add(1)
+			switch name {
+			case "private":
+				return fails(http.StatusNotFound)(region, name, tag)
+			case "nocard":
+				return map[string]interface{}{"account_level": float64(42)}, nil
+			}
+			return map[string]interface{}{
+				"account_level": float64(42),
+				"card":          map[string]interface{}{"small": "small.png", "wide": wide},
+			}, nil
+		},
+	}
+	h := newFakeServer(t, fake).Handler()
+
+	tests := []struct {
+		name     string
+		target   string
+		wantCard interface{}
+		wantKey  bool
+		// wantCalls is the total of account lookups made so far.
+		wantCalls int64
+	}{
+		{"not requested", "/rest/v1/rank/eu/foo/bar", nil, false, 0},
+		{"requested", "/rest/v1/rank/eu/foo/bar?card=true", wide, true, 1},
+		{"with the level", "/rest/v1/rank/eu/foo/bar?card=true&level=true", wide, true, 1},
+		{"no card", "/rest/v1/rank/eu/nocard/bar?card=true", nil, true, 2},
+		{"account unavailable", "/rest/v1/rank/eu/private/bar?card=true", nil, true, 3},
+	}
+	for _, tt := range tests {
+		w := serve(h, http.MethodGet, tt.target, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200: %s", tt.name, w.Code, w.Body)
+		}
+		card, ok := decodeBody(t, w.Body.Bytes())["card_url"]
+		if ok != tt.wantKey || card != tt.wantCard {
+			t.Errorf("%s: card_url = %v (present %v), want %v (present %v)", tt.name, card, ok, tt.wantCard, tt.wantKey)
+		}
+		if n := accountCalls.Load(); n != tt.wantCalls {
+			t.Errorf("%s: account lookups = %d, want %d", tt.name, n, tt.wantCalls)
+		}
+	}
+}
+
+func TestHTTPServerTimeouts(t *testing.T) {
+	// limits are the address and connection timeouts of an http.Server.
+	type limits struct {
+		addr                          string
+		readHeader, read, write, idle time.Duration
+	}
+	tests := []struct {
+		name string
+		env  map[string]string
+		want limits
+	}{
+		{"defaults", nil, limits{":8080", 5 * time.Second, 15 * time.Second, 30 * time.Second, 2 * time.Minute}},
+		{"configured", map[string]string{
+			"PORT":                       "9000",
+			"SERVER_READ_HEADER_TIMEOUT": "1s",
+			"SERVER_READ_TIMEOUT":        "2s",
+			"SERVER_WRITE_TIMEOUT":       "45s",
+			"SERVER_IDLE_TIMEOUT":        "5m",
+		}, limits{":9000", time.Second, 2 * time.Second, 45 * time.Second, 5 * time.Minute}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			srv := NewServer(testConfig(t, "http://upstream.invalid"), discardLogger(), &fakeMMRClient{}).HTTPServer()
+			got := limits{srv.Addr, srv.ReadHeaderTimeout, srv.ReadTimeout, srv.WriteTimeout, srv.IdleTimeout}
+			if got != tt.want {
+				t.Errorf("HTTPServer() = %+v, want %+v", got, tt.want)
+			}
+			if srv.Handler == nil {
+				t.Error("HTTPServer() has no handler")
+			}
+		})
+	}
+}
+
+func TestSlowHeadersAreCutOff(t *testing.T) {
+	cfg := testConfig(t, "http://upstream.invalid")
+	cfg.ReadHeaderTimeout = 100 * time.Millisecond
+	srv := NewServer(cfg, discardLogger(), &fakeMMRClient{}).HTTPServer()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Headers that never finish are what a slowloris client sends.
+	io.WriteString(conn, "GET /rest/v1/regions HTTP/1.1\r\nHost: localhost\r\n")
+
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	io.Copy(io.Discard, conn)
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("connection held for %v, want it closed after the 100ms header timeout", d)
+	}
+}
+
+func TestServerClockExpiresEntries(t *testing.T) {
+	tests := []struct {
+		name      string
+		staleTTL  time.Duration
+		age       time.Duration
+		wantCache string
+		wantCalls int64
+	}{
+		{"fresh", 0, defaultCacheTTL - time.Second, cacheHit, 1},
+		{"expired", 0, defaultCacheTTL, cacheMiss, 2},
+		{"within the stale window", time.Minute, defaultCacheTTL + 30*time.Second, cacheStale, 2},
+		{"past the stale window", time.Minute, defaultCacheTTL + time.Minute, cacheMiss, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &cacheTTLJitter, 0)
+			setVar(t, &staleTTL, tt.staleTTL)
+			fake := &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))}
+			s := newFakeServer(t, fake)
+			clock := newFakeClock()
+			s.now = clock.now
+			h := s.Handler()
+
+			if w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", ""); w.Header().Get("X-Cache") != cacheMiss {
+				t.Fatalf("first lookup X-Cache = %q, want %s", w.Header().Get("X-Cache"), cacheMiss)
+			}
+			clock.advance(tt.age)
+			w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+			s.background.wg.Wait()
+			if got := w.Header().Get("X-Cache"); w.Code != http.StatusOK || got != tt.wantCache {
+				t.Errorf("after %v: status %d, X-Cache = %q, want 200 %s", tt.age, w.Code, got, tt.wantCache)
+			}
+			if n := fake.calls.Load(); n != tt.wantCalls {
+				t.Errorf("upstream calls = %d, want %d", n, tt.wantCalls)
+			}
+		})
+	}
+}
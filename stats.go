@@ -0,0 +1,190 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cacheCounters is a point in time copy of the cache statistics. Misses are
+// cold misses of keys not cached recently; misses of keys evicted within
+// CACHE_TOMBSTONE_TTL are Refetches.
+type cacheCounters struct {
+	Hits      uint64 `json:"hits"`
+	Stale     uint64 `json:"stale"`
+	Misses    uint64 `json:"misses"`
+	Refetches uint64 `json:"refetches"`
+	Evictions uint64 `json:"evictions"`
+}
+
+var (
+	// cacheHealthMinRatio is the hit ratio below which GET /cache/health
+	// reports the cache as unhealthy.
+	cacheHealthMinRatio = envFloat("CACHE_HEALTH_MIN_HIT_RATIO", 0.5)
+	// cacheHealthWindow is how far back GET /cache/health looks.
+	cacheHealthWindow = envDuration("CACHE_HEALTH_WINDOW", 5*time.Minute)
+)
+
+// hitWindowBuckets is how many slices cacheHealthWindow is counted in. The
+// window slides one slice at a time.
+const hitWindowBuckets = 60
+
+// hitBucket counts the lookups of one slice of the window. slot identifies
+// the slice, so a bucket left over from an earlier lap is recognised.
+type hitBucket struct {
+	slot   int64
+	hits   uint64
+	misses uint64
+}
+
+// hitWindow counts cache hits and misses over the last cacheHealthWindow.
+type hitWindow [hitWindowBuckets]hitBucket
+
+func hitSlot(now time.Time) int64 {
+	return now.UnixNano() / max(int64(cacheHealthWindow/hitWindowBuckets), 1)
+}
+
+func (w *hitWindow) record(now time.Time, hit bool) {
+	slot := hitSlot(now)
+	b := &w[slot%hitWindowBuckets]
+	if b.slot != slot {
+		*b = hitBucket{slot: slot}
+	}
+	if hit {
+		b.hits++
+	} else {
+		b.misses++
+	}
+}
+
+// totals sums the buckets still inside the window.
+func (w *hitWindow) totals(now time.Time) (hits, misses uint64) {
+	slot := hitSlot(now)
+	for _, b := range w {
+		if b.slot > slot-hitWindowBuckets {
+			hits += b.hits
+			misses += b.misses
+		}
+	}
+	return hits, misses
+}
+
+// cacheStats counts cache outcomes. A mutex rather than independent atomics
+// keeps the counters consistent with each other across a reset. recent
+// counts the same outcomes over a sliding window and is not reset.
+type cacheStats struct {
+	mu       sync.Mutex
+	counters cacheCounters
+	recent   hitWindow
+	now      func() time.Time
+}
+
+func (s *cacheStats) hit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters.Hits++
+	s.recent.record(s.now(), true)
+}
+
+func (s *cacheStats) stale() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters.Stale++
+	s.recent.record(s.now(), true)
+}
+
+func (s *cacheStats) miss() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters.Misses++
+	s.recent.record(s.now(), false)
+}
+
+func (s *cacheStats) refetch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters.Refetches++
+	s.recent.record(s.now(), false)
+}
+
+func (s *cacheStats) evicted(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters.Evictions += uint64(n)
+}
+
+func (s *cacheStats) snapshot() cacheCounters {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counters
+}
+
+// recentTotals returns the hits, stale hits included, and misses of the last
+// cacheHealthWindow.
+func (s *cacheStats) recentTotals() (hits, misses uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.recent.totals(s.now())
+}
+
+// reset zeroes the counters and returns their previous values.
+func (s *cacheStats) reset() cacheCounters {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.counters
+	s.counters = cacheCounters{}
+	return prev
+}
+
+// countMiss counts a cache miss of key as a refetch when key was evicted
+// recently and as a cold miss otherwise.
+func (s *Server) countMiss(key string) {
+	if s.cache.evictedRecently(key) {
+		s.stats.refetch()
+		return
+	}
+	s.stats.miss()
+}
+
+// cacheStatsHandler reports the cache counters and current entry count.
+func (s *Server) cacheStatsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"counters": s.stats.snapshot(),
+		"entries":  s.cache.len(),
+	})
+}
+
+// cacheStatsResetHandler zeroes the counters, leaving cached entries alone,
+// and returns the values from before the reset.
+func (s *Server) cacheStatsResetHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"previous": s.stats.reset(),
+	})
+}
+
+// cacheHealthHandler answers 200 while the hit ratio of the last
+// CACHE_HEALTH_WINDOW is at least CACHE_HEALTH_MIN_HIT_RATIO and 503 once it
+// drops below, for alerting on cache degradation. A window without lookups
+// is healthy, having nothing to judge.
+func (s *Server) cacheHealthHandler(c *gin.Context) {
+	hits, misses := s.stats.recentTotals()
+	ratio := 1.0
+	if total := hits + misses; total > 0 {
+		ratio = float64(hits) / float64(total)
+	}
+
+	status, health := http.StatusOK, healthOK
+	if ratio < cacheHealthMinRatio {
+		status, health = http.StatusServiceUnavailable, healthDegraded
+	}
+	c.JSON(status, gin.H{
+		"status":    health,
+		"hit_ratio": ratio,
+		"threshold": cacheHealthMinRatio,
+		"hits":      hits,
+		"misses":    misses,
+		"window":    cacheHealthWindow.String(),
+	})
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// opsServer is a fake backed Server with the operational routes enabled
+// under the client key "ops".
+func opsServer(t *testing.T, fake *fakeMMRClient) *Server {
+	t.Helper()
+	cfg := testConfig(t, "http://upstream.invalid")
+	cfg.ClientAPIKey = "ops"
+	return NewServer(cfg, discardLogger(), fake)
+}
+
+func TestCacheStatsReset(t *testing.T) {
+	s := opsServer(t, &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))})
+	h := s.Handler()
+	for range 3 {
+		serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+	}
+
+	if w := serve(h, http.MethodPost, "/cache/stats/reset", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("reset without credentials = %d, want 401", w.Code)
+	}
+	w := serve(h, http.MethodPost, "/cache/stats/reset", "", "Authorization", "Bearer ops")
+	var reset struct{ Previous cacheCounters }
+	if err := json.Unmarshal(w.Body.Bytes(), &reset); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("reset = %d %s: %v", w.Code, w.Body, err)
+	}
+	if want := (cacheCounters{Hits: 2, Misses: 1}); reset.Previous != want {
+		t.Errorf("previous = %+v, want %+v", reset.Previous, want)
+	}
+
+	w = serve(h, http.MethodGet, "/cache/stats", "", "Authorization", "Bearer ops")
+	var stats struct {
+		Counters cacheCounters
+		Entries  int
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Counters != (cacheCounters{}) || stats.Entries != 1 {
+		t.Errorf("stats after reset = %+v, want zero counters and the entry kept", stats)
+	}
+	if w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", ""); w.Header().Get("X-Cache") != cacheHit {
+		t.Errorf("X-Cache after reset = %q, want the entry still cached", w.Header().Get("X-Cache"))
+	}
+}
+
+func TestCacheStatsResetConcurrent(t *testing.T) {
+	stats := cacheStats{now: newFakeClock().now}
+	const writers, hits = 4, 500
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		counted uint64
+	)
+	for range writers {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for range hits {
+				stats.hit()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				prev := stats.reset()
+				mu.Lock()
+				counted += prev.Hits
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every hit is reported by exactly one reset or is still counted.
+	if total := counted + stats.snapshot().Hits; total != writers*hits {
+		t.Errorf("hits seen = %d, want %d", total, writers*hits)
+	}
+}
+
+func TestCacheHealth(t *testing.T) {
+	setVar(t, &cacheHealthMinRatio, 0.5)
+	setVar(t, &cacheHealthWindow, 5*time.Minute)
+	s := opsServer(t, &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))})
+	clock := newFakeClock()
+	s.now = clock.now
+	h := s.Handler()
+	lookup := func(players ...string) {
+		for _, p := range players {
+			serve(h, http.MethodGet, "/rest/v1/rank/eu/"+p+"/t", "")
+		}
+	}
+
+	steps := []struct {
+		name string
+		// run makes the lookups of the step.
+		run        func()
+		wantStatus int
+		wantHits   uint64
+		wantMisses uint64
+	}{
+		{"no lookups yet", func() {}, http.StatusOK, 0, 0},
+		{"a miss", func() { lookup("a") }, http.StatusServiceUnavailable, 0, 1},
+		{"hits bring it up", func() { lookup("a", "a", "a") }, http.StatusOK, 3, 1},
+		{"exactly at the threshold", func() { lookup("b", "c") }, http.StatusOK, 3, 3},
+		{"misses drag it down", func() { lookup("d") }, http.StatusServiceUnavailable, 3, 4},
+		{"the window moves on", func() { clock.advance(6 * time.Minute) }, http.StatusOK, 0, 0},
+		{"only recent lookups count", func() { lookup("a", "a", "a") }, http.StatusOK, 2, 1},
+	}
+	for _, st := range steps {
+		st.run()
+		if w := serve(h, http.MethodGet, "/cache/health", ""); w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: without credentials = %d, want 401", st.name, w.Code)
+		}
+		w := serve(h, http.MethodGet, "/cache/health", "", "Authorization", "Bearer ops")
+		if w.Code != st.wantStatus {
+			t.Errorf("%s: status = %d, want %d: %s", st.name, w.Code, st.wantStatus, w.Body)
+		}
+		var body struct {
+			Status       string
+			Hits, Misses uint64
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		wantHealth := healthOK
+		if st.wantStatus != http.StatusOK {
+			wantHealth = healthDegraded
+		}
+		if body.Status != wantHealth || body.Hits != st.wantHits || body.Misses != st.wantMisses {
+			t.Errorf("%s: %s with %d hits, %d misses, want %s with %d, %d", st.name, body.Status, body.Hits, body.Misses, wantHealth, st.wantHits, st.wantMisses)
+		}
+	}
+}
+
+func TestTombstoneRefetches(t *testing.T) {
+	tests := []struct {
+		name         string
+		tombstoneTTL time.Duration
+		// sweep evicts expired entries before the second lookup, which comes
+		// after since more time has passed.
+		sweep         bool
+		since         time.Duration
+		wantMisses    uint64
+		wantRefetches uint64
+	}{
+		{"expired, not yet swept", 10 * time.Minute, false, 0, 2, 1},
+		{"evicted recently", 10 * time.Minute, true, time.Minute, 2, 1},
+		{"tombstone expired", 10 * time.Minute, true, 10 * time.Minute, 3, 0},
+		{"tombstones disabled", 0, true, 0, 3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &tombstoneTTL, tt.tombstoneTTL)
+			setVar(t, &cacheTTLJitter, 0)
+			fake := &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))}
+			s := newFakeServer(t, fake)
+			clock := newFakeClock()
+			s.now = clock.now
+			h := s.Handler()
+
+			serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+			serve(h, http.MethodGet, "/rest/v1/rank/eu/other/bar", "")
+			clock.advance(defaultCacheTTL + time.Second)
+			if tt.sweep {
+				if n := s.cache.evictExpired(); n != 2 {
+					t.Fatalf("evicted %d entries, want 2", n)
+				}
+			}
+			clock.advance(tt.since)
+			if w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", ""); w.Header().Get("X-Cache") != cacheMiss {
+				t.Fatalf("X-Cache = %q, want a %s", w.Header().Get("X-Cache"), cacheMiss)
+			}
+
+			// The first two lookups are always cold misses.
+			got := s.stats.snapshot()
+			if got.Misses != tt.wantMisses || got.Refetches != tt.wantRefetches {
+				t.Errorf("misses %d, refetches %d, want %d and %d", got.Misses, got.Refetches, tt.wantMisses, tt.wantRefetches)
+			}
+			if n := fake.calls.Load(); n != 3 {
+				t.Errorf("upstream calls = %d, want 3 either way", n)
+			}
+		})
+	}
+}
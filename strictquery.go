@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// knownQueryParams are the query parameters some route reads. Parameters in
+// UPSTREAM_QUERY_ALLOWLIST are known too.
+var knownQueryParams = map[string]bool{
+	"actwins":   true,
+	"card":      true,
+	"data":      true,
+	"debug":     true,
+	"estimate":  true,
+	"format":    true,
+	"lang":      true,
+	"latency":   true,
+	"level":     true,
+	"min_rr":    true,
+	"min_tier":  true,
+	"progress":  true,
+	"recent":    true,
+	"region":    true,
+	"rr_format": true,
+	"tz":        true,
+}
+
+// rejectUnknownQuery refuses requests carrying query parameters outside
+// knownQueryParams and passthrough, listing them under "unknown".
+func rejectUnknownQuery(passthrough map[string]bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var unknown []string
+		for name := range c.Request.URL.Query() {
+			if !knownQueryParams[name] && !passthrough[name] {
+				unknown = append(unknown, name)
+			}
+		}
+		if len(unknown) > 0 {
+			slices.Sort(unknown)
+			lerr := newAPIError(http.StatusBadRequest, codeUnknownQuery, "Unknown query parameters: "+strings.Join(unknown, ", "))
+			lerr.body["unknown"] = unknown
+			respondError(c, lerr)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestStrictQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		strict    bool
+		allowlist string
+		query     string
+		// wantUnknown are the rejected parameters; nil means the request is
+		// served.
+		wantUnknown []string
+	}{
+		{"typo rejected", true, "", "?formt=json", []string{"formt"}},
+		{"all unknown listed sorted", true, "", "?zz=1&format=json&aa=2", []string{"aa", "zz"}},
+		{"known accepted", true, "", "?format=json&progress=true&rr_format=float", nil},
+		{"no query", true, "", "", nil},
+		{"upstream allowlist is known", true, "platform", "?platform=pc", nil},
+		{"lenient ignores typos", false, "", "?formt=json", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))}
+			cfg := testConfig(t, "http://upstream.invalid")
+			cfg.StrictQuery = tt.strict
+			cfg.UpstreamQueryAllowlist = parseQueryAllowlist(tt.allowlist)
+			h := NewServer(cfg, discardLogger(), fake).Handler()
+
+			w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar"+tt.query, "")
+			if tt.wantUnknown == nil {
+				if w.Code != http.StatusOK {
+					t.Errorf("status = %d, want 200: %s", w.Code, w.Body)
+				}
+				return
+			}
+			var body struct {
+				Code    string
+				Unknown []string
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d %s, want 400: %v", w.Code, w.Body, err)
+			}
+			if body.Code != codeUnknownQuery || !slices.Equal(body.Unknown, tt.wantUnknown) {
+				t.Errorf("code %q, unknown %q, want %s and %q", body.Code, body.Unknown, codeUnknownQuery, tt.wantUnknown)
+			}
+			if n := fake.calls.Load(); n != 0 {
+				t.Errorf("upstream calls = %d, want a rejected request to make none", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+type tenantKey struct{}
+
+// tenantPattern limits tenant names so they are safe to embed in cache keys.
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// cacheTenant reads the optional X-Cache-Tenant header into the request
+// context. Tenants get cache entries isolated from each other and from the
+// shared, tenant-less entries.
+func cacheTenant() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant := c.GetHeader("X-Cache-Tenant")
+		if tenant == "" {
+			c.Next()
+			return
+		}
+		if !tenantPattern.MatchString(tenant) {
+			respondError(c, newAPIError(http.StatusBadRequest, codeInvalidTenant, "Invalid X-Cache-Tenant header"))
+			c.Abort()
+			return
+		}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), tenantKey{}, tenant))
+		c.Next()
+	}
+}
+
+// tenantCacheKey scopes key to the tenant carried by ctx, if any.
+func tenantCacheKey(ctx context.Context, key string) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+		return "tenant:" + tenant + "|" + key
+	}
+	return key
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCacheTenants(t *testing.T) {
+	fake := &fakeMMRClient{mmr: returns(rankData(15, "Platinum 1", 45, "Diamond 2"))}
+	h := newFakeServer(t, fake).Handler()
+
+	tests := []struct {
+		name       string
+		tenant     string
+		wantStatus int
+		wantCache  string
+		// wantCalls is the total of upstream calls made so far.
+		wantCalls int64
+	}{
+		{"shared miss", "", http.StatusOK, cacheMiss, 1},
+		{"shared hit", "", http.StatusOK, cacheHit, 1},
+		{"first tenant misses", "acme", http.StatusOK, cacheMiss, 2},
+		{"first tenant hits", "acme", http.StatusOK, cacheHit, 2},
+		{"second tenant misses", "globex", http.StatusOK, cacheMiss, 3},
+		{"second tenant hits", "globex", http.StatusOK, cacheHit, 3},
+		{"shared entry still there", "", http.StatusOK, cacheHit, 3},
+		{"invalid tenant", "not a tenant!", http.StatusBadRequest, "", 3},
+	}
+	for _, tt := range tests {
+		var header []string
+		if tt.tenant != "" {
+			header = []string{"X-Cache-Tenant", tt.tenant}
+		}
+		w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar", "", header...)
+		if w.Code != tt.wantStatus {
+			t.Fatalf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.wantStatus, w.Body)
+		}
+		if got := w.Header().Get("X-Cache"); got != tt.wantCache {
+			t.Errorf("%s: X-Cache = %q, want %q", tt.name, got, tt.wantCache)
+		}
+		if n := fake.calls.Load(); n != tt.wantCalls {
+			t.Errorf("%s: upstream calls = %d, want %d", tt.name, n, tt.wantCalls)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// forwardUpstreamErrors enables passing a sanitized copy of henrikdev's error
+// message back to clients as "upstream_message". Off by default so production
+// responses stay terse.
+var forwardUpstreamErrors = os.Getenv("FORWARD_UPSTREAM_ERRORS") == "true"
+
+const (
+	maxUpstreamErrorBody    = 4 << 10
+	maxUpstreamErrorMessage = 200
+)
+
+// baseURLFor returns the upstream base URL to use for region.
+func baseURLFor(cfg Config, region string) string {
+	return cmp.Or(cfg.RegionBaseURLs[region], cfg.UpstreamBaseURL)
+}
+
+// upstreamURL is the full upstream URL for path under base.
+func upstreamURL(base, path, apiKey string) string {
+	return base + path + "?api_key=" + url.QueryEscape(apiKey)
+}
+
+// debugUpstreamURL is the primary upstream URL with the api key replaced by
+// a placeholder.
+func (s *Server) debugUpstreamURL(region, path string) string {
+	return baseURLFor(s.cfg, region) + path + "?api_key=[REDACTED]"
+}
+
+// debugRequested reports whether the client asked for ?debug=true output. It
+// is never honoured in release mode.
+func debugRequested(c *gin.Context) bool {
+	return gin.Mode() != gin.ReleaseMode && c.Query("debug") == "true"
+}
+
+// fetchUpstream requests path from the henrikdev base URL for region, failing
+// over to UPSTREAM_FALLBACK_URL when configured. The primary is then only given
+// half of the remaining deadline so the fallback still has time to answer.
+// The caller owns the returned response body.
+func (h *httpMMRClient) fetchUpstream(ctx context.Context, region, path string) (*http.Response, error) {
+	if h.cfg.UpstreamFallbackURL == "" {
+		return h.fetchFrom(ctx, baseURLFor(h.cfg, region), region, path)
+	}
+
+	var (
+		primaryCtx context.Context
+		cancel     context.CancelFunc
+	)
+	if deadline, ok := ctx.Deadline(); ok {
+		primaryCtx, cancel = context.WithTimeout(ctx, time.Until(deadline)/2)
+	} else {
+		primaryCtx, cancel = context.WithCancel(ctx)
+	}
+	res, err := h.fetchFrom(primaryCtx, baseURLFor(h.cfg, region), region, path)
+	if !retryable(res, err) || ctx.Err() != nil || !hasHeadroom(ctx, minUpstreamHeadroom) {
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		res.Body = cancelBody{ReadCloser: res.Body, cancel: cancel}
+		return res, nil
+	}
+	if res != nil {
+		res.Body.Close()
+	}
+	cancel()
+	return h.fetchFrom(ctx, h.cfg.UpstreamFallbackURL, region, path)
+}
+
+// fetchFrom requests path, for region, from the upstream at base, adding the
+// passthrough query parameters carried by ctx. The call, body included, is
+// bounded by UpstreamAttemptTimeout when set.
+func (h *httpMMRClient) fetchFrom(ctx context.Context, base, region, path string) (*http.Response, error) {
+	target := upstreamURL(base, path, h.apiKey)
+	if query := upstreamQueryFrom(ctx); query != nil {
+		target += "&" + query.Encode()
+	}
+
+	cancel := context.CancelFunc(func() {})
+	if h.cfg.UpstreamAttemptTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, h.cfg.UpstreamAttemptTimeout)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	addUpstreamHeaders(req, h.cfg.UpstreamHeaders)
+	start := time.Now()
+	res, err := h.client.Do(req)
+	h.logAttempt(ctx, base, region, res, err, time.Since(start))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	res.Body = cancelBody{ReadCloser: res.Body, cancel: cancel}
+	if err := decompressBody(res); err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	return res, nil
+}
+
+// cancelBody releases a per-attempt context once its body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// gzipBody closes both the gzip reader and the underlying body.
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b gzipBody) Close() error {
+	return errors.Join(b.Reader.Close(), b.body.Close())
+}
+
+// decompressBody transparently unwraps gzip encoded bodies. The default
+// transport already does this when it negotiated compression itself, but a
+// custom transport or an intermediary proxy may hand us the raw encoding.
+func decompressBody(res *http.Response) error {
+	if res.Uncompressed || !strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") {
+		return nil
+	}
+	zr, err := gzip.NewReader(res.Body)
+	if err != nil {
+		return err
+	}
+	res.Body = gzipBody{Reader: zr, body: res.Body}
+	res.Header.Del("Content-Encoding")
+	res.ContentLength = -1
+	return nil
+}
+
+var urlPattern = regexp.MustCompile(`https?://\S+`)
+
+// redact removes the api key and any URLs from s so it is safe to show to
+// clients or write to logs.
+func redact(s, apiKey string) string {
+	if apiKey != "" {
+		s = strings.ReplaceAll(s, apiKey, "[REDACTED]")
+	}
+	return urlPattern.ReplaceAllString(s, "[URL]")
+}
+
+// upstreamErrorMessage extracts a human readable message from an upstream
+// error body. It understands henrikdev's {"errors":[{"message":...}]} shape as
+// well as a flat {"message":...}. An empty string is returned when nothing
+// usable is found.
+func upstreamErrorMessage(body io.Reader, apiKey string) string {
+	var payload struct {
+		Message string `json:"message"`
+		Errors  []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(io.LimitReader(body, maxUpstreamErrorBody)).Decode(&payload); err != nil {
+		return ""
+	}
+
+	msg := payload.Message
+	if len(payload.Errors) > 0 && payload.Errors[0].Message != "" {
+		msg = payload.Errors[0].Message
+	}
+
+	msg = strings.TrimSpace(redact(msg, apiKey))
+	if len(msg) > maxUpstreamErrorMessage {
+		msg = msg[:maxUpstreamErrorMessage]
+	}
+	return msg
+}
+
+// warmConnectionsPerHost is how many idle connections warmConnections opens
+// to each upstream host.
+const warmConnectionsPerHost = 2
+
+// warmConnections opens a few connections to every configured upstream base
+// URL so the first real requests skip the TCP and TLS handshakes. The
+// requests are unauthenticated HEADs; any response at all leaves a reusable
+// connection in the pool. Failures are only logged.
+func (h *httpMMRClient) warmConnections(ctx context.Context) {
+	hosts := map[string]struct{}{h.cfg.UpstreamBaseURL: {}}
+	for _, u := range h.cfg.RegionBaseURLs {
+		hosts[u] = struct{}{}
+	}
+	if h.cfg.UpstreamFallbackURL != "" {
+		hosts[h.cfg.UpstreamFallbackURL] = struct{}{}
+	}
+
+	var wg sync.WaitGroup
+	for host := range hosts {
+		for range warmConnectionsPerHost {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, err := http.NewRequestWithContext(ctx, http.MethodHead, host, nil)
+				if err != nil {
+					return
+				}
+				addUpstreamHeaders(req, h.cfg.UpstreamHeaders)
+				res, err := h.client.Do(req)
+				if err != nil {
+					h.logger.Debug("Connection warmup failed", slog.String("host", host), slog.String("error", err.Error()))
+					return
+				}
+				res.Body.Close()
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// warmInBackground runs warmConnections on the background group when
+// WARM_CONNECTIONS is set, bounded by WARM_CONNECTIONS_TIMEOUT, so startup
+// never waits on it.
+func (s *Server) warmInBackground(upstream *httpMMRClient) {
+	if !s.cfg.WarmConnections || s.cfg.Offline {
+		return
+	}
+	s.background.goFunc(func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, s.cfg.WarmConnectionsTimeout)
+		defer cancel()
+		upstream.warmConnections(ctx)
+	})
+}
+
+// errAPIKeyRejected is returned by probeAPIKey when henrikdev refuses the key.
+var errAPIKeyRejected = errors.New("api key rejected by upstream")
+
+// probeAPIKey makes one cheap authenticated upstream call to confirm the api
+// key is accepted. Only a 401 or 403 counts as rejection; other failures are
+// returned as-is so callers can tell a bad key from an unreachable upstream.
+func (h *httpMMRClient) probeAPIKey(ctx context.Context) error {
+	res, err := h.fetchUpstream(ctx, "", "/valorant/v1/status/eu")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: status %d", errAPIKeyRejected, res.StatusCode)
+	}
+	return nil
+}
+
+// checkAPIKey runs the startup probe within cfg.StartupProbeTimeout and logs
+// its outcome. A rejected key is logged as an error and, under
+// STRICT_STARTUP, returned so startup can abort; any other probe failure is
+// only a warning.
+func checkAPIKey(cfg Config, logger *slog.Logger, upstream *httpMMRClient) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.StartupProbeTimeout)
+	err := upstream.probeAPIKey(ctx)
+	cancel()
+
+	switch {
+	case errors.Is(err, errAPIKeyRejected):
+		logger.Error("VALORANT_API_KEY was rejected by upstream", slog.String("error", err.Error()))
+		if cfg.StrictStartup {
+			return err
+		}
+	case err != nil:
+		logger.Warn("Could not validate VALORANT_API_KEY", slog.String("error", redact(err.Error(), cfg.APIKey)))
+	}
+	return nil
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUpstreamErrorMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"errors array", `{"errors":[{"message":"Player not found"}]}`, "Player not found"},
+		{"flat message", `{"message":"Invalid region"}`, "Invalid region"},
+		{"errors win over message", `{"message":"outer","errors":[{"message":"inner"}]}`, "inner"},
+		{"api key redacted", `{"message":"bad key test-key"}`, "bad key [REDACTED]"},
+		{"urls redacted", `{"message":"see https://x.y/?api_key=abc for help"}`, "see [URL] for help"},
+		{"not json", `<html>`, ""},
+		{"no message", `{"status":400}`, ""},
+		{"truncated", `{"message":"` + strings.Repeat("a", 300) + `"}`, strings.Repeat("a", maxUpstreamErrorMessage)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := upstreamErrorMessage(strings.NewReader(tt.body), "test-key"); got != tt.want {
+				t.Errorf("upstreamErrorMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwardUpstreamErrors(t *testing.T) {
+	for _, forward := range []bool{false, true} {
+		t.Run(map[bool]string{false: "off", true: "on"}[forward], func(t *testing.T) {
+			setVar(t, &forwardUpstreamErrors, forward)
+			cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusBadRequest, `{"errors":[{"message":"Invalid tag test-key, see https://docs.henrikdev.xyz"}]}`)
+			})
+			s := newHTTPServer(t, cfg)
+
+			w := serve(s.Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", w.Code)
+			}
+			var body map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			msg, ok := body["upstream_message"]
+			if !forward {
+				if ok {
+					t.Fatalf("upstream_message = %v, want none", msg)
+				}
+				return
+			}
+			if want := "Invalid tag [REDACTED], see [URL]"; msg != want {
+				t.Errorf("upstream_message = %v, want %q", msg, want)
+			}
+		})
+	}
+}
+
+func TestRegionBaseURLOverride(t *testing.T) {
+	var primary, override atomic.Int64
+	cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		primary.Add(1)
+		writeJSON(w, http.StatusOK, mmrBody)
+	})
+	eu := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		override.Add(1)
+		writeJSON(w, http.StatusOK, mmrBody)
+	}))
+	t.Cleanup(eu.Close)
+	cfg.RegionBaseURLs = map[string]string{"eu": eu.URL}
+	h := newHTTPServer(t, cfg).Handler()
+
+	tests := []struct {
+		region                    string
+		wantPrimary, wantOverride int64
+	}{
+		{"eu", 0, 1},
+		{"na", 1, 1},
+		{"kr", 2, 1},
+	}
+	for _, tt := range tests {
+		if w := serve(h, http.MethodGet, "/rest/v1/rank/"+tt.region+"/foo/bar", ""); w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200", tt.region, w.Code)
+		}
+		if primary.Load() != tt.wantPrimary || override.Load() != tt.wantOverride {
+			t.Errorf("after %s: primary calls = %d, override calls = %d, want %d and %d",
+				tt.region, primary.Load(), override.Load(), tt.wantPrimary, tt.wantOverride)
+		}
+	}
+}
+
+func TestRegionBaseURLFromEnv(t *testing.T) {
+	t.Setenv("UPSTREAM_BASE_URL", "https://default.example/")
+	t.Setenv("UPSTREAM_BASE_URL_EU", "https://eu.example/")
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for region, want := range map[string]string{"eu": "https://eu.example", "na": "https://default.example"} {
+		if got := baseURLFor(cfg, region); got != want {
+			t.Errorf("baseURLFor(%s) = %q, want %q", region, got, want)
+		}
+	}
+}
+
+func TestCheckAPIKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		hang    bool
+		strict  bool
+		wantErr bool
+		// wantLog is the level of the one line logged, empty for none.
+		wantLog string
+	}{
+		{"accepted", http.StatusOK, false, true, false, ""},
+		{"rejected", http.StatusUnauthorized, false, false, false, "ERROR"},
+		{"rejected under strict startup", http.StatusUnauthorized, false, true, true, "ERROR"},
+		{"forbidden under strict startup", http.StatusForbidden, false, true, true, "ERROR"},
+		{"upstream down is not a bad key", http.StatusServiceUnavailable, false, true, false, ""},
+		{"probe timeout", http.StatusOK, true, true, false, "WARN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				if tt.hang {
+					<-release
+				}
+				writeJSON(w, tt.status, `{"status":0}`)
+			})
+			defer close(release)
+			cfg.StrictStartup = tt.strict
+			cfg.StartupProbeTimeout = 50 * time.Millisecond
+			var logs bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+			err := checkAPIKey(cfg, logger, newHTTPMMRClient(cfg, discardLogger()))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkAPIKey() = %v, want an error: %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, errAPIKeyRejected) {
+				t.Errorf("checkAPIKey() = %v, want errAPIKeyRejected", err)
+			}
+
+			var line struct{ Level, Msg string }
+			if logs.Len() > 0 {
+				if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+					t.Fatalf("decoding log %q: %v", logs.String(), err)
+				}
+			}
+			if line.Level != tt.wantLog {
+				t.Errorf("logged %q, want one line at level %q", logs.String(), tt.wantLog)
+			}
+			if line.Msg != "" && !strings.Contains(line.Msg, "VALORANT_API_KEY") {
+				t.Errorf("log message %q does not name VALORANT_API_KEY", line.Msg)
+			}
+			if strings.Contains(logs.String(), cfg.APIKey) {
+				t.Errorf("log %q leaks the api key", logs.String())
+			}
+		})
+	}
+}
+
+// gzipped returns s gzip compressed.
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if _, err := io.WriteString(zw, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestDecompressBody(t *testing.T) {
+	tests := []struct {
+		name         string
+		encoding     string
+		uncompressed bool
+		body         []byte
+		want         string
+		wantErr      bool
+	}{
+		{"gzip", "gzip", false, gzipped(t, mmrBody), mmrBody, false},
+		{"encoding is case insensitive", "GZIP", false, gzipped(t, mmrBody), mmrBody, false},
+		{"identity", "", false, []byte(mmrBody), mmrBody, false},
+		{"already decompressed by the transport", "gzip", true, []byte(mmrBody), mmrBody, false},
+		{"corrupt gzip", "gzip", false, []byte("not gzip"), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &http.Response{
+				Header:       http.Header{"Content-Encoding": {tt.encoding}},
+				Body:         io.NopCloser(bytes.NewReader(tt.body)),
+				Uncompressed: tt.uncompressed,
+			}
+			err := decompressBody(res)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decompressBody() = %v, want an error: %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got, err := io.ReadAll(res.Body)
+			if err != nil || string(got) != tt.want {
+				t.Errorf("body = %q, %v, want %q", got, err, tt.want)
+			}
+			if err := res.Body.Close(); err != nil {
+				t.Errorf("Close() = %v", err)
+			}
+		})
+	}
+}
+
+func TestGzipUpstream(t *testing.T) {
+	body := gzipped(t, mmrBody)
+	cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(body)
+	})
+	// A transport that leaves compression alone hands over the raw encoding.
+	client := newHTTPMMRClient(cfg, discardLogger())
+	client.client.Transport = &http.Transport{DisableCompression: true}
+	s := NewServer(cfg, discardLogger(), client)
+
+	w := serve(s.Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if msg := decodeBody(t, w.Body.Bytes())["message"]; msg != "Platinum 1 [45RR] | Peak: Diamond 2" {
+		t.Errorf("message = %v, want the decoded rank", msg)
+	}
+}
+
+func TestWarmConnections(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		offline   bool
+		wantHeads int64
+	}{
+		{"enabled", true, false, int64(warmConnectionsPerHost)},
+		{"disabled", false, false, 0},
+		{"offline", true, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var heads atomic.Int64
+			cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead && r.URL.Query().Get("api_key") == "" {
+					heads.Add(1)
+				}
+			})
+			cfg.WarmConnections = tt.enabled
+			cfg.Offline = tt.offline
+			cfg.WarmConnectionsTimeout = time.Second
+			s := NewServer(cfg, discardLogger(), nil)
+
+			s.warmInBackground(newHTTPMMRClient(cfg, discardLogger()))
+			s.background.wg.Wait()
+			if n := heads.Load(); n != tt.wantHeads {
+				t.Errorf("warmup HEADs = %d, want %d", n, tt.wantHeads)
+			}
+		})
+	}
+}
+
+func TestWarmConnectionsDoesNotBlock(t *testing.T) {
+	cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	cfg.WarmConnections = true
+	cfg.WarmConnectionsTimeout = 50 * time.Millisecond
+	s := NewServer(cfg, discardLogger(), nil)
+
+	start := time.Now()
+	s.warmInBackground(newHTTPMMRClient(cfg, discardLogger()))
+	if d := time.Since(start); d > 10*time.Millisecond {
+		t.Errorf("warmInBackground took %v, want it to return at once", d)
+	}
+	// A hung upstream is given up on after the timeout.
+	s.background.wg.Wait()
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("warmup ran for %v, want it cut off after 50ms", d)
+	}
+}
+
+func TestUpstreamFailover(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	tests := []struct {
+		name string
+		// primary answers with status, hangs when zero.
+		primary      int
+		unreachable  bool
+		wantStatus   int
+		wantFallback int64
+	}{
+		{"primary fine", http.StatusOK, false, http.StatusOK, 0},
+		{"primary 5xx", http.StatusBadGateway, false, http.StatusOK, 1},
+		{"primary unreachable", 0, true, http.StatusOK, 1},
+		{"primary hangs", 0, false, http.StatusOK, 1},
+		{"player not found is final", http.StatusNotFound, false, http.StatusNotFound, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &maxUpstreamRetries, 0)
+			// Half the budget, left after a hung primary, must exceed the minimum
+			// headroom for the fallback to be tried.
+			setVar(t, &requestBudget, 2*time.Second)
+			cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				if tt.primary == 0 {
+					<-r.Context().Done()
+					return
+				}
+				writeJSON(w, tt.primary, mmrBody)
+			})
+			if tt.unreachable {
+				cfg.UpstreamBaseURL = down.URL
+			}
+			var fallbackCalls atomic.Int64
+			fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fallbackCalls.Add(1)
+				writeJSON(w, http.StatusOK, mmrBody)
+			}))
+			defer fallback.Close()
+			cfg.UpstreamFallbackURL = fallback.URL
+
+			start := time.Now()
+			w := serve(newHTTPServer(t, cfg).Handler(), http.MethodGet, "/rest/v1/rank/eu/foo/bar", "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if n := fallbackCalls.Load(); n != tt.wantFallback {
+				t.Errorf("fallback calls = %d, want %d", n, tt.wantFallback)
+			}
+			// A hung primary only gets half the budget.
+			if d := time.Since(start); d > requestBudget {
+				t.Errorf("took %v, want the failover within the %v budget", d, requestBudget)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// headerNamePattern is the RFC 9110 token grammar header names must match.
+var headerNamePattern = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// parseUpstreamHeaders parses UPSTREAM_HEADERS, comma separated Name=value
+// pairs. Names must be valid header names other than Host, which Go takes
+// from the URL, and values cannot contain line breaks.
+func parseUpstreamHeaders(list string) (http.Header, error) {
+	headers := http.Header{}
+	for _, pair := range strings.Split(list, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || !headerNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid UPSTREAM_HEADERS entry %q, expected Name=value", pair)
+		}
+		if strings.EqualFold(name, "Host") {
+			return nil, fmt.Errorf("UPSTREAM_HEADERS cannot set %s", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("invalid UPSTREAM_HEADERS value for %s", name)
+		}
+		headers.Set(name, value)
+	}
+	return headers, nil
+}
+
+// addUpstreamHeaders sets the configured upstream headers on req.
+func addUpstreamHeaders(req *http.Request, headers http.Header) {
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseUpstreamHeaders(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    http.Header
+		wantErr bool
+	}{
+		{"", http.Header{}, false},
+		{"X-Proxy-Auth=secret", http.Header{"X-Proxy-Auth": {"secret"}}, false},
+		{" x-proxy-auth = secret , X-Tenant=a=b,", http.Header{"X-Proxy-Auth": {"secret"}, "X-Tenant": {"a=b"}}, false},
+		{"X-Empty=", http.Header{"X-Empty": {""}}, false},
+		{"X-Proxy-Auth", nil, true},
+		{"=secret", nil, true},
+		{"Bad Name=x", nil, true},
+		{"X-Bad:Name=x", nil, true},
+		{"Host=evil.example", nil, true},
+		{"host=evil.example", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseUpstreamHeaders(tt.list)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseUpstreamHeaders(%q) error = %v, want an error: %v", tt.list, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !maps.EqualFunc(got, tt.want, slices.Equal) {
+			t.Errorf("parseUpstreamHeaders(%q) = %v, want %v", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestUpstreamHeadersOnOutboundRequests(t *testing.T) {
+	t.Setenv("UPSTREAM_HEADERS", "X-Proxy-Auth=secret,X-Tenant=overlay")
+	setVar(t, &maxUpstreamRetries, 0)
+	var (
+		mu   sync.Mutex
+		seen = map[string]http.Header{}
+	)
+	record := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			seen[name+" "+strings.Split(r.URL.Path, "/")[3]] = r.Header.Clone()
+			mu.Unlock()
+			if name == "primary" && strings.Contains(r.URL.Path, "/mmr-history/") {
+				writeJSON(w, http.StatusBadGateway, `{"status":502}`)
+				return
+			}
+			writeJSON(w, http.StatusOK, mmrBody)
+		}
+	}
+	cfg := newUpstream(t, record("primary"))
+	fallback := httptest.NewServer(record("fallback"))
+	t.Cleanup(fallback.Close)
+	cfg.UpstreamFallbackURL = fallback.URL
+	h := newHTTPServer(t, cfg).Handler()
+
+	if w := serve(h, http.MethodGet, "/rest/v1/rank/eu/foo/bar?level=true&recent=true", ""); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// The history call failed over, so the fallback saw headers too.
+	if want := []string{"fallback mmr-history", "primary account", "primary mmr", "primary mmr-history"}; !slices.Equal(slices.Sorted(maps.Keys(seen)), want) {
+		t.Errorf("upstream calls = %q, want %q", slices.Sorted(maps.Keys(seen)), want)
+	}
+	for call, header := range seen {
+		if header.Get("X-Proxy-Auth") != "secret" || header.Get("X-Tenant") != "overlay" {
+			t.Errorf("%s: headers %v, want the configured ones", call, header)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// upstreamQuota is the latest quota henrikdev reported, shared by the whole
+// process like upstreamPause. Whether it counts as low is up to each caller's
+// UPSTREAM_QUOTA_LOW_WATER.
+var upstreamQuota quotaTracker
+
+type quotaTracker struct {
+	mu        sync.Mutex
+	seen      bool
+	limit     int
+	remaining int
+	resetAt   time.Time
+	// nextAllowed is the slot after the last one handed out by delay.
+	nextAllowed time.Time
+}
+
+// observe records the x-ratelimit-limit, -remaining and -reset headers of an
+// upstream response. Reset is given in seconds from now. Responses without
+// the headers leave the last known quota alone.
+func (q *quotaTracker) observe(h http.Header) {
+	remaining, err := strconv.Atoi(h.Get("X-Ratelimit-Remaining"))
+	if err != nil {
+		return
+	}
+	limit, _ := strconv.Atoi(h.Get("X-Ratelimit-Limit"))
+	reset, _ := strconv.Atoi(h.Get("X-Ratelimit-Reset"))
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.seen = true
+	q.limit = limit
+	q.remaining = remaining
+	q.resetAt = time.Now().Add(time.Duration(reset) * time.Second)
+}
+
+// low reports whether the remaining quota has reached lowWater in a window
+// that has not reset yet. A lowWater of zero never counts as low.
+func (q *quotaTracker) low(lowWater int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.lowLocked(time.Now(), lowWater)
+}
+
+func (q *quotaTracker) lowLocked(now time.Time, lowWater int) bool {
+	return lowWater > 0 && q.seen && q.remaining <= lowWater && now.Before(q.resetAt)
+}
+
+// delay returns how long the next upstream call should wait, and whether it
+// may: a wait that would leave less than minUpstreamHeadroom of ctx's
+// deadline is refused. While quota is low the calls left are spread evenly
+// over the rest of the window, each call reserving the slot after the last
+// one handed out so concurrent callers do not all fire together. Refused
+// calls reserve nothing. With no calls left the call waits for the reset.
+func (q *quotaTracker) delay(ctx context.Context, lowWater int) (time.Duration, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	if !q.lowLocked(now, lowWater) {
+		return 0, true
+	}
+	untilReset := q.resetAt.Sub(now)
+	if q.remaining <= 0 {
+		return untilReset, hasHeadroom(ctx, untilReset+minUpstreamHeadroom)
+	}
+	slot := q.nextAllowed
+	if slot.Before(now) {
+		slot = now
+	}
+	slot = slot.Add(untilReset / time.Duration(q.remaining+1))
+	wait := slot.Sub(now)
+	if !hasHeadroom(ctx, wait+minUpstreamHeadroom) {
+		return wait, false
+	}
+	q.nextAllowed = slot
+	return wait, true
+}
+
+// quotaSnapshot is the last reported upstream quota, as shown by
+// /healthz/detailed.
+type quotaSnapshot struct {
+	Limit     int   `json:"limit"`
+	Remaining int   `json:"remaining"`
+	ResetInMs int64 `json:"reset_in_ms"`
+	Low       bool  `json:"low"`
+}
+
+// snapshot returns the last reported quota, judged low against lowWater, or
+// nil before any response carried one.
+func (q *quotaTracker) snapshot(lowWater int) *quotaSnapshot {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.seen {
+		return nil
+	}
+	now := time.Now()
+	return &quotaSnapshot{
+		Limit:     q.limit,
+		Remaining: q.remaining,
+		ResetInMs: max(q.resetAt.Sub(now), 0).Milliseconds(),
+		Low:       q.lowLocked(now, lowWater),
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// quotaHeaders are x-ratelimit headers reporting remaining of limit calls
+// left, resetting in reset seconds.
+func quotaHeaders(limit, remaining, reset string) http.Header {
+	h := http.Header{}
+	h.Set("X-Ratelimit-Limit", limit)
+	h.Set("X-Ratelimit-Remaining", remaining)
+	h.Set("X-Ratelimit-Reset", reset)
+	return h
+}
+
+func TestQuotaTrackerLow(t *testing.T) {
+	tests := []struct {
+		name     string
+		lowWater int
+		headers  http.Header
+		want     bool
+	}{
+		{"pacing disabled", 0, quotaHeaders("100", "1", "60"), false},
+		{"no headers seen", 5, http.Header{}, false},
+		{"above low water", 5, quotaHeaders("100", "6", "60"), false},
+		{"at low water", 5, quotaHeaders("100", "5", "60"), true},
+		{"exhausted", 5, quotaHeaders("100", "0", "60"), true},
+		{"window already reset", 5, quotaHeaders("100", "0", "0"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var q quotaTracker
+			q.observe(tt.headers)
+			if got := q.low(tt.lowWater); got != tt.want {
+				t.Errorf("low() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuotaTrackerDelayReservesSlots(t *testing.T) {
+	var q quotaTracker
+	// Four calls left over 10s: one every 2s.
+	q.observe(quotaHeaders("100", "4", "10"))
+
+	var (
+		mu     sync.Mutex
+		delays []time.Duration
+		wg     sync.WaitGroup
+	)
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d, _ := q.delay(context.Background(), 10)
+			mu.Lock()
+			delays = append(delays, d)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	slices.Sort(delays)
+
+	const slop = 100 * time.Millisecond
+	for i, d := range delays {
+		want := time.Duration(i+1) * 2 * time.Second
+		if d < want-slop || d > want+slop {
+			t.Errorf("delays = %v, want slots 2s apart starting at 2s", delays)
+			break
+		}
+	}
+}
+
+func TestQuotaTrackerDelayExhausted(t *testing.T) {
+	var q quotaTracker
+	q.observe(quotaHeaders("100", "0", "30"))
+
+	if d, _ := q.delay(context.Background(), 10); d < 29*time.Second || d > 30*time.Second {
+		t.Errorf("delay() = %v, want the 30s until reset", d)
+	}
+}
+
+func TestQuotaTrackerDelayNotLow(t *testing.T) {
+	var q quotaTracker
+	q.observe(quotaHeaders("100", "50", "30"))
+
+	if d, _ := q.delay(context.Background(), 10); d != 0 {
+		t.Errorf("delay() = %v, want 0 with quota to spare", d)
+	}
+}
+
+func TestQuotaTrackerDelayRefusedReservesNothing(t *testing.T) {
+	var q quotaTracker
+	// One call left over 10s: the slot is 5s out.
+	q.observe(quotaHeaders("100", "1", "10"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for range 3 {
+		if _, ok := q.delay(ctx, 10); ok {
+			t.Fatal("delay() allowed a wait past the deadline")
+		}
+	}
+	d, ok := q.delay(context.Background(), 10)
+	if !ok || d < 4900*time.Millisecond || d > 5*time.Second {
+		t.Errorf("delay() = %v, %v after refusals, want the first slot at 5s", d, ok)
+	}
+}
+
+func TestLowQuotaThrottlesUpstream(t *testing.T) {
+	tests := []struct {
+		name      string
+		headers   http.Header
+		wantCode  string
+		wantCalls int64
+		// minWait and maxWait bound how long the second lookup takes.
+		minWait, maxWait time.Duration
+	}{
+		{"plenty left", quotaHeaders("100", "50", "1"), "", 2, 0, 300 * time.Millisecond},
+		{"low", quotaHeaders("100", "1", "1"), "", 2, 300 * time.Millisecond, 900 * time.Millisecond},
+		{"exhausted", quotaHeaders("100", "0", "60"), codeUpstreamPaused, 1, 0, 300 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int64
+			cfg := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				for k, v := range tt.headers {
+					w.Header()[k] = v
+				}
+				writeJSON(w, http.StatusOK, mmrBody)
+			})
+			cfg.ClientAPIKey = "ops"
+			cfg.UpstreamQuotaLowWater = 5
+			h := newHTTPServer(t, cfg).Handler()
+
+			if w := serve(h, http.MethodGet, "/rest/v1/rank/eu/first/t", ""); w.Code != http.StatusOK {
+				t.Fatalf("first lookup = %d, want 200: %s", w.Code, w.Body)
+			}
+			start := time.Now()
+			w := serve(h, http.MethodGet, "/rest/v1/rank/eu/second/t", "")
+			took := time.Since(start)
+
+			if tt.wantCode == "" && w.Code != http.StatusOK {
+				t.Errorf("second lookup = %d, want 200: %s", w.Code, w.Body)
+			}
+			if tt.wantCode != "" {
+				if body := decodeBody(t, w.Body.Bytes()); w.Code != http.StatusServiceUnavailable || body["code"] != tt.wantCode {
+					t.Errorf("second lookup = %d %v, want 503 %s", w.Code, body["code"], tt.wantCode)
+				}
+				if w.Header().Get("Retry-After") == "" {
+					t.Error("refused lookup has no Retry-After")
+				}
+			}
+			if took < tt.minWait || took > tt.maxWait {
+				t.Errorf("second lookup took %v, want between %v and %v", took, tt.minWait, tt.maxWait)
+			}
+			if n := calls.Load(); n != tt.wantCalls {
+				t.Errorf("upstream calls = %d, want %d", n, tt.wantCalls)
+			}
+
+			w = serve(h, http.MethodGet, "/healthz/detailed", "", "Authorization", "Bearer ops")
+			quota, _ := decodeBody(t, w.Body.Bytes())["upstream"].(map[string]interface{})["quota"].(map[string]interface{})
+			if wantLow := tt.name != "plenty left"; quota["low"] != wantLow || quota["limit"] != float64(100) {
+				t.Errorf("reported quota = %v, want limit 100 and low %v", quota, wantLow)
+			}
+		})
+	}
+}